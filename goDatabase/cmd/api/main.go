@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
+
     "goDatabase/internal/auth"
+	"goDatabase/internal/kms"
 	"goDatabase/internal/server"
 )
 
 func main() {
 
-    auth.NewAuth()
+	masterKeys, err := kms.FromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to open master keys: %v", err)
+	}
+
+    auth.NewAuth(masterKeys)
 
-	server := server.NewServer()
+	server := server.NewServer(masterKeys)
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
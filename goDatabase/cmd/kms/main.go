@@ -0,0 +1,100 @@
+// Command kms manages the master keys configured through KMS_BACKEND.
+//
+//	go run ./cmd/kms init      create the keyring file with a random key
+//	go run ./cmd/kms import    create the keyring file from ENCRYPTION_MASTER_KEYS
+//	go run ./cmd/kms current   print the current master key ID
+//	go run ./cmd/kms rotate    create a new master key version
+//	go run ./cmd/kms rotate-session
+//	                           create a new session key, e.g. from cron
+//
+// A running server notices a rotation within a few minutes: it rewraps the
+// stored data and vault keys with a new master key version, and seals new
+// cookies with a new session key while older ones stay valid.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	"goDatabase/internal/auth"
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/kms"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) != 2 {
+		log.Fatal("usage: kms init|import|current|rotate|rotate-session")
+	}
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "init", "import":
+		path := os.Getenv("KMS_KEYRING_FILE")
+		if path == "" {
+			path = kms.DefaultKeyringFile
+		}
+		var keys []envelope.MasterKey
+		if os.Args[1] == "import" {
+			spec := os.Getenv("ENCRYPTION_MASTER_KEYS")
+			if spec == "" {
+				log.Fatal("ENCRYPTION_MASTER_KEYS is not set")
+			}
+			var err error
+			if keys, err = envelope.ParseMasterKeys(spec); err != nil {
+				log.Fatal(err)
+			}
+		}
+		f, err := kms.CreateFile(path, keys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		current, err := f.CurrentID(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Created %s with current key %s\n", path, current)
+
+	case "current":
+		k := open(ctx)
+		current, err := k.CurrentID(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(current)
+
+	case "rotate":
+		k := open(ctx)
+		current, err := k.Rotate(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Rotated, current key is %s\n", current)
+
+	case "rotate-session":
+		keys, err := auth.RotateSessionKeys(ctx, open(ctx), auth.SessionKeysPath())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Rotated session keys, %d still open cookies\n", len(keys))
+
+	default:
+		log.Fatalf("unknown command %q, expected init, import, current, rotate or rotate-session", os.Args[1])
+	}
+}
+
+// open opens the configured KMS.
+func open(ctx context.Context) kms.KMS {
+	k, err := kms.FromEnv(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if k == nil {
+		log.Fatal("no master keys configured, set KMS_BACKEND or ENCRYPTION_MASTER_KEYS")
+	}
+	return k
+}
@@ -0,0 +1,309 @@
+// Package archive reads zip, tar and tar.gz archives stored as objects.
+// Archives are read through an io.ReaderAt so zip and tar files only fetch
+// the parts they need, and every entry name and size is checked before it is
+// handed out.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Format is an archive format.
+type Format int
+
+const (
+	Unknown Format = iota
+	Zip
+	Tar
+	TarGz
+)
+
+// Detect tells the format of an archive from its name.
+func Detect(name string) Format {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return Zip
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarGz
+	case strings.HasSuffix(name, ".tar"):
+		return Tar
+	}
+	return Unknown
+}
+
+// BaseName strips the archive extension from a file name.
+func BaseName(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// Limits bound what an archive may expand to, against zip bombs.
+type Limits struct {
+	MaxEntries int   // files and folders
+	MaxSize    int64 // total uncompressed bytes
+	MaxRatio   int64 // uncompressed bytes per archive byte
+}
+
+var (
+	ErrUnsupported    = errors.New("unsupported archive format")
+	ErrTooManyEntries = errors.New("archive has too many entries")
+	ErrTooLarge       = errors.New("archive expands to more than the allowed size")
+	ErrRatio          = errors.New("archive compression ratio is suspiciously high")
+	ErrNotFound       = errors.New("entry not found in archive")
+)
+
+// Entry is a file or folder inside an archive.
+type Entry struct {
+	Name     string    `json:"name"` // cleaned relative path, without trailing slash
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	IsDir    bool      `json:"isDir"`
+}
+
+// CleanName turns an entry name into a safe relative path. Names that are
+// absolute or climb out of the extraction folder ("zip slip") are rejected.
+func CleanName(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", fmt.Errorf("absolute path %q in archive", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path %q leaves the extraction folder", name)
+		}
+	}
+
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return "", fmt.Errorf("empty path in archive")
+	}
+	if strings.ContainsFunc(name, unicode.IsControl) {
+		return "", fmt.Errorf("path %q contains control characters", name)
+	}
+	return name, nil
+}
+
+// Scan lists the entries of an archive after checking them against limits.
+// Symlinks and other special entries are left out. Only the parts of a zip
+// or tar file holding entry headers are read; tar.gz has to be read through.
+func Scan(format Format, r io.ReaderAt, size int64, limits Limits) ([]Entry, int64, error) {
+	var entries []Entry
+	var total int64
+	err := walk(format, r, size, limits, nil, func(e Entry, _ io.Reader) error {
+		entries = append(entries, e)
+		total += e.Size
+		return nil
+	})
+	return entries, total, err
+}
+
+// Walk calls fn for every entry of an archive with a reader for its content,
+// or nil for folders. Limits are enforced on the bytes actually decompressed,
+// so archives that lie about their sizes are stopped too.
+func Walk(format Format, r io.ReaderAt, size int64, limits Limits, fn func(Entry, io.Reader) error) error {
+	return walk(format, r, size, limits, func(e Entry) bool { return !e.IsDir }, fn)
+}
+
+// errFound stops a walk once Find has seen its entry.
+var errFound = errors.New("found")
+
+// Find calls fn with the content of the file called name in an archive, or
+// returns ErrNotFound.
+func Find(format Format, r io.ReaderAt, size int64, limits Limits, name string, fn func(Entry, io.Reader) error) error {
+	name, err := CleanName(name)
+	if err != nil {
+		return ErrNotFound
+	}
+	want := func(e Entry) bool { return !e.IsDir && e.Name == name }
+	err = walk(format, r, size, limits, want, func(e Entry, data io.Reader) error {
+		if data == nil {
+			return nil
+		}
+		if err := fn(e, data); err != nil {
+			return err
+		}
+		return errFound
+	})
+	switch err {
+	case errFound:
+		return nil
+	case nil:
+		return ErrNotFound
+	}
+	return err
+}
+
+// walk goes through the entries of an archive, calling fn for each. Entries
+// for which wantData returns true get a reader for their content.
+func walk(format Format, r io.ReaderAt, size int64, limits Limits, wantData func(Entry) bool, fn func(Entry, io.Reader) error) error {
+	counter := &limitCounter{limits: limits, archiveSize: size}
+
+	switch format {
+	case Zip:
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return fmt.Errorf("reading zip: %v", err)
+		}
+		for _, f := range zr.File {
+			mode := f.Mode()
+			if !mode.IsDir() && !mode.IsRegular() {
+				continue
+			}
+			name, err := CleanName(f.Name)
+			if err != nil {
+				return err
+			}
+			e := Entry{Name: name, Size: int64(f.UncompressedSize64), Modified: f.Modified, IsDir: mode.IsDir()}
+			if e.IsDir {
+				e.Size = 0
+			}
+			if err := counter.add(e); err != nil {
+				return err
+			}
+			// Per entry ratio, for archives small enough to pass the total one
+			if !e.IsDir && limits.MaxRatio > 0 && e.Size > 1<<20 && e.Size/max(int64(f.CompressedSize64), 1) > limits.MaxRatio {
+				return ErrRatio
+			}
+			if wantData == nil || !wantData(e) {
+				if err := fn(e, nil); err != nil {
+					return err
+				}
+				continue
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("opening %s: %v", name, err)
+			}
+			err = fn(e, counter.reader(rc, e.Size))
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case Tar, TarGz:
+		// A plain tar is read through a seekable section, which lets the
+		// tar reader skip over entry data instead of reading it
+		var src io.Reader = io.NewSectionReader(r, 0, size)
+		if format == TarGz {
+			gz, err := gzip.NewReader(bufio.NewReaderSize(src, 1<<20))
+			if err != nil {
+				return fmt.Errorf("reading gzip: %v", err)
+			}
+			defer gz.Close()
+			src = gz
+		}
+
+		tr := tar.NewReader(src)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("reading tar: %v", err)
+			}
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+				continue
+			}
+			name, err := CleanName(hdr.Name)
+			if err != nil {
+				return err
+			}
+			e := Entry{Name: name, Size: hdr.Size, Modified: hdr.ModTime, IsDir: hdr.Typeflag == tar.TypeDir}
+			if e.IsDir {
+				e.Size = 0
+			}
+			if err := counter.add(e); err != nil {
+				return err
+			}
+			var data io.Reader
+			if wantData != nil && wantData(e) {
+				data = counter.reader(tr, e.Size)
+			}
+			if err := fn(e, data); err != nil {
+				return err
+			}
+		}
+	}
+	return ErrUnsupported
+}
+
+// limitCounter enforces Limits over the entries and bytes of one archive.
+type limitCounter struct {
+	limits      Limits
+	archiveSize int64
+	entries     int
+	declared    int64
+	read        int64
+}
+
+func (lc *limitCounter) add(e Entry) error {
+	lc.entries++
+	if lc.limits.MaxEntries > 0 && lc.entries > lc.limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+	lc.declared += e.Size
+	return lc.check(lc.declared)
+}
+
+func (lc *limitCounter) check(n int64) error {
+	if lc.limits.MaxSize > 0 && n > lc.limits.MaxSize {
+		return ErrTooLarge
+	}
+	if lc.limits.MaxRatio > 0 && n > 1<<20 && n/max(lc.archiveSize, 1) > lc.limits.MaxRatio {
+		return ErrRatio
+	}
+	return nil
+}
+
+// reader wraps the content of an entry that declared size bytes, failing if
+// it turns out longer or pushes the archive past its limits.
+func (lc *limitCounter) reader(r io.Reader, size int64) io.Reader {
+	return &countingReader{r: r, lc: lc, left: size}
+}
+
+type countingReader struct {
+	r    io.Reader
+	lc   *limitCounter
+	left int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	if cr.left <= 0 {
+		// Anything past the declared size means the header lied
+		var one [1]byte
+		if n, _ := cr.r.Read(one[:]); n > 0 {
+			return 0, fmt.Errorf("entry is larger than its declared size")
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > cr.left {
+		p = p[:cr.left]
+	}
+	n, err := cr.r.Read(p)
+	cr.left -= int64(n)
+	cr.lc.read += int64(n)
+	if checkErr := cr.lc.check(cr.lc.read); checkErr != nil {
+		return n, checkErr
+	}
+	return n, err
+}
@@ -1,8 +1,12 @@
 package auth
 
 import (
+    "context"
     "log"
     "os"
+    "time"
+
+    "goDatabase/internal/kms"
 
     "github.com/gorilla/sessions"
     "github.com/joho/godotenv"
@@ -21,7 +25,10 @@ var SessionName = "session-name"
 var Store *sessions.CookieStore
 
 
-func NewAuth() {
+// NewAuth sets up the session store and OAuth providers. With masterKeys the
+// session keys are kept wrapped in SESSION_KEYS_FILE, otherwise they are read
+// from SESSION_SECRETS.
+func NewAuth(masterKeys kms.KMS) {
     err := godotenv.Load()
     if err != nil {
         log.Fatal("Error loading .env file")
@@ -29,18 +36,40 @@ func NewAuth() {
 
     googleClientId := os.Getenv("GOOGLE_CLIENT_ID")
     googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-    sessionSecret := os.Getenv("SESSION_SECRET")
     //error handling
-    if googleClientId == "" || googleClientSecret == "" || sessionSecret == "" {
+    if googleClientId == "" || googleClientSecret == "" {
         log.Fatal("Environment variables not set properly")
     }
 
+    // Cookies are sealed with the current session key and opened with any
+    // key of the ring, so rotating keys does not log anyone out
+    var keys []SessionKey
+    if masterKeys != nil {
+        keys, err = LoadSessionKeys(context.Background(), masterKeys, SessionKeysPath())
+    } else {
+        keys, err = SessionKeysFromEnv()
+    }
+    if err != nil {
+        log.Fatalf("failed to load session keys: %v", err)
+    }
 
-    Store = sessions.NewCookieStore([]byte(sessionSecret))
+    var ring *SessionKeyRing
+    Store, ring = NewSessionStore(keys)
     if Store == nil {
         log.Fatalf("failed to create session store")
     }
 
+    if masterKeys != nil {
+        var rotateEvery time.Duration
+        if interval := os.Getenv("SESSION_ROTATION_INTERVAL"); interval != "" {
+            rotateEvery, err = time.ParseDuration(interval)
+            if err != nil {
+                log.Fatalf("invalid SESSION_ROTATION_INTERVAL: %v", err)
+            }
+        }
+        go watchSessionKeys(masterKeys, SessionKeysPath(), ring, keys, rotateEvery)
+    }
+
     Store.Options = &sessions.Options{
         Path:     "/",
         MaxAge:   86400 * 30,
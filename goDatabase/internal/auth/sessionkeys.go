@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/hkdf"
+
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/kms"
+)
+
+// DefaultSessionKeysFile is where the wrapped session keys live when
+// SESSION_KEYS_FILE is not set.
+const DefaultSessionKeysFile = "session_keys.json"
+
+const (
+	// How long a replaced session key still opens cookies; matches the
+	// cookie lifetime
+	SessionKeyRetention = 30 * 24 * time.Hour
+	// How often the session keys file is checked for rotations
+	sessionKeysCheckInterval = 5 * time.Minute
+)
+
+// sessionSecretContext binds a wrapped session secret to its purpose, so other
+// material wrapped by the same KMS cannot be passed off as one.
+var sessionSecretContext = []byte("session secret")
+
+// SessionKey is one secret of the session key ring. Cookies are sealed with
+// the current key, the first of the ring; the others only open cookies sealed
+// before a rotation.
+type SessionKey struct {
+	Secret  []byte
+	Created time.Time
+	Retired time.Time // when a newer key replaced it, zero for the current key
+	Legacy  bool      // a single SESSION_SECRET from before the key ring, which only signed cookies
+}
+
+// Pair returns the hash and block keys of the secret, derived with HKDF so
+// cookies are both authenticated and encrypted. A legacy secret is the hash
+// key as it is, with no block key, so the cookies it signed still open.
+func (k SessionKey) Pair() (hashKey, blockKey []byte) {
+	if k.Legacy {
+		return k.Secret, nil
+	}
+	return deriveKey(k.Secret, "session hash key", 64), deriveKey(k.Secret, "session block key", 32)
+}
+
+func deriveKey(secret []byte, purpose string, size int) []byte {
+	key := make([]byte, size)
+	io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(purpose)), key)
+	return key
+}
+
+// SessionKeyPairs returns the hash and block key pairs of keys in the order
+// sessions.NewCookieStore expects them.
+func SessionKeyPairs(keys []SessionKey) [][]byte {
+	pairs := make([][]byte, 0, 2*len(keys))
+	for _, k := range keys {
+		hashKey, blockKey := k.Pair()
+		pairs = append(pairs, hashKey, blockKey)
+	}
+	return pairs
+}
+
+// SessionKeyRing is the codec of a cookie store whose keys can be replaced
+// while the store is in use.
+type SessionKeyRing struct {
+	mu     sync.RWMutex
+	codecs []securecookie.Codec
+}
+
+// NewSessionStore returns a cookie store sealing cookies with keys, and the
+// key ring to rotate them with.
+func NewSessionStore(keys []SessionKey) (*sessions.CookieStore, *SessionKeyRing) {
+	ring := &SessionKeyRing{}
+	ring.Set(keys)
+	store := sessions.NewCookieStore()
+	store.Codecs = []securecookie.Codec{ring}
+	return store, ring
+}
+
+// Set replaces the keys of the ring, the current one first.
+func (r *SessionKeyRing) Set(keys []SessionKey) {
+	codecs := securecookie.CodecsFromPairs(SessionKeyPairs(keys)...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codecs = codecs
+}
+
+// Encode seals a cookie with the current key.
+func (r *SessionKeyRing) Encode(name string, value interface{}) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return securecookie.EncodeMulti(name, value, r.codecs...)
+}
+
+// Decode opens a cookie with whichever key sealed it.
+func (r *SessionKeyRing) Decode(name, value string, dst interface{}) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return securecookie.DecodeMulti(name, value, dst, r.codecs...)
+}
+
+// SessionKeysFromEnv reads the session keys from SESSION_SECRETS, a comma
+// separated list with the current secret first. SESSION_SECRET, the single
+// secret used before, keeps opening the cookies it signed; on its own it also
+// seals new cookies.
+func SessionKeysFromEnv() ([]SessionKey, error) {
+	var keys []SessionKey
+	for _, secret := range strings.Split(os.Getenv("SESSION_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			keys = append(keys, SessionKey{Secret: []byte(secret)})
+		}
+	}
+	if legacy := os.Getenv("SESSION_SECRET"); legacy != "" {
+		if len(keys) == 0 {
+			keys = append(keys, SessionKey{Secret: []byte(legacy)})
+		}
+		keys = append(keys, SessionKey{Secret: []byte(legacy), Legacy: true})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("SESSION_SECRETS is not set")
+	}
+	return keys, nil
+}
+
+// SessionKeysPath returns the path of the session keys file, SESSION_KEYS_FILE
+// or DefaultSessionKeysFile.
+func SessionKeysPath() string {
+	if path := os.Getenv("SESSION_KEYS_FILE"); path != "" {
+		return path
+	}
+	return DefaultSessionKeysFile
+}
+
+// sessionKeysFile is the on-disk form of the session keys file.
+type sessionKeysFile struct {
+	Keys []sessionKeyJSON `json:"keys,omitempty"` // current first
+
+	// A single secret, as written before session keys could be rotated
+	MasterKeyID string `json:"masterKeyId,omitempty"`
+	Wrapped     []byte `json:"wrapped,omitempty"`
+}
+
+type sessionKeyJSON struct {
+	MasterKeyID string     `json:"masterKeyId"`
+	Wrapped     []byte     `json:"wrapped"` // base64 in JSON
+	Created     time.Time  `json:"created"`
+	Retired     *time.Time `json:"retired,omitempty"`
+	Legacy      bool       `json:"legacy,omitempty"`
+}
+
+// LoadSessionKeys returns the session keys kept in path, wrapped by
+// masterKeys. A random key is created the first time, with SESSION_SECRET
+// kept as a legacy key if it is set. Keys wrapped by an older master key are
+// rewrapped with the current one.
+func LoadSessionKeys(ctx context.Context, masterKeys kms.KMS, path string) ([]SessionKey, error) {
+	keys, stale, err := readSessionKeys(ctx, masterKeys, path)
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		if err := writeSessionKeys(ctx, masterKeys, path, keys); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// RotateSessionKeys adds a random key to the session keys in path and makes
+// it current. Keys replaced more than SessionKeyRetention ago are dropped,
+// since every cookie they sealed has expired.
+func RotateSessionKeys(ctx context.Context, masterKeys kms.KMS, path string) ([]SessionKey, error) {
+	keys, _, err := readSessionKeys(ctx, masterKeys, path)
+	if err != nil {
+		return nil, err
+	}
+	current, err := newSessionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := []SessionKey{current}
+	for _, k := range keys {
+		if k.Retired.IsZero() {
+			k.Retired = current.Created
+		}
+		if current.Created.Sub(k.Retired) < SessionKeyRetention {
+			rotated = append(rotated, k)
+		}
+	}
+	if err := writeSessionKeys(ctx, masterKeys, path, rotated); err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+func newSessionKey() (SessionKey, error) {
+	secret, err := envelope.NewDataKey()
+	if err != nil {
+		return SessionKey{}, err
+	}
+	return SessionKey{Secret: secret, Created: time.Now().UTC()}, nil
+}
+
+// readSessionKeys reads and unwraps the session keys in path. stale tells
+// whether the file should be written again: it is missing, in the older
+// single secret format, or wrapped by an older master key.
+func readSessionKeys(ctx context.Context, masterKeys kms.KMS, path string) (keys []SessionKey, stale bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := newSessionKey()
+		if err != nil {
+			return nil, false, err
+		}
+		keys = []SessionKey{key}
+		// Cookies signed with SESSION_SECRET stay valid until they expire
+		if legacy := os.Getenv("SESSION_SECRET"); legacy != "" {
+			keys = append(keys, SessionKey{Secret: []byte(legacy), Created: key.Created, Retired: key.Created, Legacy: true})
+		}
+		return keys, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var file sessionKeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, false, fmt.Errorf("reading session keys file %s: %w", path, err)
+	}
+
+	// The single secret of the older format only signed cookies; it is kept
+	// as a legacy key so they stay valid
+	if file.MasterKeyID != "" {
+		secret, err := masterKeys.Unwrap(ctx, file.MasterKeyID, file.Wrapped, sessionSecretContext)
+		if err != nil {
+			return nil, false, fmt.Errorf("unwrapping session secret: %w", err)
+		}
+		current, err := newSessionKey()
+		if err != nil {
+			return nil, false, err
+		}
+		legacy := SessionKey{Secret: secret, Created: current.Created, Retired: current.Created, Legacy: true}
+		return []SessionKey{current, legacy}, true, nil
+	}
+
+	if len(file.Keys) == 0 {
+		return nil, false, fmt.Errorf("session keys file %s has no keys", path)
+	}
+	currentID, err := masterKeys.CurrentID(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, k := range file.Keys {
+		secret, err := masterKeys.Unwrap(ctx, k.MasterKeyID, k.Wrapped, sessionSecretContext)
+		if err != nil {
+			return nil, false, fmt.Errorf("unwrapping session key: %w", err)
+		}
+		key := SessionKey{Secret: secret, Created: k.Created, Legacy: k.Legacy}
+		if k.Retired != nil {
+			key.Retired = *k.Retired
+		}
+		keys = append(keys, key)
+		stale = stale || k.MasterKeyID != currentID
+	}
+	return keys, stale, nil
+}
+
+// writeSessionKeys wraps keys with the current master key and replaces the
+// file at path with them.
+func writeSessionKeys(ctx context.Context, masterKeys kms.KMS, path string, keys []SessionKey) error {
+	var file sessionKeysFile
+	for _, k := range keys {
+		keyID, wrapped, err := masterKeys.Wrap(ctx, k.Secret, sessionSecretContext)
+		if err != nil {
+			return fmt.Errorf("wrapping session key: %w", err)
+		}
+		entry := sessionKeyJSON{MasterKeyID: keyID, Wrapped: wrapped, Created: k.Created, Legacy: k.Legacy}
+		if !k.Retired.IsZero() {
+			retired := k.Retired
+			entry.Retired = &retired
+		}
+		file.Keys = append(file.Keys, entry)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a file of our own and rename it over the old one, so a rotation
+	// from the kms command racing the server never leaves half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".session_keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp already creates the file readable only by its owner
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// watchSessionKeys keeps ring in step with the session keys file: it picks up
+// rotations made by the kms command and, with rotateEvery set, rotates the
+// keys itself once the current one is that old.
+func watchSessionKeys(masterKeys kms.KMS, path string, ring *SessionKeyRing, keys []SessionKey, rotateEvery time.Duration) {
+	ctx := context.Background()
+	seen, _ := os.ReadFile(path)
+	for {
+		time.Sleep(sessionKeysCheckInterval)
+
+		var updated []SessionKey
+		var err error
+		if rotateEvery > 0 && time.Since(keys[0].Created) >= rotateEvery {
+			updated, err = RotateSessionKeys(ctx, masterKeys, path)
+			if err == nil {
+				log.Println("Rotated session keys")
+			}
+		} else if data, readErr := os.ReadFile(path); readErr == nil && !bytes.Equal(data, seen) {
+			updated, err = LoadSessionKeys(ctx, masterKeys, path)
+		} else {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error updating session keys: %v", err)
+			continue
+		}
+
+		ring.Set(updated)
+		keys = updated
+		seen, _ = os.ReadFile(path)
+	}
+}
@@ -0,0 +1,165 @@
+// Package contenttype decides which MIME type a stored file is served with
+// and whether a browser may render it inline.
+package contenttype
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Generic is the type of content that could not be identified.
+const Generic = "application/octet-stream"
+
+// SniffLen is the number of leading bytes Resolve looks at.
+const SniffLen = 512
+
+// Common extensions, checked before the system MIME table so results do not
+// depend on the host.
+var extensionTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".svg":  "image/svg+xml",
+	".pdf":  "application/pdf",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".txt":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".csv":  "text/csv; charset=utf-8",
+	".json": "application/json",
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".zip":  "application/zip",
+}
+
+// ByName guesses a MIME type from a file name, or returns Generic.
+func ByName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return Generic
+}
+
+// isGeneric reports whether a type says nothing about the content.
+func isGeneric(contentType string) bool {
+	switch mediaType(contentType) {
+	case "", Generic, "binary/octet-stream", "application/unknown":
+		return true
+	}
+	return false
+}
+
+// NeedsSniffing reports whether Resolve would look at the content for a file
+// stored with this type.
+func NeedsSniffing(stored string) bool {
+	return isGeneric(stored)
+}
+
+// Resolve returns the type to serve a file with. The type stored with the
+// object wins unless it is generic; then the leading bytes of the content
+// are sniffed, and the file name is used when sniffing only finds plain text
+// or nothing at all.
+func Resolve(name, stored string, head []byte) string {
+	if !isGeneric(stored) {
+		return stored
+	}
+
+	sniffed := Generic
+	if len(head) > 0 {
+		sniffed = http.DetectContentType(head)
+	}
+	if isGeneric(sniffed) || mediaType(sniffed) == "text/plain" {
+		if byName := ByName(name); !isGeneric(byName) {
+			return byName
+		}
+	}
+	return sniffed
+}
+
+// mediaType strips parameters such as charset and lower-cases the type.
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// Inline reports whether a type is safe for a browser to render in our
+// origin. Anything that can run script, such as HTML, SVG or XML, must be
+// downloaded instead.
+func Inline(contentType string) bool {
+	t := mediaType(contentType)
+	switch {
+	case t == "image/svg+xml":
+		return false
+	case strings.HasPrefix(t, "image/"), strings.HasPrefix(t, "audio/"), strings.HasPrefix(t, "video/"):
+		return true
+	case t == "application/pdf", t == "text/plain", t == "text/csv", t == "text/markdown":
+		return true
+	}
+	return false
+}
+
+// Disposition builds a Content-Disposition header for a file. The file is
+// shown inline only if that was asked for and its type is safe. The name is
+// given both as an ASCII fallback and, per RFC 6266, as UTF-8 in filename*.
+func Disposition(contentType, filename string, inline bool) string {
+	kind := "attachment"
+	if inline && Inline(contentType) {
+		kind = "inline"
+	}
+
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" {
+		filename = "download"
+	}
+
+	return kind + `; filename="` + asciiFallback(filename) + `"; filename*=UTF-8''` + encodeRFC5987(filename)
+}
+
+// asciiFallback replaces characters that cannot appear in a quoted filename
+// parameter for old clients.
+func asciiFallback(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\' || r < 0x20 || r > 0x7e:
+			b.WriteByte('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// encodeRFC5987 percent-encodes everything but the attr-char set of RFC 5987.
+func encodeRFC5987(name string) string {
+	var b strings.Builder
+	for _, c := range []byte(name) {
+		if isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrGrantNotFound is returned when removing access that was never granted.
+var ErrGrantNotFound = errors.New("access grant not found")
+
+// AccessGrant gives a registered user rights on a file or folder of another
+// user. A grant on a folder covers everything inside it.
+type AccessGrant struct {
+	OwnerID      int       `json:"ownerId"`
+	OwnerEmail   string    `json:"ownerEmail"`
+	OwnerName    string    `json:"ownerName"`
+	GranteeID    int       `json:"granteeId"`
+	GranteeEmail string    `json:"granteeEmail"`
+	GranteeName  string    `json:"granteeName"`
+	ItemPath     string    `json:"path"`
+	ItemType     string    `json:"type"` // "file" or "folder"
+	Role         string    `json:"role"` // "viewer" or "editor"
+	CreationDate time.Time `json:"creationDate"`
+}
+
+const accessGrantQuery = `
+	SELECT g.ownerID, o.userEmail, o.firstName || ' ' || o.lastName,
+		g.granteeID, u.userEmail, u.firstName || ' ' || u.lastName,
+		g.itemPath, g.itemType, g.role, g.creationDate
+	FROM accessGrants g
+	JOIN userInfo o ON o.userID = g.ownerID
+	JOIN userInfo u ON u.userID = g.granteeID
+`
+
+func (s *service) queryAccessGrants(query string, args ...any) ([]AccessGrant, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access grants: %v", err)
+	}
+	defer rows.Close()
+
+	grants := make([]AccessGrant, 0)
+	for rows.Next() {
+		var g AccessGrant
+		err := rows.Scan(&g.OwnerID, &g.OwnerEmail, &g.OwnerName, &g.GranteeID, &g.GranteeEmail, &g.GranteeName,
+			&g.ItemPath, &g.ItemType, &g.Role, &g.CreationDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read access grant: %v", err)
+		}
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list access grants: %v", err)
+	}
+	return grants, nil
+}
+
+// Grant a user a role on a file or folder of the owner, replacing the role
+// they had on that exact path
+func (s *service) GrantAccess(ownerID, granteeID int, itemPath, itemType, role string) error {
+	query := `
+		INSERT INTO accessGrants (ownerID, granteeID, itemPath, itemType, role)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (ownerID, granteeID, itemPath) DO UPDATE SET role = EXCLUDED.role, itemType = EXCLUDED.itemType
+	`
+	if _, err := s.db.Exec(query, ownerID, granteeID, itemPath, itemType, role); err != nil {
+		return fmt.Errorf("failed to grant access: %v", err)
+	}
+	return nil
+}
+
+// Take back the access a user was granted on a path
+func (s *service) RevokeAccess(ownerID, granteeID int, itemPath string) error {
+	result, err := s.db.Exec(`DELETE FROM accessGrants WHERE ownerID = $1 AND granteeID = $2 AND itemPath = $3`, ownerID, granteeID, itemPath)
+	if err != nil {
+		return fmt.Errorf("failed to revoke access: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrGrantNotFound
+	}
+	return nil
+}
+
+// List the grants an owner made, optionally only those on itemPath
+func (s *service) ListGrants(ownerID int, itemPath string) ([]AccessGrant, error) {
+	return s.queryAccessGrants(accessGrantQuery+`
+		WHERE g.ownerID = $1 AND ($2::text = '' OR g.itemPath = $2)
+		ORDER BY g.itemPath, u.userEmail
+	`, ownerID, itemPath)
+}
+
+// List what other users have shared with a user, newest first
+func (s *service) SharedWithUser(granteeID int) ([]AccessGrant, error) {
+	return s.queryAccessGrants(accessGrantQuery+`
+		WHERE g.granteeID = $1
+		ORDER BY g.creationDate DESC, g.grantID DESC
+	`, granteeID)
+}
+
+// Get the strongest role a user has on an item of the owner through a grant
+// on the item or a folder above it. It returns "" without access.
+func (s *service) AccessRole(ownerID, granteeID int, itemPath string) (string, error) {
+	query := `
+		SELECT role FROM accessGrants
+		WHERE ownerID = $1 AND granteeID = $2
+			AND (itemPath = $3 OR left($3::text, length(itemPath) + 1) = itemPath || '/')
+		ORDER BY role = 'editor' DESC
+		LIMIT 1
+	`
+	var role string
+	err := s.db.QueryRow(query, ownerID, granteeID, itemPath).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check access: %v", err)
+	}
+	return role, nil
+}
+
+// Point an owner's grants at the new path of a moved file or folder,
+// including grants on items inside a moved folder
+func (s *service) MoveAccessGrants(ownerID int, oldPath, newPath string) error {
+	query := `
+		UPDATE accessGrants SET itemPath = $3::text || substr(itemPath, length($2::text) + 1)
+		WHERE ownerID = $1 AND (itemPath = $2 OR itemPath LIKE $4)
+	`
+	_, err := s.db.Exec(query, ownerID, oldPath, newPath, escapeLike(oldPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to move access grants: %v", err)
+	}
+	return nil
+}
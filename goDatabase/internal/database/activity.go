@@ -0,0 +1,79 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxActivityPerUser bounds how many activity rows are kept for each user.
+const maxActivityPerUser = 500
+
+// RecentFile is a file with the latest thing that happened to it.
+type RecentFile struct {
+	FileRecord
+	Action       string    `json:"action"`
+	ActivityDate time.Time `json:"activityDate"`
+}
+
+// Record that a user uploaded, downloaded, moved or renamed an indexed file.
+// The oldest rows beyond maxActivityPerUser are dropped.
+func (s *service) RecordActivity(userID int, objectKey, action string) error {
+	query := `
+		INSERT INTO fileActivity (userID, fileID, action)
+		SELECT userID, fileID, $3 FROM Files WHERE userID = $1 AND objectKey = $2
+	`
+	result, err := s.db.Exec(query, userID, objectKey, action)
+	if err != nil {
+		return fmt.Errorf("failed to record activity: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotIndexed
+	}
+
+	query = `
+		DELETE FROM fileActivity WHERE userID = $1 AND activityID <= (
+			SELECT activityID FROM fileActivity WHERE userID = $1
+			ORDER BY activityID DESC OFFSET $2 LIMIT 1
+		)
+	`
+	_, err = s.db.Exec(query, userID, maxActivityPerUser)
+	if err != nil {
+		return fmt.Errorf("failed to prune activity: %v", err)
+	}
+	return nil
+}
+
+// List the files a user most recently worked with, newest first
+func (s *service) RecentFiles(userID int, limit int) ([]RecentFile, error) {
+	query := `
+		SELECT f.fileID, f.fileName, f.objectKey, f.fileType, f.fileSize, f.uploadDate, f.lastModifiedData, ` + tagsColumn("f") + `,
+			a.action, a.activityDate
+		FROM (
+			SELECT DISTINCT ON (fileID) fileID, action, activityDate
+			FROM fileActivity WHERE userID = $1
+			ORDER BY fileID, activityID DESC
+		) a JOIN Files f ON f.fileID = a.fileID
+		ORDER BY a.activityDate DESC
+		LIMIT $2
+	`
+	rows, err := s.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recent files: %v", err)
+	}
+	defer rows.Close()
+
+	files := make([]RecentFile, 0)
+	for rows.Next() {
+		var f RecentFile
+		var tags string
+		if err := rows.Scan(&f.FileID, &f.FileName, &f.ObjectKey, &f.FileType, &f.FileSize, &f.UploadDate, &f.LastModified, &tags, &f.Action, &f.ActivityDate); err != nil {
+			return nil, fmt.Errorf("failed to read recent file: %v", err)
+		}
+		f.Tags = splitTags(tags)
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list recent files: %v", err)
+	}
+	return files, nil
+}
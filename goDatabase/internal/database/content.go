@@ -0,0 +1,141 @@
+package database
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Markers ts_headline puts around matches. They come from the Unicode private
+// use area so they cannot clash with HTML, which is escaped afterwards.
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+// ContentMatch is a file whose extracted text matched a full-text search.
+type ContentMatch struct {
+	FileRecord
+	Snippet string  `json:"snippet"` // HTML with matches wrapped in <mark>
+	Rank    float64 `json:"rank"`
+}
+
+// Store the extracted text of an indexed file
+func (s *service) SetFileContent(userID int, objectKey, content string) error {
+	query := `
+		INSERT INTO fileContent (fileID, content)
+		SELECT fileID, $3 FROM Files WHERE userID = $1 AND objectKey = $2
+		ON CONFLICT (fileID) DO UPDATE SET
+			content = EXCLUDED.content,
+			indexedDate = CURRENT_TIMESTAMP
+	`
+	_, err := s.db.Exec(query, userID, objectKey, content)
+	if err != nil {
+		return fmt.Errorf("failed to set file content: %v", err)
+	}
+	return nil
+}
+
+// Forget the extracted text of a file, e.g. after it was overwritten with
+// something that cannot be indexed
+func (s *service) ClearFileContent(userID int, objectKey string) error {
+	query := `
+		DELETE FROM fileContent WHERE fileID IN (
+			SELECT fileID FROM Files WHERE userID = $1 AND objectKey = $2
+		)
+	`
+	_, err := s.db.Exec(query, userID, objectKey)
+	if err != nil {
+		return fmt.Errorf("failed to clear file content: %v", err)
+	}
+	return nil
+}
+
+// Forget the extracted text of all of a user's files, e.g. once their files
+// are encrypted
+func (s *service) ClearUserFileContent(userID int) error {
+	query := `
+		DELETE FROM fileContent WHERE fileID IN (
+			SELECT fileID FROM Files WHERE userID = $1
+		)
+	`
+	_, err := s.db.Exec(query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear file content: %v", err)
+	}
+	return nil
+}
+
+// copyFileContent duplicates the extracted text of the files below oldPrefix
+// (or of the single file oldPrefix) onto their copies below newPrefix.
+func (s *service) copyFileContent(q execer, userID int, oldPrefix, newPrefix, pattern string) error {
+	query := `
+		INSERT INTO fileContent (fileID, content)
+		SELECT n.fileID, fc.content
+		FROM Files o
+		JOIN fileContent fc ON fc.fileID = o.fileID
+		JOIN Files n ON n.userID = o.userID
+			AND n.objectKey = $3::text || substr(o.objectKey, length($2::text) + 1)
+		WHERE o.userID = $1 AND o.objectKey LIKE $4
+		ON CONFLICT (fileID) DO NOTHING
+	`
+	_, err := q.Exec(query, userID, oldPrefix, newPrefix, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to copy file content: %v", err)
+	}
+	return nil
+}
+
+// Full-text search the extracted text of a user's files
+func (s *service) SearchFileContent(userID int, text, folder string, limit, offset int) ([]ContentMatch, int, error) {
+	conditions := `f.userID = $1 AND fc.contentVector @@ websearch_to_tsquery('english', $2)`
+	args := []interface{}{userID, text}
+	if folder != "" {
+		args = append(args, escapeLike(folder)+"%")
+		conditions += fmt.Sprintf(" AND f.objectKey LIKE $%d", len(args))
+	}
+
+	var total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM fileContent fc JOIN Files f ON f.fileID = fc.fileID
+		WHERE `+conditions, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count content matches: %v", err)
+	}
+
+	options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=25, MinWords=8", highlightStart, highlightStop)
+	args = append(args, options, limit, offset)
+	n := len(args)
+
+	query := fmt.Sprintf(`
+		SELECT f.fileID, f.fileName, f.objectKey, f.fileType, f.fileSize, f.uploadDate, f.lastModifiedData, %s,
+			ts_headline('english', fc.content, websearch_to_tsquery('english', $2), $%d),
+			ts_rank(fc.contentVector, websearch_to_tsquery('english', $2)) AS rank
+		FROM fileContent fc JOIN Files f ON f.fileID = fc.fileID
+		WHERE %s
+		ORDER BY rank DESC, f.objectKey
+		LIMIT $%d OFFSET $%d
+	`, tagsColumn("f"), n-2, conditions, n-1, n)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search file content: %v", err)
+	}
+	defer rows.Close()
+
+	matches := make([]ContentMatch, 0)
+	for rows.Next() {
+		var m ContentMatch
+		var tags, headline string
+		if err := rows.Scan(&m.FileID, &m.FileName, &m.ObjectKey, &m.FileType, &m.FileSize, &m.UploadDate, &m.LastModified, &tags, &headline, &m.Rank); err != nil {
+			return nil, 0, fmt.Errorf("failed to read content match: %v", err)
+		}
+		m.Tags = splitTags(tags)
+		m.Snippet = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(html.EscapeString(headline))
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to search file content: %v", err)
+	}
+	return matches, total, nil
+}
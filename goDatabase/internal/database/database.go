@@ -33,6 +33,83 @@ type Service interface {
     UpdateFaceScannedBool(userID int, updateBool bool) error
 	UpdateProfilePicture(email string, profilePicture string) error
 	GetProfilePictureByEmail(email string) (string, error)
+
+	// Folder and Files metadata index
+	UpsertFile(userID int, objectKey, contentType string, size int64, lastModified time.Time) error
+	DeleteFile(userID int, objectKey string) error
+	MoveFile(userID int, oldKey, newKey string) error
+	CopyFile(userID int, oldKey, newKey string) error
+	EnsureFolder(userID int, folderPath string) (int, error)
+	DeleteFolder(userID int, folderPath string) error
+	MoveFolder(userID int, oldPath, newPath string) error
+	CopyFolder(userID int, oldPath, newPath string) error
+	ListFolder(userID int, folderPath string, opts ListOptions) ([]FolderRecord, []FileRecord, *ListCursor, error)
+	HasIndexedItems(userID int) (bool, error)
+	ReindexUser(userID int, folders []string, files []FileRecord) error
+	SearchFiles(userID int, search FileSearch) ([]FileRecord, int, error)
+
+	// Full-text index of file contents
+	SetFileContent(userID int, objectKey, content string) error
+	ClearFileContent(userID int, objectKey string) error
+	ClearUserFileContent(userID int) error
+	SearchFileContent(userID int, text, folder string, limit, offset int) ([]ContentMatch, int, error)
+
+	// User-defined tags on files
+	AddTags(userID int, objectKeys, tags []string) error
+	RemoveTags(userID int, objectKeys, tags []string) error
+	GetFileTags(userID int, objectKeys []string) (map[string][]string, error)
+	ListTags(userID int) ([]TagCount, error)
+
+	// Starred items and recent activity
+	StarItem(userID int, itemPath string, isFolder bool) error
+	UnstarItem(userID int, itemPath string, isFolder bool) error
+	ListStarred(userID int) ([]StarredItem, error)
+	RecordActivity(userID int, objectKey, action string) error
+	RecentFiles(userID int, limit int) ([]RecentFile, error)
+
+	// Public share links
+	CreateShareLink(link ShareLink) (ShareLink, error)
+	GetShareLink(token string) (ShareLink, error)
+	ListShareLinks(userID int, itemPath string) ([]ShareLink, error)
+	RevokeShareLink(userID int, token string) error
+	ClaimShareDownload(linkID int) (bool, error)
+	LogShareAccess(linkID int, ipAddress, userAgent, outcome string) error
+	ListShareAccess(userID int, token string, limit int) ([]ShareAccess, error)
+	MoveShareLinks(userID int, oldPath, newPath string) error
+
+	// Access to files and folders granted to other users
+	GrantAccess(ownerID, granteeID int, itemPath, itemType, role string) error
+	RevokeAccess(ownerID, granteeID int, itemPath string) error
+	ListGrants(ownerID int, itemPath string) ([]AccessGrant, error)
+	SharedWithUser(granteeID int) ([]AccessGrant, error)
+	AccessRole(ownerID, granteeID int, itemPath string) (string, error)
+	MoveAccessGrants(ownerID int, oldPath, newPath string) error
+
+	// Upload-only links into a user's folder
+	CreateFileRequest(request FileRequest) (FileRequest, error)
+	GetFileRequest(token string) (FileRequest, error)
+	ListFileRequests(userID int) ([]FileRequest, error)
+	RevokeFileRequest(userID int, token string) error
+	ReserveFileRequestBytes(requestID int, size int64) (bool, error)
+	ReleaseFileRequestBytes(requestID int, size int64) error
+	MoveFileRequests(userID int, oldPath, newPath string) error
+
+	// In-app notifications
+	AddNotification(userID int, kind, message, itemPath string) error
+	ListNotifications(userID int, unreadOnly bool, limit int) ([]Notification, int, error)
+	MarkNotificationsRead(userID int, ids []int) error
+
+	// Per-user file encryption keys, wrapped by a master key
+	DataKeys(userID int) ([]DataKey, error)
+	AddDataKey(userID, version int, wrappedKey []byte, masterKeyID string) error
+	DataKeysNotWrappedBy(masterKeyID string, limit int) ([]DataKey, error)
+	RewrapDataKey(userID, version int, wrappedKey []byte, masterKeyID string) error
+
+	// Face-unlocked vault keys, wrapped by a master key
+	GetVault(userID int) (Vault, error)
+	CreateVault(userID int, wrappedKey []byte, masterKeyID string) error
+	VaultsNotWrappedBy(masterKeyID string, limit int) ([]Vault, error)
+	RewrapVault(userID int, wrappedKey []byte, masterKeyID string) error
 }
 
 type service struct {
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDataKeyExists is returned when adding a data key version that another
+// request added first.
+var ErrDataKeyExists = errors.New("data key version already exists")
+
+// DataKey is one version of a user's file encryption key, wrapped by a
+// server master key.
+type DataKey struct {
+	UserID       int
+	Version      int
+	WrappedKey   []byte
+	MasterKeyID  string
+	CreationDate time.Time
+}
+
+func scanDataKeys(rows *sql.Rows) ([]DataKey, error) {
+	keys := make([]DataKey, 0)
+	for rows.Next() {
+		var k DataKey
+		if err := rows.Scan(&k.UserID, &k.Version, &k.WrappedKey, &k.MasterKeyID, &k.CreationDate); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// List every version of a user's data key, newest first
+func (s *service) DataKeys(userID int) ([]DataKey, error) {
+	query := `
+		SELECT userID, keyVersion, wrappedKey, masterKeyID, creationDate
+		FROM userDataKeys WHERE userID = $1
+		ORDER BY keyVersion DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys, err := scanDataKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data keys: %v", err)
+	}
+	return keys, nil
+}
+
+// Store a new version of a user's data key. Two requests adding the same
+// version race on the primary key; the loser gets ErrDataKeyExists.
+func (s *service) AddDataKey(userID, version int, wrappedKey []byte, masterKeyID string) error {
+	query := `
+		INSERT INTO userDataKeys (userID, keyVersion, wrappedKey, masterKeyID)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (userID, keyVersion) DO NOTHING
+	`
+	result, err := s.db.Exec(query, userID, version, wrappedKey, masterKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to add data key: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrDataKeyExists
+	}
+	return nil
+}
+
+// List up to limit data keys wrapped with a master key other than masterKeyID
+func (s *service) DataKeysNotWrappedBy(masterKeyID string, limit int) ([]DataKey, error) {
+	query := `
+		SELECT userID, keyVersion, wrappedKey, masterKeyID, creationDate
+		FROM userDataKeys WHERE masterKeyID <> $1
+		ORDER BY userID, keyVersion
+		LIMIT $2
+	`
+	rows, err := s.db.Query(query, masterKeyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys, err := scanDataKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data keys: %v", err)
+	}
+	return keys, nil
+}
+
+// Replace the wrapped form of a data key after wrapping it with another
+// master key. The key itself does not change.
+func (s *service) RewrapDataKey(userID, version int, wrappedKey []byte, masterKeyID string) error {
+	query := `UPDATE userDataKeys SET wrappedKey = $3, masterKeyID = $4 WHERE userID = $1 AND keyVersion = $2`
+	if _, err := s.db.Exec(query, userID, version, wrappedKey, masterKeyID); err != nil {
+		return fmt.Errorf("failed to rewrap data key: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrFileRequestNotFound is returned for file request tokens that do not
+// exist or do not belong to the user.
+var ErrFileRequestNotFound = errors.New("file request not found")
+
+// FileRequest is an upload-only link into one of a user's folders.
+type FileRequest struct {
+	RequestID     int        `json:"-"`
+	Token         string     `json:"token"`
+	UserID        int        `json:"-"`
+	FolderPath    string     `json:"path"`
+	Title         string     `json:"title"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	MaxTotalBytes int64      `json:"maxTotalBytes,omitempty"` // 0 means only the quota applies
+	UploadedBytes int64      `json:"uploadedBytes"`
+	AllowedTypes  []string   `json:"allowedTypes"` // empty allows any type
+	Revoked       bool       `json:"revoked"`
+	CreationDate  time.Time  `json:"creationDate"`
+}
+
+const fileRequestColumns = `requestID, token, userID, folderPath, title, expiresAt,
+	COALESCE(maxTotalBytes, 0), uploadedBytes, allowedTypes, revoked, creationDate`
+
+func scanFileRequest(row rowScanner) (FileRequest, error) {
+	var r FileRequest
+	var expiresAt sql.NullTime
+	var allowedTypes string
+	err := row.Scan(&r.RequestID, &r.Token, &r.UserID, &r.FolderPath, &r.Title, &expiresAt,
+		&r.MaxTotalBytes, &r.UploadedBytes, &allowedTypes, &r.Revoked, &r.CreationDate)
+	if err != nil {
+		return r, err
+	}
+	if expiresAt.Valid {
+		r.ExpiresAt = &expiresAt.Time
+	}
+	r.AllowedTypes = splitTags(allowedTypes)
+	return r, nil
+}
+
+// Create a file request. Token, UserID and FolderPath must be set; a nil
+// ExpiresAt, zero MaxTotalBytes and no AllowedTypes disable those checks.
+// Allowed types are stored comma separated and cannot contain commas.
+func (s *service) CreateFileRequest(request FileRequest) (FileRequest, error) {
+	var maxTotalBytes any
+	if request.MaxTotalBytes > 0 {
+		maxTotalBytes = request.MaxTotalBytes
+	}
+
+	query := `
+		INSERT INTO fileRequests (token, userID, folderPath, title, expiresAt, maxTotalBytes, allowedTypes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + fileRequestColumns
+	created, err := scanFileRequest(s.db.QueryRow(query, request.Token, request.UserID, request.FolderPath, request.Title,
+		request.ExpiresAt, maxTotalBytes, strings.Join(request.AllowedTypes, tagSeparator)))
+	if err != nil {
+		return FileRequest{}, fmt.Errorf("failed to create file request: %v", err)
+	}
+	return created, nil
+}
+
+// Look up a file request by its token, revoked or not
+func (s *service) GetFileRequest(token string) (FileRequest, error) {
+	query := `SELECT ` + fileRequestColumns + ` FROM fileRequests WHERE token = $1`
+	request, err := scanFileRequest(s.db.QueryRow(query, token))
+	if err == sql.ErrNoRows {
+		return FileRequest{}, ErrFileRequestNotFound
+	}
+	if err != nil {
+		return FileRequest{}, fmt.Errorf("failed to get file request: %v", err)
+	}
+	return request, nil
+}
+
+// List a user's file requests, newest first
+func (s *service) ListFileRequests(userID int) ([]FileRequest, error) {
+	query := `SELECT ` + fileRequestColumns + ` FROM fileRequests WHERE userID = $1 ORDER BY creationDate DESC, requestID DESC`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file requests: %v", err)
+	}
+	defer rows.Close()
+
+	requests := make([]FileRequest, 0)
+	for rows.Next() {
+		request, err := scanFileRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file request: %v", err)
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list file requests: %v", err)
+	}
+	return requests, nil
+}
+
+// Close one of a user's file requests to further uploads
+func (s *service) RevokeFileRequest(userID int, token string) error {
+	result, err := s.db.Exec(`UPDATE fileRequests SET revoked = TRUE WHERE userID = $1 AND token = $2`, userID, token)
+	if err != nil {
+		return fmt.Errorf("failed to revoke file request: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrFileRequestNotFound
+	}
+	return nil
+}
+
+// Set aside size bytes of a file request's allowance before uploading. It
+// returns false without reserving if the request is closed, expired or the
+// bytes do not fit, so concurrent uploads cannot go over the limit.
+func (s *service) ReserveFileRequestBytes(requestID int, size int64) (bool, error) {
+	query := `
+		UPDATE fileRequests SET uploadedBytes = uploadedBytes + $2
+		WHERE requestID = $1 AND NOT revoked
+			AND (expiresAt IS NULL OR expiresAt > CURRENT_TIMESTAMP)
+			AND (maxTotalBytes IS NULL OR uploadedBytes + $2 <= maxTotalBytes)
+	`
+	result, err := s.db.Exec(query, requestID, size)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve upload size: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve upload size: %v", err)
+	}
+	return n > 0, nil
+}
+
+// Give back bytes reserved for uploads that did not happen
+func (s *service) ReleaseFileRequestBytes(requestID int, size int64) error {
+	query := `UPDATE fileRequests SET uploadedBytes = GREATEST(uploadedBytes - $2, 0) WHERE requestID = $1`
+	if _, err := s.db.Exec(query, requestID, size); err != nil {
+		return fmt.Errorf("failed to release upload size: %v", err)
+	}
+	return nil
+}
+
+// Point a user's file requests at the new path of a moved folder, including
+// requests into folders inside it
+func (s *service) MoveFileRequests(userID int, oldPath, newPath string) error {
+	query := `
+		UPDATE fileRequests SET folderPath = $3::text || substr(folderPath, length($2::text) + 1)
+		WHERE userID = $1 AND (folderPath = $2 OR folderPath LIKE $4)
+	`
+	_, err := s.db.Exec(query, userID, oldPath, newPath, escapeLike(oldPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to move file requests: %v", err)
+	}
+	return nil
+}
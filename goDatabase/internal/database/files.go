@@ -0,0 +1,284 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// FileRecord is one row of the Files metadata index.
+type FileRecord struct {
+	FileID       int       `json:"fileID"`
+	FileName     string    `json:"name"`
+	ObjectKey    string    `json:"path"`
+	FileType     string    `json:"contentType"`
+	FileSize     int64     `json:"size"`
+	UploadDate   time.Time `json:"uploadDate"`
+	LastModified time.Time `json:"lastModified"`
+	Tags         []string  `json:"tags"`
+}
+
+// FileSearch holds the filters of a file search. Zero values are ignored.
+type FileSearch struct {
+	Name           string // substring, or a glob when it contains * or ?
+	ContentType    string // exact type, or a prefix such as "image/"
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Folder         string   // only search below this folder
+	ExcludeFolder  string   // leave out files below this folder
+	Tags           []string // files must carry every one of these tags
+	Limit          int
+	Offset         int
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// globToLike turns a shell style glob into a LIKE pattern.
+func globToLike(glob string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(glob))
+}
+
+// Add a file to the index, or refresh it if the key is already indexed.
+// Folders leading up to the file are created as needed.
+func (s *service) UpsertFile(userID int, objectKey, contentType string, size int64, lastModified time.Time) error {
+	folderID, err := s.folderIDForKey(s.db, userID, objectKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO Files (fileName, objectKey, fileType, fileSize, lastModifiedData, folderID, userID)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (userID, objectKey) DO UPDATE SET
+			fileType = EXCLUDED.fileType,
+			fileSize = EXCLUDED.fileSize,
+			lastModifiedData = EXCLUDED.lastModifiedData,
+			folderID = EXCLUDED.folderID
+	`
+	_, err = s.db.Exec(query, path.Base(objectKey), objectKey, contentType, size, lastModified, folderID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to upsert file: %v", err)
+	}
+	return nil
+}
+
+// Remove a single file from the index
+func (s *service) DeleteFile(userID int, objectKey string) error {
+	query := `DELETE FROM Files WHERE userID = $1 AND objectKey = $2`
+	_, err := s.db.Exec(query, userID, objectKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+	return nil
+}
+
+// deleteFilesWithPrefix removes every file stored below prefix from the index.
+func (s *service) deleteFilesWithPrefix(userID int, prefix string) error {
+	query := `DELETE FROM Files WHERE userID = $1 AND objectKey LIKE $2`
+	_, err := s.db.Exec(query, userID, escapeLike(prefix)+"%")
+	if err != nil {
+		return fmt.Errorf("failed to delete files: %v", err)
+	}
+	return nil
+}
+
+// Point an indexed file at its new key, keeping its fileID. A file that was
+// overwritten at the new key is dropped from the index, in the same
+// transaction so a failed move leaves both rows as they were.
+func (s *service) MoveFile(userID int, oldKey, newKey string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		folderID, err := s.folderIDForKey(tx, userID, newKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM Files WHERE userID = $1 AND objectKey = $2`, userID, newKey)
+		if err != nil {
+			return fmt.Errorf("failed to move file: %v", err)
+		}
+
+		query := `
+			UPDATE Files SET objectKey = $3, fileName = $4, folderID = $5
+			WHERE userID = $1 AND objectKey = $2
+		`
+		_, err = tx.Exec(query, userID, oldKey, newKey, path.Base(newKey), folderID)
+		if err != nil {
+			return fmt.Errorf("failed to move file: %v", err)
+		}
+		return nil
+	})
+}
+
+// moveFilesWithPrefix re-prefixes every indexed file below oldPrefix, keeping
+// their fileIDs. Files already indexed at the new keys are dropped first.
+func (s *service) moveFilesWithPrefix(q execer, userID int, oldPrefix, newPrefix string) error {
+	query := `
+		DELETE FROM Files WHERE userID = $1 AND objectKey IN (
+			SELECT $3::text || substr(objectKey, length($2::text) + 1)
+			FROM Files WHERE userID = $1 AND objectKey LIKE $4
+		)
+	`
+	_, err := q.Exec(query, userID, oldPrefix, newPrefix, escapeLike(oldPrefix)+"%")
+	if err != nil {
+		return fmt.Errorf("failed to move files: %v", err)
+	}
+
+	query = `
+		UPDATE Files SET objectKey = $3::text || substr(objectKey, length($2::text) + 1)
+		WHERE userID = $1 AND objectKey LIKE $4
+	`
+	_, err = q.Exec(query, userID, oldPrefix, newPrefix, escapeLike(oldPrefix)+"%")
+	if err != nil {
+		return fmt.Errorf("failed to move files: %v", err)
+	}
+	return s.relinkFiles(q, userID, newPrefix)
+}
+
+// Index a copy of a file under newKey
+func (s *service) CopyFile(userID int, oldKey, newKey string) error {
+	folderID, err := s.folderIDForKey(s.db, userID, newKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO Files (fileName, objectKey, fileType, fileSize, lastModifiedData, folderID, userID)
+		SELECT $4::text, $3::text, fileType, fileSize, NOW(), $5::int, userID
+		FROM Files WHERE userID = $1 AND objectKey = $2
+		ON CONFLICT (userID, objectKey) DO NOTHING
+	`
+	_, err = s.db.Exec(query, userID, oldKey, newKey, path.Base(newKey), folderID)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %v", err)
+	}
+	if err := s.copyFileContent(s.db, userID, oldKey, newKey, escapeLike(oldKey)); err != nil {
+		return err
+	}
+	return s.copyFileTags(s.db, userID, oldKey, newKey, escapeLike(oldKey))
+}
+
+// copyFilesWithPrefix indexes copies of every file below oldPrefix under
+// newPrefix.
+func (s *service) copyFilesWithPrefix(q execer, userID int, oldPrefix, newPrefix string) error {
+	query := `
+		INSERT INTO Files (fileName, objectKey, fileType, fileSize, lastModifiedData, userID)
+		SELECT fileName, $3::text || substr(objectKey, length($2::text) + 1), fileType, fileSize, NOW(), userID
+		FROM Files WHERE userID = $1 AND objectKey LIKE $4
+		ON CONFLICT (userID, objectKey) DO NOTHING
+	`
+	_, err := q.Exec(query, userID, oldPrefix, newPrefix, escapeLike(oldPrefix)+"%")
+	if err != nil {
+		return fmt.Errorf("failed to copy files: %v", err)
+	}
+	if err := s.relinkFiles(q, userID, newPrefix); err != nil {
+		return err
+	}
+	if err := s.copyFileContent(q, userID, oldPrefix, newPrefix, escapeLike(oldPrefix)+"%"); err != nil {
+		return err
+	}
+	return s.copyFileTags(q, userID, oldPrefix, newPrefix, escapeLike(oldPrefix)+"%")
+}
+
+// relinkFiles points the files below prefix at the Folder rows matching
+// their keys.
+func (s *service) relinkFiles(q execer, userID int, prefix string) error {
+	query := `
+		UPDATE Files f SET folderID = d.folderID
+		FROM Folder d
+		WHERE f.userID = $1 AND f.objectKey LIKE $2
+			AND d.userID = f.userID
+			AND d.folderPath = regexp_replace(f.objectKey, '/[^/]*$', '')
+	`
+	_, err := q.Exec(query, userID, escapeLike(prefix)+"%")
+	if err != nil {
+		return fmt.Errorf("failed to link files to folders: %v", err)
+	}
+	return nil
+}
+
+// Search the index and return one page of matches with the total match count
+func (s *service) SearchFiles(userID int, search FileSearch) ([]FileRecord, int, error) {
+	where := []string{"userID = $1"}
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if search.Name != "" {
+		if strings.ContainsAny(search.Name, "*?") {
+			where = append(where, "fileName ILIKE "+arg(globToLike(search.Name)))
+		} else {
+			where = append(where, "fileName ILIKE "+arg("%"+escapeLike(search.Name)+"%"))
+		}
+	}
+	if search.ContentType != "" {
+		if strings.HasSuffix(search.ContentType, "/") {
+			where = append(where, "fileType LIKE "+arg(escapeLike(search.ContentType)+"%"))
+		} else {
+			where = append(where, "fileType = "+arg(search.ContentType))
+		}
+	}
+	if search.MinSize > 0 {
+		where = append(where, "fileSize >= "+arg(search.MinSize))
+	}
+	if search.MaxSize > 0 {
+		where = append(where, "fileSize <= "+arg(search.MaxSize))
+	}
+	if !search.ModifiedAfter.IsZero() {
+		where = append(where, "lastModifiedData >= "+arg(search.ModifiedAfter))
+	}
+	if !search.ModifiedBefore.IsZero() {
+		where = append(where, "lastModifiedData < "+arg(search.ModifiedBefore))
+	}
+	if search.Folder != "" {
+		where = append(where, "objectKey LIKE "+arg(escapeLike(search.Folder)+"%"))
+	}
+	if search.ExcludeFolder != "" {
+		where = append(where, "objectKey NOT LIKE "+arg(escapeLike(search.ExcludeFolder)+"%"))
+	}
+	if len(search.Tags) > 0 {
+		where = append(where, tagFilter("Files", arg(search.Tags), len(search.Tags)))
+	}
+	conditions := strings.Join(where, " AND ")
+
+	var total int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM Files WHERE `+conditions, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %v", err)
+	}
+
+	query := `
+		SELECT fileID, fileName, objectKey, fileType, fileSize, uploadDate, lastModifiedData, ` + tagsColumn("Files") + `
+		FROM Files WHERE ` + conditions + `
+		ORDER BY lower(fileName), objectKey
+		LIMIT ` + arg(search.Limit) + ` OFFSET ` + arg(search.Offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search files: %v", err)
+	}
+	defer rows.Close()
+
+	files := make([]FileRecord, 0)
+	for rows.Next() {
+		var f FileRecord
+		var tags string
+		if err := rows.Scan(&f.FileID, &f.FileName, &f.ObjectKey, &f.FileType, &f.FileSize, &f.UploadDate, &f.LastModified, &tags); err != nil {
+			return nil, 0, fmt.Errorf("failed to read search result: %v", err)
+		}
+		f.Tags = splitTags(tags)
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to search files: %v", err)
+	}
+	return files, total, nil
+}
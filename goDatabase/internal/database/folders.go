@@ -0,0 +1,439 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// FolderRecord is one row of the Folder table.
+type FolderRecord struct {
+	FolderID     int       `json:"folderID"`
+	FolderName   string    `json:"name"`
+	FolderPath   string    `json:"path"`
+	CreationDate time.Time `json:"lastModified"`
+}
+
+// folderParent returns the parent of a folder path or object key, or "" for
+// the bucket root.
+func folderParent(p string) string {
+	parent := path.Dir(p)
+	if parent == "." || parent == "/" {
+		return ""
+	}
+	return parent
+}
+
+// Create a folder and any missing parents, returning its folderID
+func (s *service) EnsureFolder(userID int, folderPath string) (int, error) {
+	return s.ensureFolder(s.db, userID, folderPath)
+}
+
+// ensureFolder is EnsureFolder run on q.
+func (s *service) ensureFolder(q execer, userID int, folderPath string) (int, error) {
+	folderPath = strings.Trim(folderPath, "/")
+	if folderPath == "" {
+		return 0, fmt.Errorf("failed to ensure folder: empty path")
+	}
+
+	var parentID sql.NullInt64
+	if parent := folderParent(folderPath); parent != "" {
+		id, err := s.ensureFolder(q, userID, parent)
+		if err != nil {
+			return 0, err
+		}
+		parentID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	query := `
+		INSERT INTO Folder (folderName, folderPath, userID, parentFolderID)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (userID, folderPath) DO UPDATE SET parentFolderID = EXCLUDED.parentFolderID
+		RETURNING folderID
+	`
+	var folderID int
+	err := q.QueryRow(query, path.Base(folderPath), folderPath, userID, parentID).Scan(&folderID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to ensure folder: %v", err)
+	}
+	return folderID, nil
+}
+
+// folderIDForKey returns the folderID of the folder holding objectKey, creating
+// it if needed. Keys at the bucket root have no folder.
+func (s *service) folderIDForKey(q execer, userID int, objectKey string) (sql.NullInt64, error) {
+	parent := folderParent(objectKey)
+	if parent == "" {
+		return sql.NullInt64{}, nil
+	}
+	id, err := s.ensureFolder(q, userID, parent)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: int64(id), Valid: true}, nil
+}
+
+// Remove a folder, its subfolders and every file below it from the index
+func (s *service) DeleteFolder(userID int, folderPath string) error {
+	if err := s.deleteFilesWithPrefix(userID, folderPath+"/"); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM Folder WHERE userID = $1 AND (folderPath = $2 OR folderPath LIKE $3)`
+	_, err := s.db.Exec(query, userID, folderPath, escapeLike(folderPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %v", err)
+	}
+	return nil
+}
+
+// Move a folder and everything below it to newPath. Folder and file IDs are
+// kept unless the move merges into a folder that already exists. The index
+// is left as it was if any step fails.
+func (s *service) MoveFolder(userID int, oldPath, newPath string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM Folder WHERE userID = $1 AND folderPath = $2)`,
+			userID, newPath).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to move folder: %v", err)
+		}
+
+		if exists {
+			// Merge: make sure every destination folder exists, move the files
+			// and stars over and drop the old folder rows
+			if err := s.copyFolderRows(tx, userID, oldPath, newPath); err != nil {
+				return err
+			}
+			if err := s.moveFilesWithPrefix(tx, userID, oldPath+"/", newPath+"/"); err != nil {
+				return err
+			}
+			if err := s.moveFolderStars(tx, userID, oldPath, newPath); err != nil {
+				return err
+			}
+			query := `DELETE FROM Folder WHERE userID = $1 AND (folderPath = $2 OR folderPath LIKE $3)`
+			_, err := tx.Exec(query, userID, oldPath, escapeLike(oldPath)+"/%")
+			if err != nil {
+				return fmt.Errorf("failed to move folder: %v", err)
+			}
+			return nil
+		}
+
+		var parentID sql.NullInt64
+		if parent := folderParent(newPath); parent != "" {
+			id, err := s.ensureFolder(tx, userID, parent)
+			if err != nil {
+				return err
+			}
+			parentID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+
+		query := `
+			UPDATE Folder SET folderPath = $3::text || substr(folderPath, length($2::text) + 1)
+			WHERE userID = $1 AND (folderPath = $2 OR folderPath LIKE $4)
+		`
+		_, err = tx.Exec(query, userID, oldPath, newPath, escapeLike(oldPath)+"/%")
+		if err != nil {
+			return fmt.Errorf("failed to move folder: %v", err)
+		}
+
+		query = `UPDATE Folder SET folderName = $3, parentFolderID = $4 WHERE userID = $1 AND folderPath = $2`
+		_, err = tx.Exec(query, userID, newPath, path.Base(newPath), parentID)
+		if err != nil {
+			return fmt.Errorf("failed to move folder: %v", err)
+		}
+
+		return s.moveFilesWithPrefix(tx, userID, oldPath+"/", newPath+"/")
+	})
+}
+
+// Index a copy of a folder and everything below it under newPath, all or
+// nothing
+func (s *service) CopyFolder(userID int, oldPath, newPath string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if err := s.copyFolderRows(tx, userID, oldPath, newPath); err != nil {
+			return err
+		}
+		return s.copyFilesWithPrefix(tx, userID, oldPath+"/", newPath+"/")
+	})
+}
+
+// copyFolderRows creates Folder rows at newPath for oldPath and each of its
+// subfolders, keeping rows that already exist, and links up their parents.
+func (s *service) copyFolderRows(q execer, userID int, oldPath, newPath string) error {
+	if _, err := s.ensureFolder(q, userID, newPath); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO Folder (folderName, folderPath, userID)
+		SELECT folderName, $3::text || substr(folderPath, length($2::text) + 1), userID
+		FROM Folder WHERE userID = $1 AND folderPath LIKE $4
+		ON CONFLICT (userID, folderPath) DO NOTHING
+	`
+	_, err := q.Exec(query, userID, oldPath, newPath, escapeLike(oldPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to copy folders: %v", err)
+	}
+
+	query = `
+		UPDATE Folder c SET parentFolderID = p.folderID
+		FROM Folder p
+		WHERE c.userID = $1 AND c.folderPath LIKE $2
+			AND p.userID = c.userID
+			AND p.folderPath = regexp_replace(c.folderPath, '/[^/]*$', '')
+	`
+	_, err = q.Exec(query, userID, escapeLike(newPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to link copied folders: %v", err)
+	}
+	return nil
+}
+
+// ListOptions controls the order and paging of ListFolder.
+type ListOptions struct {
+	Tags  []string    // only list files carrying all of these tags, and no folders
+	Sort  string      // "name", "size", "modified" or "type"
+	Desc  bool        // sort descending
+	Limit int         // page size, 0 for everything
+	After *ListCursor // continue after this item
+}
+
+// ListCursor marks the last item of a page. Folders (Kind 0) always come
+// before files (Kind 1).
+type ListCursor struct {
+	Kind  int    `json:"k"`
+	Value string `json:"v"` // sort key of the item as text
+	Path  string `json:"p"`
+}
+
+// listSortKeys maps each sort order to its key expression and the type the
+// cursor value is cast back to.
+var listSortKeys = map[string][2]string{
+	"name":     {"lower(name)", "text"},
+	"size":     {"size", "bigint"},
+	"modified": {"modified", "timestamp"},
+	"type":     {"lower(type)", "text"},
+}
+
+// List one page of the folders and files directly inside folderPath ("" for
+// the root), folders first. The returned cursor is nil on the last page.
+func (s *service) ListFolder(userID int, folderPath string, opts ListOptions) ([]FolderRecord, []FileRecord, *ListCursor, error) {
+	sortKey, ok := listSortKeys[opts.Sort]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("failed to list folder: unknown sort %q", opts.Sort)
+	}
+	sortExpr, sortType := sortKey[0], sortKey[1]
+
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Children of the root have no slash; children of "a/b" look like "a/b/x"
+	childCondition := func(column string) string {
+		return fmt.Sprintf("position('/' in %s) = 0", column)
+	}
+	if folderPath != "" {
+		prefix := folderPath + "/"
+		like, start := arg(escapeLike(prefix)+"%"), arg(len(prefix)+1)
+		childCondition = func(column string) string {
+			return fmt.Sprintf("%s LIKE %s AND position('/' in substr(%s, %s)) = 0", column, like, column, start)
+		}
+	}
+
+	items := `
+		SELECT 1 AS kind, fileID AS id, fileName AS name, objectKey AS path, fileType AS type, fileSize AS size,
+			lastModifiedData AS modified, uploadDate AS uploaded, ` + tagsColumn("Files") + ` AS tags
+		FROM Files WHERE userID = $1 AND ` + childCondition("objectKey")
+	if len(opts.Tags) > 0 {
+		items += " AND " + tagFilter("Files", arg(opts.Tags), len(opts.Tags))
+	} else {
+		items = `
+		SELECT 0, folderID, folderName, folderPath, 'folder', 0::bigint,
+			creationDate, creationDate, ''
+		FROM Folder WHERE userID = $1 AND ` + childCondition("folderPath") + `
+		UNION ALL` + items
+	}
+
+	dir, cmp := "ASC", ">"
+	if opts.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	where := ""
+	if opts.After != nil {
+		kind := arg(opts.After.Kind)
+		where = fmt.Sprintf("WHERE kind > %s OR (kind = %s AND (%s, path) %s (%s::%s, %s::text))",
+			kind, kind, sortExpr, cmp, arg(opts.After.Value), sortType, arg(opts.After.Path))
+	}
+
+	// One row more than the page tells whether there is a next page
+	limit := "ALL"
+	if opts.Limit > 0 {
+		limit = arg(opts.Limit + 1)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT kind, id, name, path, type, size, modified, uploaded, tags, (%s)::text
+		FROM (%s
+		) items
+		%s
+		ORDER BY kind, %s %s, path %s
+		LIMIT %s
+	`, sortExpr, items, where, sortExpr, dir, dir, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list folder: %v", err)
+	}
+	defer rows.Close()
+
+	folders := make([]FolderRecord, 0)
+	files := make([]FileRecord, 0)
+	var last, next *ListCursor
+	for rows.Next() {
+		if opts.Limit > 0 && len(folders)+len(files) == opts.Limit {
+			next = last
+			break
+		}
+
+		var kind, id int
+		var name, itemPath, itemType, tags, sortValue string
+		var size int64
+		var modified, uploaded time.Time
+		if err := rows.Scan(&kind, &id, &name, &itemPath, &itemType, &size, &modified, &uploaded, &tags, &sortValue); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read folder entry: %v", err)
+		}
+
+		if kind == 0 {
+			folders = append(folders, FolderRecord{FolderID: id, FolderName: name, FolderPath: itemPath, CreationDate: modified})
+		} else {
+			files = append(files, FileRecord{
+				FileID:       id,
+				FileName:     name,
+				ObjectKey:    itemPath,
+				FileType:     itemType,
+				FileSize:     size,
+				UploadDate:   uploaded,
+				LastModified: modified,
+				Tags:         splitTags(tags),
+			})
+		}
+		last = &ListCursor{Kind: kind, Value: sortValue, Path: itemPath}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list folder: %v", err)
+	}
+
+	return folders, files, next, nil
+}
+
+// Check whether anything has been indexed for a user yet
+func (s *service) HasIndexedItems(userID int) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM Files WHERE userID = $1)
+			OR EXISTS (SELECT 1 FROM Folder WHERE userID = $1)
+	`
+	err := s.db.QueryRow(query, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check index: %v", err)
+	}
+	return exists, nil
+}
+
+// Rebuild a user's index from a full listing of their bucket. Rows for
+// objects that no longer exist are removed; existing rows keep their IDs.
+// The rows are written in a few batched statements in one transaction, so
+// listings never see a half rebuilt index.
+func (s *service) ReindexUser(userID int, folders []string, files []FileRecord) error {
+	keepFolders := make(map[string]bool)
+	addFolder := func(p string) {
+		for ; p != ""; p = folderParent(p) {
+			keepFolders[p] = true
+		}
+	}
+
+	for _, folderPath := range folders {
+		addFolder(strings.Trim(folderPath, "/"))
+	}
+
+	keys := make([]string, 0, len(files))
+	names := make([]string, 0, len(files))
+	types := make([]string, 0, len(files))
+	sizes := make([]int64, 0, len(files))
+	modified := make([]time.Time, 0, len(files))
+	for _, f := range files {
+		addFolder(folderParent(f.ObjectKey))
+		keys = append(keys, f.ObjectKey)
+		names = append(names, path.Base(f.ObjectKey))
+		types = append(types, f.FileType)
+		sizes = append(sizes, f.FileSize)
+		modified = append(modified, f.LastModified)
+	}
+
+	folderPaths := make([]string, 0, len(keepFolders))
+	folderNames := make([]string, 0, len(keepFolders))
+	for p := range keepFolders {
+		folderPaths = append(folderPaths, p)
+		folderNames = append(folderNames, path.Base(p))
+	}
+
+	return s.inTx(func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO Folder (folderName, folderPath, userID)
+			SELECT name, folderPath, $1 FROM unnest($2::text[], $3::text[]) AS t(name, folderPath)
+			ON CONFLICT (userID, folderPath) DO NOTHING
+		`
+		_, err := tx.Exec(query, userID, folderNames, folderPaths)
+		if err != nil {
+			return fmt.Errorf("failed to index folders: %v", err)
+		}
+
+		_, err = tx.Exec(`DELETE FROM Folder WHERE userID = $1 AND NOT (folderPath = ANY($2))`, userID, folderPaths)
+		if err != nil {
+			return fmt.Errorf("failed to remove stale folders: %v", err)
+		}
+
+		query = `
+			UPDATE Folder c SET parentFolderID = (
+				SELECT p.folderID FROM Folder p
+				WHERE p.userID = c.userID AND c.folderPath LIKE '%/%'
+					AND p.folderPath = regexp_replace(c.folderPath, '/[^/]*$', '')
+			)
+			WHERE c.userID = $1
+		`
+		_, err = tx.Exec(query, userID)
+		if err != nil {
+			return fmt.Errorf("failed to link folders: %v", err)
+		}
+
+		query = `
+			INSERT INTO Files (fileName, objectKey, fileType, fileSize, lastModifiedData, folderID, userID)
+			SELECT t.name, t.objectKey, t.fileType, t.fileSize, t.modified, d.folderID, $1
+			FROM unnest($2::text[], $3::text[], $4::text[], $5::bigint[], $6::timestamp[])
+				AS t(name, objectKey, fileType, fileSize, modified)
+			LEFT JOIN Folder d ON d.userID = $1 AND t.objectKey LIKE '%/%'
+				AND d.folderPath = regexp_replace(t.objectKey, '/[^/]*$', '')
+			ON CONFLICT (userID, objectKey) DO UPDATE SET
+				fileType = EXCLUDED.fileType,
+				fileSize = EXCLUDED.fileSize,
+				lastModifiedData = EXCLUDED.lastModifiedData,
+				folderID = EXCLUDED.folderID
+		`
+		_, err = tx.Exec(query, userID, names, keys, types, sizes, modified)
+		if err != nil {
+			return fmt.Errorf("failed to index files: %v", err)
+		}
+
+		_, err = tx.Exec(`DELETE FROM Files WHERE userID = $1 AND NOT (objectKey = ANY($2))`, userID, keys)
+		if err != nil {
+			return fmt.Errorf("failed to remove stale files: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxNotificationsPerUser bounds how many notifications are kept for each
+// user.
+const maxNotificationsPerUser = 200
+
+// Notification tells a user about something that happened to their files
+// while they were away, such as uploads through a file request.
+type Notification struct {
+	NotificationID int       `json:"id"`
+	Kind           string    `json:"kind"`
+	Message        string    `json:"message"`
+	ItemPath       string    `json:"path"`
+	Read           bool      `json:"read"`
+	CreationDate   time.Time `json:"creationDate"`
+}
+
+// Add a notification for a user. The oldest beyond maxNotificationsPerUser
+// are dropped.
+func (s *service) AddNotification(userID int, kind, message, itemPath string) error {
+	query := `INSERT INTO notifications (userID, kind, message, itemPath) VALUES ($1, $2, $3, $4)`
+	if _, err := s.db.Exec(query, userID, kind, message, itemPath); err != nil {
+		return fmt.Errorf("failed to add notification: %v", err)
+	}
+
+	query = `
+		DELETE FROM notifications WHERE userID = $1 AND notificationID <= (
+			SELECT notificationID FROM notifications WHERE userID = $1
+			ORDER BY notificationID DESC OFFSET $2 LIMIT 1
+		)
+	`
+	if _, err := s.db.Exec(query, userID, maxNotificationsPerUser); err != nil {
+		return fmt.Errorf("failed to prune notifications: %v", err)
+	}
+	return nil
+}
+
+// List a user's notifications, newest first, and count the unread ones
+func (s *service) ListNotifications(userID int, unreadOnly bool, limit int) ([]Notification, int, error) {
+	query := `
+		SELECT notificationID, kind, message, itemPath, isRead, creationDate
+		FROM notifications
+		WHERE userID = $1 AND (NOT $2 OR NOT isRead)
+		ORDER BY notificationID DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list notifications: %v", err)
+	}
+	defer rows.Close()
+
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.NotificationID, &n.Kind, &n.Message, &n.ItemPath, &n.Read, &n.CreationDate); err != nil {
+			return nil, 0, fmt.Errorf("failed to read notification: %v", err)
+		}
+		notifications = append(notifications, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to list notifications: %v", err)
+	}
+
+	var unread int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE userID = $1 AND NOT isRead`, userID).Scan(&unread)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count notifications: %v", err)
+	}
+	return notifications, unread, nil
+}
+
+// Mark some of a user's notifications as read, or all of them if ids is empty
+func (s *service) MarkNotificationsRead(userID int, ids []int) error {
+	query := `UPDATE notifications SET isRead = TRUE WHERE userID = $1 AND (cardinality($2::int[]) = 0 OR notificationID = ANY($2))`
+	if ids == nil {
+		ids = []int{}
+	}
+	if _, err := s.db.Exec(query, userID, ids); err != nil {
+		return fmt.Errorf("failed to mark notifications read: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrShareNotFound is returned for share tokens that do not exist or do not
+// belong to the user.
+var ErrShareNotFound = errors.New("share link not found")
+
+// ShareLink is a public link to a file or folder of a user.
+type ShareLink struct {
+	LinkID        int        `json:"-"`
+	Token         string     `json:"token"`
+	UserID        int        `json:"-"`
+	ItemPath      string     `json:"path"`
+	ItemType      string     `json:"type"` // "file" or "folder"
+	PasswordHash  string     `json:"-"`
+	HasPassword   bool       `json:"hasPassword"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	MaxDownloads  int        `json:"maxDownloads,omitempty"` // 0 means unlimited
+	DownloadCount int        `json:"downloadCount"`
+	Revoked       bool       `json:"revoked"`
+	CreationDate  time.Time  `json:"creationDate"`
+}
+
+// ShareAccess is one recorded use of a share link.
+type ShareAccess struct {
+	AccessDate time.Time `json:"accessDate"`
+	IPAddress  string    `json:"ipAddress"`
+	UserAgent  string    `json:"userAgent"`
+	Outcome    string    `json:"outcome"`
+}
+
+const shareLinkColumns = `linkID, token, userID, itemPath, itemType, COALESCE(passwordHash, ''),
+	expiresAt, COALESCE(maxDownloads, 0), downloadCount, revoked, creationDate`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var l ShareLink
+	var expiresAt sql.NullTime
+	err := row.Scan(&l.LinkID, &l.Token, &l.UserID, &l.ItemPath, &l.ItemType, &l.PasswordHash,
+		&expiresAt, &l.MaxDownloads, &l.DownloadCount, &l.Revoked, &l.CreationDate)
+	if err != nil {
+		return l, err
+	}
+	if expiresAt.Valid {
+		l.ExpiresAt = &expiresAt.Time
+	}
+	l.HasPassword = l.PasswordHash != ""
+	return l, nil
+}
+
+// Create a share link. Token, UserID, ItemPath and ItemType must be set; an
+// empty PasswordHash, nil ExpiresAt and zero MaxDownloads disable those checks.
+func (s *service) CreateShareLink(link ShareLink) (ShareLink, error) {
+	var passwordHash, maxDownloads any
+	if link.PasswordHash != "" {
+		passwordHash = link.PasswordHash
+	}
+	if link.MaxDownloads > 0 {
+		maxDownloads = link.MaxDownloads
+	}
+
+	query := `
+		INSERT INTO shareLinks (token, userID, itemPath, itemType, passwordHash, expiresAt, maxDownloads)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + shareLinkColumns
+	created, err := scanShareLink(s.db.QueryRow(query, link.Token, link.UserID, link.ItemPath, link.ItemType, passwordHash, link.ExpiresAt, maxDownloads))
+	if err != nil {
+		return ShareLink{}, fmt.Errorf("failed to create share link: %v", err)
+	}
+	return created, nil
+}
+
+// Look up a share link by its token, revoked or not
+func (s *service) GetShareLink(token string) (ShareLink, error) {
+	query := `SELECT ` + shareLinkColumns + ` FROM shareLinks WHERE token = $1`
+	link, err := scanShareLink(s.db.QueryRow(query, token))
+	if err == sql.ErrNoRows {
+		return ShareLink{}, ErrShareNotFound
+	}
+	if err != nil {
+		return ShareLink{}, fmt.Errorf("failed to get share link: %v", err)
+	}
+	return link, nil
+}
+
+// List a user's share links, newest first, optionally only those for itemPath
+func (s *service) ListShareLinks(userID int, itemPath string) ([]ShareLink, error) {
+	query := `
+		SELECT ` + shareLinkColumns + ` FROM shareLinks
+		WHERE userID = $1 AND ($2::text = '' OR itemPath = $2)
+		ORDER BY creationDate DESC, linkID DESC
+	`
+	rows, err := s.db.Query(query, userID, itemPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share links: %v", err)
+	}
+	defer rows.Close()
+
+	links := make([]ShareLink, 0)
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read share link: %v", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list share links: %v", err)
+	}
+	return links, nil
+}
+
+// Revoke one of a user's share links. Revoking twice is not an error.
+func (s *service) RevokeShareLink(userID int, token string) error {
+	result, err := s.db.Exec(`UPDATE shareLinks SET revoked = TRUE WHERE userID = $1 AND token = $2`, userID, token)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// Count a download against a share link. It returns false without counting
+// if the link is revoked, expired or has no downloads left, so concurrent
+// requests cannot go over the limit.
+func (s *service) ClaimShareDownload(linkID int) (bool, error) {
+	query := `
+		UPDATE shareLinks SET downloadCount = downloadCount + 1
+		WHERE linkID = $1 AND NOT revoked
+			AND (expiresAt IS NULL OR expiresAt > CURRENT_TIMESTAMP)
+			AND (maxDownloads IS NULL OR downloadCount < maxDownloads)
+	`
+	result, err := s.db.Exec(query, linkID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count share download: %v", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count share download: %v", err)
+	}
+	return n > 0, nil
+}
+
+// Record an access to a share link and how it went
+func (s *service) LogShareAccess(linkID int, ipAddress, userAgent, outcome string) error {
+	query := `INSERT INTO shareAccessLog (linkID, ipAddress, userAgent, outcome) VALUES ($1, $2, $3, $4)`
+	if _, err := s.db.Exec(query, linkID, ipAddress, userAgent, outcome); err != nil {
+		return fmt.Errorf("failed to log share access: %v", err)
+	}
+	return nil
+}
+
+// List the most recent accesses to one of a user's share links
+func (s *service) ListShareAccess(userID int, token string, limit int) ([]ShareAccess, error) {
+	query := `
+		SELECT a.accessDate, a.ipAddress, a.userAgent, a.outcome
+		FROM shareAccessLog a JOIN shareLinks l ON l.linkID = a.linkID
+		WHERE l.userID = $1 AND l.token = $2
+		ORDER BY a.accessID DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, userID, token, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share access: %v", err)
+	}
+	defer rows.Close()
+
+	accesses := make([]ShareAccess, 0)
+	for rows.Next() {
+		var a ShareAccess
+		if err := rows.Scan(&a.AccessDate, &a.IPAddress, &a.UserAgent, &a.Outcome); err != nil {
+			return nil, fmt.Errorf("failed to read share access: %v", err)
+		}
+		accesses = append(accesses, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list share access: %v", err)
+	}
+	return accesses, nil
+}
+
+// Point a user's share links at the new path of a moved file or folder,
+// including links to items inside a moved folder
+func (s *service) MoveShareLinks(userID int, oldPath, newPath string) error {
+	query := `
+		UPDATE shareLinks SET itemPath = $3::text || substr(itemPath, length($2::text) + 1)
+		WHERE userID = $1 AND (itemPath = $2 OR itemPath LIKE $4)
+	`
+	_, err := s.db.Exec(query, userID, oldPath, newPath, escapeLike(oldPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to move share links: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotIndexed is returned when a path has no row in the Folder or Files
+// index.
+var ErrNotIndexed = errors.New("item is not indexed")
+
+// StarredItem is a starred file or folder.
+type StarredItem struct {
+	Type         string    `json:"type"` // "file" or "folder"
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	StarredDate  time.Time `json:"starredDate"`
+}
+
+// starTarget returns the stars column and ID referencing the file or folder
+// at itemPath.
+func (s *service) starTarget(userID int, itemPath string, isFolder bool) (string, int, error) {
+	column, query := "fileID", `SELECT fileID FROM Files WHERE userID = $1 AND objectKey = $2`
+	if isFolder {
+		column, query = "folderID", `SELECT folderID FROM Folder WHERE userID = $1 AND folderPath = $2`
+	}
+
+	var id int
+	err := s.db.QueryRow(query, userID, itemPath).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", 0, ErrNotIndexed
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to find item: %v", err)
+	}
+	return column, id, nil
+}
+
+// Star a file or folder. Starring an item twice is not an error.
+func (s *service) StarItem(userID int, itemPath string, isFolder bool) error {
+	column, id, err := s.starTarget(userID, itemPath, isFolder)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO stars (userID, %[1]s) VALUES ($1, $2) ON CONFLICT (userID, %[1]s) DO NOTHING`, column)
+	if _, err := s.db.Exec(query, userID, id); err != nil {
+		return fmt.Errorf("failed to star item: %v", err)
+	}
+	return nil
+}
+
+// Remove the star from a file or folder
+func (s *service) UnstarItem(userID int, itemPath string, isFolder bool) error {
+	column, id, err := s.starTarget(userID, itemPath, isFolder)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`DELETE FROM stars WHERE userID = $1 AND %s = $2`, column)
+	if _, err := s.db.Exec(query, userID, id); err != nil {
+		return fmt.Errorf("failed to unstar item: %v", err)
+	}
+	return nil
+}
+
+// List a user's starred files and folders, most recently starred first
+func (s *service) ListStarred(userID int) ([]StarredItem, error) {
+	query := `
+		SELECT 'file', f.fileName, f.objectKey, f.fileType, f.fileSize, f.lastModifiedData, st.starredDate
+		FROM stars st JOIN Files f ON f.fileID = st.fileID
+		WHERE st.userID = $1
+		UNION ALL
+		SELECT 'folder', d.folderName, d.folderPath, 'folder', 0, d.creationDate, st.starredDate
+		FROM stars st JOIN Folder d ON d.folderID = st.folderID
+		WHERE st.userID = $1
+		ORDER BY 7 DESC
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list starred items: %v", err)
+	}
+	defer rows.Close()
+
+	items := make([]StarredItem, 0)
+	for rows.Next() {
+		var item StarredItem
+		if err := rows.Scan(&item.Type, &item.Name, &item.Path, &item.ContentType, &item.Size, &item.LastModified, &item.StarredDate); err != nil {
+			return nil, fmt.Errorf("failed to read starred item: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list starred items: %v", err)
+	}
+	return items, nil
+}
+
+// moveFolderStars re-points stars on oldPath and its subfolders at the
+// matching folders below newPath, for moves that merge into existing folders.
+func (s *service) moveFolderStars(q execer, userID int, oldPath, newPath string) error {
+	query := `
+		UPDATE stars st SET folderID = n.folderID
+		FROM Folder o JOIN Folder n ON n.userID = o.userID
+			AND n.folderPath = $3::text || substr(o.folderPath, length($2::text) + 1)
+		WHERE st.folderID = o.folderID
+			AND o.userID = $1 AND (o.folderPath = $2 OR o.folderPath LIKE $4)
+			AND NOT EXISTS (SELECT 1 FROM stars x WHERE x.userID = st.userID AND x.folderID = n.folderID)
+	`
+	_, err := q.Exec(query, userID, oldPath, newPath, escapeLike(oldPath)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to move folder stars: %v", err)
+	}
+	return nil
+}
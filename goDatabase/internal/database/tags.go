@@ -0,0 +1,167 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TagCount is a tag together with the number of files carrying it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// tagSeparator joins tag names in query results. Tag names cannot contain it.
+const tagSeparator = ","
+
+// tagsColumn selects the tags of the Files row aliased as table, joined into
+// one string.
+func tagsColumn(table string) string {
+	return fmt.Sprintf(`COALESCE((
+			SELECT string_agg(t.tagName, '%s' ORDER BY t.tagName)
+			FROM fileTags ft JOIN Tags t ON t.tagID = ft.tagID
+			WHERE ft.fileID = %s.fileID
+		), '')`, tagSeparator, table)
+}
+
+// tagFilter restricts the Files row aliased as table to files carrying every
+// tag in the array parameter tagsArg. The tags must not repeat.
+func tagFilter(table, tagsArg string, tagCount int) string {
+	return fmt.Sprintf(`%s.fileID IN (
+			SELECT ft.fileID FROM fileTags ft JOIN Tags t ON t.tagID = ft.tagID
+			WHERE t.tagName = ANY(%s)
+			GROUP BY ft.fileID HAVING COUNT(*) = %d
+		)`, table, tagsArg, tagCount)
+}
+
+// splitTags turns the output of tagsColumn back into a list.
+func splitTags(joined string) []string {
+	if joined == "" {
+		return []string{}
+	}
+	return strings.Split(joined, tagSeparator)
+}
+
+// Add tags to indexed files. Tags that do not exist yet are created.
+func (s *service) AddTags(userID int, objectKeys, tags []string) error {
+	query := `
+		INSERT INTO Tags (tagName, userID)
+		SELECT unnest($2::text[]), $1
+		ON CONFLICT (userID, tagName) DO NOTHING
+	`
+	_, err := s.db.Exec(query, userID, tags)
+	if err != nil {
+		return fmt.Errorf("failed to create tags: %v", err)
+	}
+
+	query = `
+		INSERT INTO fileTags (fileID, tagID)
+		SELECT f.fileID, t.tagID
+		FROM Files f JOIN Tags t ON t.userID = f.userID
+		WHERE f.userID = $1 AND f.objectKey = ANY($2) AND t.tagName = ANY($3)
+		ON CONFLICT (fileID, tagID) DO NOTHING
+	`
+	_, err = s.db.Exec(query, userID, objectKeys, tags)
+	if err != nil {
+		return fmt.Errorf("failed to tag files: %v", err)
+	}
+	return nil
+}
+
+// Remove tags from indexed files. Tags left without files are deleted.
+func (s *service) RemoveTags(userID int, objectKeys, tags []string) error {
+	query := `
+		DELETE FROM fileTags ft
+		USING Files f, Tags t
+		WHERE ft.fileID = f.fileID AND ft.tagID = t.tagID
+			AND f.userID = $1 AND f.objectKey = ANY($2)
+			AND t.userID = $1 AND t.tagName = ANY($3)
+	`
+	_, err := s.db.Exec(query, userID, objectKeys, tags)
+	if err != nil {
+		return fmt.Errorf("failed to untag files: %v", err)
+	}
+
+	query = `
+		DELETE FROM Tags t
+		WHERE t.userID = $1 AND NOT EXISTS (SELECT 1 FROM fileTags ft WHERE ft.tagID = t.tagID)
+	`
+	_, err = s.db.Exec(query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove unused tags: %v", err)
+	}
+	return nil
+}
+
+// Get the tags of each indexed file in objectKeys. Keys that are not indexed
+// are left out of the result.
+func (s *service) GetFileTags(userID int, objectKeys []string) (map[string][]string, error) {
+	query := `SELECT f.objectKey, ` + tagsColumn("f") + ` FROM Files f WHERE f.userID = $1 AND f.objectKey = ANY($2)`
+	rows, err := s.db.Query(query, userID, objectKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file tags: %v", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var key, joined string
+		if err := rows.Scan(&key, &joined); err != nil {
+			return nil, fmt.Errorf("failed to read file tags: %v", err)
+		}
+		tags[key] = splitTags(joined)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get file tags: %v", err)
+	}
+	return tags, nil
+}
+
+// List every tag a user has on at least one file, with the number of files
+func (s *service) ListTags(userID int) ([]TagCount, error) {
+	query := `
+		SELECT t.tagName, COUNT(*)
+		FROM Tags t JOIN fileTags ft ON ft.tagID = t.tagID
+		WHERE t.userID = $1
+		GROUP BY t.tagName
+		ORDER BY t.tagName
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %v", err)
+	}
+	defer rows.Close()
+
+	tags := make([]TagCount, 0)
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, fmt.Errorf("failed to read tag: %v", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tags: %v", err)
+	}
+	return tags, nil
+}
+
+// copyFileTags gives the copies below newPrefix the tags of the files below
+// oldPrefix (or of the single file oldPrefix).
+func (s *service) copyFileTags(q execer, userID int, oldPrefix, newPrefix, pattern string) error {
+	query := `
+		INSERT INTO fileTags (fileID, tagID)
+		SELECT n.fileID, ft.tagID
+		FROM Files o
+		JOIN fileTags ft ON ft.fileID = o.fileID
+		JOIN Files n ON n.userID = o.userID
+			AND n.objectKey = $3::text || substr(o.objectKey, length($2::text) + 1)
+		WHERE o.userID = $1 AND o.objectKey LIKE $4
+		ON CONFLICT (fileID, tagID) DO NOTHING
+	`
+	_, err := q.Exec(query, userID, oldPrefix, newPrefix, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to copy file tags: %v", err)
+	}
+	return nil
+}
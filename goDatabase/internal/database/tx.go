@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// execer runs statements either directly on the database or inside a
+// transaction, so helpers can be shared by both.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// inTx runs fn in a transaction that is committed if fn succeeds and rolled
+// back otherwise.
+func (s *service) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrVaultNotFound is returned for users who have not set up a vault.
+	ErrVaultNotFound = errors.New("vault not found")
+	// ErrVaultExists is returned when creating a vault a user already has.
+	ErrVaultExists = errors.New("vault already exists")
+)
+
+// Vault is the key of a user's face-unlocked vault folder, wrapped by a
+// server master key.
+type Vault struct {
+	UserID       int
+	WrappedKey   []byte
+	MasterKeyID  string
+	CreationDate time.Time
+}
+
+// Get the vault key of a user
+func (s *service) GetVault(userID int) (Vault, error) {
+	query := `SELECT userID, wrappedKey, masterKeyID, creationDate FROM userVaults WHERE userID = $1`
+	var v Vault
+	err := s.db.QueryRow(query, userID).Scan(&v.UserID, &v.WrappedKey, &v.MasterKeyID, &v.CreationDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Vault{}, ErrVaultNotFound
+	}
+	if err != nil {
+		return Vault{}, fmt.Errorf("failed to get vault: %v", err)
+	}
+	return v, nil
+}
+
+// Store the vault key of a user. A user has one vault; creating a second
+// one returns ErrVaultExists.
+func (s *service) CreateVault(userID int, wrappedKey []byte, masterKeyID string) error {
+	query := `
+		INSERT INTO userVaults (userID, wrappedKey, masterKeyID)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (userID) DO NOTHING
+	`
+	result, err := s.db.Exec(query, userID, wrappedKey, masterKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to create vault: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrVaultExists
+	}
+	return nil
+}
+
+// List up to limit vault keys wrapped with a master key other than masterKeyID
+func (s *service) VaultsNotWrappedBy(masterKeyID string, limit int) ([]Vault, error) {
+	query := `
+		SELECT userID, wrappedKey, masterKeyID, creationDate
+		FROM userVaults WHERE masterKeyID <> $1
+		ORDER BY userID
+		LIMIT $2
+	`
+	rows, err := s.db.Query(query, masterKeyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vaults: %v", err)
+	}
+	defer rows.Close()
+
+	vaults := make([]Vault, 0)
+	for rows.Next() {
+		var v Vault
+		if err := rows.Scan(&v.UserID, &v.WrappedKey, &v.MasterKeyID, &v.CreationDate); err != nil {
+			return nil, fmt.Errorf("failed to read vaults: %v", err)
+		}
+		vaults = append(vaults, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vaults: %v", err)
+	}
+	return vaults, nil
+}
+
+// Replace the wrapped form of a vault key after wrapping it with another
+// master key
+func (s *service) RewrapVault(userID int, wrappedKey []byte, masterKeyID string) error {
+	query := `UPDATE userVaults SET wrappedKey = $2, masterKeyID = $3 WHERE userID = $1`
+	if _, err := s.db.Exec(query, userID, wrappedKey, masterKeyID); err != nil {
+		return fmt.Errorf("failed to rewrap vault: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownKey is returned when a wrapped key names a master key that is
+// not in the keyring.
+var ErrUnknownKey = errors.New("envelope: unknown master key")
+
+// NewDataKey returns a random data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Keyring holds the server master keys by ID. New data keys are wrapped with
+// the current key; older keys are only kept to unwrap data keys that have
+// not been rewrapped yet.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// MasterKey is one master key of a Keyring.
+type MasterKey struct {
+	ID  string
+	Key []byte // KeySize bytes
+}
+
+// NewKeyring builds a keyring from master keys, the current one first.
+func NewKeyring(keys []MasterKey) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, mk := range keys {
+		if mk.ID == "" {
+			return nil, errors.New("envelope: master key without an ID")
+		}
+		if _, dup := k.keys[mk.ID]; dup {
+			return nil, fmt.Errorf("envelope: master key %q listed twice", mk.ID)
+		}
+		if len(mk.Key) != KeySize {
+			return nil, fmt.Errorf("envelope: master key %q must be %d bytes", mk.ID, KeySize)
+		}
+		block, err := aes.NewCipher(mk.Key)
+		if err != nil {
+			return nil, err
+		}
+		if k.keys[mk.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+		if k.current == "" {
+			k.current = mk.ID
+		}
+	}
+	if k.current == "" {
+		return nil, errors.New("envelope: no master keys")
+	}
+	return k, nil
+}
+
+// ParseKeyring reads master keys from a list of "id:base64key" pairs
+// separated by commas, such as the ENCRYPTION_MASTER_KEYS setting. The first
+// key is the current one. Keys are 32 random bytes, e.g. from
+// "openssl rand -base64 32".
+func ParseKeyring(spec string) (*Keyring, error) {
+	keys, err := ParseMasterKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(keys)
+}
+
+// ParseMasterKeys reads the master keys of a ParseKeyring spec without
+// building a keyring.
+func ParseMasterKeys(spec string) ([]MasterKey, error) {
+	var keys []MasterKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("envelope: master key %q is not id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("envelope: master key %q must be %d bytes of base64", id, KeySize)
+		}
+		keys = append(keys, MasterKey{ID: id, Key: key})
+	}
+	return keys, nil
+}
+
+// CurrentID is the ID of the master key new data keys are wrapped with.
+func (k *Keyring) CurrentID() string { return k.current }
+
+// Wrap encrypts a data key with the current master key. context, such as the
+// owner of the key, is authenticated with it, so a wrapped key only unwraps
+// for the same context.
+func (k *Keyring) Wrap(dataKey, context []byte) (keyID string, wrapped []byte, err error) {
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.current, aead.Seal(nonce, nonce, dataKey, context), nil
+}
+
+// Unwrap decrypts a data key wrapped with master key keyID.
+func (k *Keyring) Unwrap(keyID string, wrapped, context []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, context)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,381 @@
+// Package envelope encrypts stored files with per-user data keys that are
+// themselves encrypted ("wrapped") by a server master key.
+//
+// Files are encrypted in chunks with AES-256-GCM so they can be written as a
+// stream and read back from any offset. An encrypted file is a header
+// followed by the chunks:
+//
+//	magic "GDE1" | data key version (uint32) | salt (32 bytes)
+//	chunk 0 | chunk 1 | ... | last chunk
+//
+// Every chunk but the last holds ChunkSize bytes of plaintext plus a GCM tag.
+// Each file gets its own key, derived from the data key and the salt, so
+// nonces never repeat across files. A chunk's nonce is its index plus a flag
+// marking the last chunk, and the header is authenticated with every chunk,
+// so reordered, truncated or extended files fail to decrypt.
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// ChunkSize is the plaintext size of every chunk but the last.
+	ChunkSize = 64 * 1024
+	// HeaderSize is the size of the header in front of the chunks.
+	HeaderSize = 4 + 4 + saltSize
+
+	// KeySize is the size of data keys and master keys.
+	KeySize = 32
+
+	saltSize     = 32
+	tagSize      = 16
+	sealedChunk  = ChunkSize + tagSize
+	fileKeyLabel = "goDatabase file encryption v1"
+)
+
+var magic = [4]byte{'G', 'D', 'E', '1'}
+
+var (
+	// ErrNotEncrypted is returned for data without an encryption header.
+	ErrNotEncrypted = errors.New("envelope: not an encrypted file")
+	// ErrCorrupt is returned when a file or wrapped key fails to
+	// authenticate: it was damaged, tampered with, or the key is wrong.
+	ErrCorrupt = errors.New("envelope: message authentication failed")
+)
+
+// EncryptedSize is the size of a file of plainSize bytes once encrypted.
+func EncryptedSize(plainSize int64) int64 {
+	chunks := (plainSize + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		// An empty file is still one (empty) last chunk
+		chunks = 1
+	}
+	return HeaderSize + plainSize + chunks*tagSize
+}
+
+// PlainSize is the size of the plaintext of an encrypted file of
+// encryptedSize bytes.
+func PlainSize(encryptedSize int64) (int64, error) {
+	body := encryptedSize - HeaderSize
+	if body < tagSize {
+		return 0, ErrNotEncrypted
+	}
+	full, rest := body/sealedChunk, body%sealedChunk
+	switch {
+	case rest == 0:
+		return full * ChunkSize, nil
+	case rest < tagSize, rest == tagSize && full > 0:
+		// A last chunk holds at least one byte unless it is the only one
+		return 0, ErrCorrupt
+	}
+	return full*ChunkSize + rest - tagSize, nil
+}
+
+// fileCipher derives the key of one file from its data key and header.
+func fileCipher(dataKey, header []byte) (cipher.AEAD, error) {
+	if len(dataKey) != KeySize {
+		return nil, fmt.Errorf("envelope: data key must be %d bytes", KeySize)
+	}
+	fileKey := make([]byte, KeySize)
+	salt := header[8:HeaderSize]
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dataKey, salt, []byte(fileKeyLabel)), fileKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encrypter is the reader returned by NewEncrypter.
+type encrypter struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	index   int64
+	plain   []byte
+	sealed  []byte
+	pending []byte // sealed bytes not read yet
+	done    bool
+	err     error
+}
+
+// NewEncrypter returns a reader of the encrypted form of src, encrypted with
+// dataKey. keyVersion is recorded in the header so the right data key can be
+// picked when decrypting. The output is EncryptedSize(n) bytes long for n
+// bytes of input.
+func NewEncrypter(src io.Reader, dataKey []byte, keyVersion uint32) (io.Reader, error) {
+	header := make([]byte, HeaderSize)
+	copy(header, magic[:])
+	binary.BigEndian.PutUint32(header[4:], keyVersion)
+	if _, err := rand.Read(header[8:]); err != nil {
+		return nil, err
+	}
+	aead, err := fileCipher(dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	return &encrypter{
+		src:     bufio.NewReaderSize(src, ChunkSize),
+		aead:    aead,
+		header:  header,
+		plain:   make([]byte, ChunkSize),
+		sealed:  make([]byte, 0, sealedChunk),
+		pending: header,
+	}, nil
+}
+
+func (e *encrypter) Read(p []byte) (int, error) {
+	for len(e.pending) == 0 {
+		if e.err != nil {
+			return 0, e.err
+		}
+		if e.done {
+			return 0, io.EOF
+		}
+		e.sealNext()
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// sealNext encrypts the next chunk of the source into pending.
+func (e *encrypter) sealNext() {
+	n, err := io.ReadFull(e.src, e.plain)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		e.err = err
+		return
+	default:
+		// A full chunk is the last one if nothing follows it
+		if _, err := e.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			e.err = err
+			return
+		}
+	}
+	e.pending = e.aead.Seal(e.sealed[:0], chunkNonce(e.index, last), e.plain[:n], e.header)
+	e.index++
+	e.done = last
+}
+
+// Reader decrypts an encrypted file. It reads only the chunks it needs, so
+// seeking into a large file is cheap when the source can seek. Seek decrypts
+// the chunk it lands in, so damage is reported before anything is read. A
+// Reader is safe for concurrent use, but its reads are serialized.
+type Reader struct {
+	mu        sync.Mutex
+	src       io.ReadSeeker
+	srcOffset int64 // position of src, -1 if unknown
+	aead      cipher.AEAD
+	header    []byte
+	size      int64 // plaintext size
+	chunks    int64
+	version   uint32
+
+	offset int64  // position of Read and Seek
+	cached int64  // index of the chunk in plain, -1 if none
+	plain  []byte // decrypted chunk
+	sealed []byte
+}
+
+// KeyVersion reads the data key version from the header of an encrypted
+// file.
+func KeyVersion(header []byte) (uint32, error) {
+	if len(header) < HeaderSize || [4]byte(header[:4]) != magic {
+		return 0, ErrNotEncrypted
+	}
+	return binary.BigEndian.Uint32(header[4:8]), nil
+}
+
+// NewReader opens an encrypted file of encryptedSize bytes read from src.
+// dataKey is called with the key version found in the header and returns
+// the data key it names.
+func NewReader(src io.ReadSeeker, encryptedSize int64, dataKey func(version uint32) ([]byte, error)) (*Reader, error) {
+	size, err := PlainSize(encryptedSize)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, HeaderSize)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, err
+	}
+	version, err := KeyVersion(header)
+	if err != nil {
+		return nil, err
+	}
+	key, err := dataKey(version)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := fileCipher(key, header)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	r := &Reader{
+		src:       src,
+		srcOffset: HeaderSize,
+		aead:      aead,
+		header:    header,
+		size:      size,
+		chunks:    chunks,
+		version:   version,
+		cached:    -1,
+		sealed:    make([]byte, sealedChunk),
+	}
+	if size == 0 {
+		// Reads of an empty file never reach its chunk; check it here
+		if err := r.loadChunk(0); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Size is the plaintext size of the file.
+func (r *Reader) Size() int64 { return r.size }
+
+// KeyVersion is the version of the data key the file is encrypted with.
+func (r *Reader) KeyVersion() uint32 { return r.version }
+
+// loadChunk decrypts chunk index into r.plain.
+func (r *Reader) loadChunk(index int64) error {
+	if r.cached == index {
+		return nil
+	}
+	r.cached = -1
+
+	start := HeaderSize + index*sealedChunk
+	if r.srcOffset != start {
+		if _, err := r.src.Seek(start, io.SeekStart); err != nil {
+			r.srcOffset = -1
+			return err
+		}
+	}
+	n := int64(sealedChunk)
+	if index == r.chunks-1 {
+		n = r.size - index*ChunkSize + tagSize
+	}
+	if _, err := io.ReadFull(r.src, r.sealed[:n]); err != nil {
+		r.srcOffset = -1
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	r.srcOffset = start + n
+
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(index, index == r.chunks-1), r.sealed[:n], r.header)
+	if err != nil {
+		return ErrCorrupt
+	}
+	r.plain = plain
+	r.cached = index
+	return nil
+}
+
+// readAt copies plaintext at off into p, reading chunks as needed.
+func (r *Reader) readAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	read := 0
+	for read < len(p) && off < r.size {
+		index := off / ChunkSize
+		if err := r.loadChunk(index); err != nil {
+			return read, err
+		}
+		n := copy(p[read:], r.plain[off-index*ChunkSize:])
+		read += n
+		off += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := r.readAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads plaintext at off without moving the Read offset.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("envelope: negative offset")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readAt(p, off)
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("envelope: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("envelope: negative position")
+	}
+	// Authenticate the chunk at the new offset now, so a damaged file fails
+	// before a caller such as http.ServeContent has sent any of it
+	if offset < r.size {
+		if err := r.loadChunk(offset / ChunkSize); err != nil {
+			return 0, err
+		}
+	}
+	r.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,262 @@
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"goDatabase/internal/envelope"
+)
+
+// DefaultKeyringFile is where the keyring file lives when KMS_KEYRING_FILE is
+// not set.
+const DefaultKeyringFile = "keyring.json"
+
+// keyringFile is the on-disk form of a keyring file.
+type keyringFile struct {
+	Current string        `json:"current"`
+	Keys    []fileKeyJSON `json:"keys"` // oldest first
+}
+
+type fileKeyJSON struct {
+	ID      string    `json:"id"`
+	Key     []byte    `json:"key"` // base64 in JSON
+	Created time.Time `json:"created"`
+}
+
+// FileKeyring is a KMS over master keys kept in a local JSON file, readable
+// only by its owner. Rotate adds a key to the file; the file is reloaded when
+// another process, such as the kms command, changes it.
+type FileKeyring struct {
+	path string
+
+	mu      sync.Mutex
+	info    os.FileInfo // of the file as last read
+	file    keyringFile
+	keyring *envelope.Keyring
+}
+
+// CreateFile writes a new keyring file at path holding keys, the first one
+// current. Without keys a random one is generated. It does not overwrite an
+// existing file.
+func CreateFile(path string, keys []envelope.MasterKey) (*FileKeyring, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("kms: keyring file %s already exists", path)
+	}
+	if len(keys) == 0 {
+		key, err := envelope.NewDataKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = []envelope.MasterKey{{ID: "v1", Key: key}}
+	}
+
+	now := time.Now().UTC()
+	file := keyringFile{Current: keys[0].ID}
+	for i := len(keys) - 1; i >= 0; i-- {
+		file.Keys = append(file.Keys, fileKeyJSON{ID: keys[i].ID, Key: keys[i].Key, Created: now})
+	}
+	if _, err := newFileKeyring(file); err != nil {
+		return nil, err
+	}
+	if err := writeKeyringFile(path, file); err != nil {
+		return nil, err
+	}
+	return OpenFile(path)
+}
+
+// OpenFile opens the keyring file at path.
+func OpenFile(path string) (*FileKeyring, error) {
+	f := &FileKeyring{path: path}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newFileKeyring builds the keyring of file, current key first.
+func newFileKeyring(file keyringFile) (*envelope.Keyring, error) {
+	keys := make([]envelope.MasterKey, 0, len(file.Keys))
+	found := false
+	for _, k := range file.Keys {
+		mk := envelope.MasterKey{ID: k.ID, Key: k.Key}
+		if k.ID == file.Current {
+			keys = append([]envelope.MasterKey{mk}, keys...)
+			found = true
+		} else {
+			keys = append(keys, mk)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kms: current key %q is not in the keyring file", file.Current)
+	}
+	return envelope.NewKeyring(keys)
+}
+
+// reload reads the file again if it changed since it was last read. f.mu
+// must be held.
+func (f *FileKeyring) reload() error {
+	info, err := os.Stat(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("kms: keyring file %s does not exist, create it with \"go run ./cmd/kms init\"", f.path)
+	}
+	if err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+	if f.keyring != nil && unchanged(f.info, info) {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("kms: reading keyring file %s: %w", f.path, err)
+	}
+	keyring, err := newFileKeyring(file)
+	if err != nil {
+		return fmt.Errorf("kms: reading keyring file %s: %w", f.path, err)
+	}
+	f.info = info
+	f.file = file
+	f.keyring = keyring
+	return nil
+}
+
+// unchanged reports whether the file was not replaced between two stats. The
+// file is only ever replaced by renaming a new one over it, so a rewrite
+// within the same modification time tick still shows as another file.
+func unchanged(old, info os.FileInfo) bool {
+	return os.SameFile(old, info) && old.ModTime().Equal(info.ModTime()) && old.Size() == info.Size()
+}
+
+// current returns the keyring, reloaded if the file changed.
+func (f *FileKeyring) current() (*envelope.Keyring, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f.keyring, nil
+}
+
+func (f *FileKeyring) CurrentID(context.Context) (string, error) {
+	keyring, err := f.current()
+	if err != nil {
+		return "", err
+	}
+	return keyring.CurrentID(), nil
+}
+
+func (f *FileKeyring) Wrap(_ context.Context, plaintext, aad []byte) (string, []byte, error) {
+	keyring, err := f.current()
+	if err != nil {
+		return "", nil, err
+	}
+	return keyring.Wrap(plaintext, aad)
+}
+
+func (f *FileKeyring) Unwrap(_ context.Context, keyID string, wrapped, aad []byte) ([]byte, error) {
+	keyring, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Unwrap(keyID, wrapped, aad)
+}
+
+// Rotate adds a random key named "v<n>" to the file and makes it current.
+// Older keys stay in the file to unwrap material that is not rewrapped yet.
+func (f *FileKeyring) Rotate(context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		return "", err
+	}
+
+	key, err := envelope.NewDataKey()
+	if err != nil {
+		return "", err
+	}
+	file := keyringFile{Keys: append([]fileKeyJSON(nil), f.file.Keys...)}
+	for n := len(file.Keys) + 1; ; n++ {
+		file.Current = fmt.Sprintf("v%d", n)
+		if !f.hasKey(file.Current) {
+			break
+		}
+	}
+	file.Keys = append(file.Keys, fileKeyJSON{ID: file.Current, Key: key, Created: time.Now().UTC()})
+
+	keyring, err := newFileKeyring(file)
+	if err != nil {
+		return "", err
+	}
+	if err := writeKeyringFile(f.path, file); err != nil {
+		return "", err
+	}
+	f.info, _ = os.Stat(f.path) // a nil info makes the next call reload
+	f.file = file
+	f.keyring = keyring
+	return file.Current, nil
+}
+
+func (f *FileKeyring) hasKey(id string) bool {
+	_, ok := f.keyLocked(id)
+	return ok
+}
+
+// key returns the master key named id.
+func (f *FileKeyring) key(id string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keyLocked(id)
+}
+
+// keyLocked is key with f.mu held.
+func (f *FileKeyring) keyLocked(id string) ([]byte, bool) {
+	for _, k := range f.file.Keys {
+		if k.ID == id {
+			return k.Key, true
+		}
+	}
+	return nil, false
+}
+
+// writeKeyringFile replaces the file at path with file, so readers never see
+// half a keyring.
+func writeKeyringFile(path string, file keyringFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp already creates the file readable only by its owner
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("kms: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("kms: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("kms: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package kms keeps the master keys that wrap data keys, session secrets and
+// other key material, behind an interface so they can live in a local keyring
+// file or in an external service such as HashiCorp Vault.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"goDatabase/internal/envelope"
+)
+
+// ErrUnknownKey is returned when wrapped key material names a master key the
+// KMS does not have.
+var ErrUnknownKey = envelope.ErrUnknownKey
+
+// ErrRotateUnsupported is returned by Rotate for master keys that are managed
+// outside of the application, such as ENCRYPTION_MASTER_KEYS.
+var ErrRotateUnsupported = errors.New("kms: master keys cannot be rotated from here")
+
+// KMS wraps key material with versioned master keys. Wrap always uses the
+// current version; Unwrap accepts any version the KMS still holds, so
+// material wrapped before a rotation keeps working until it is rewrapped.
+type KMS interface {
+	// CurrentID returns the ID of the master key version Wrap uses.
+	CurrentID(ctx context.Context) (string, error)
+	// Wrap encrypts plaintext bound to aad and returns the ID of the master
+	// key version that wrapped it.
+	Wrap(ctx context.Context, plaintext, aad []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts wrapped with the master key version keyID. aad must
+	// match the value given to Wrap.
+	Unwrap(ctx context.Context, keyID string, wrapped, aad []byte) ([]byte, error)
+	// Rotate creates a new master key version, makes it current and returns
+	// its ID.
+	Rotate(ctx context.Context) (string, error)
+}
+
+// static is a KMS over a fixed keyring.
+type static struct {
+	keyring *envelope.Keyring
+}
+
+// NewStatic returns a KMS over a fixed keyring, such as the one configured in
+// ENCRYPTION_MASTER_KEYS. It cannot be rotated.
+func NewStatic(keyring *envelope.Keyring) KMS {
+	return static{keyring: keyring}
+}
+
+func (k static) CurrentID(context.Context) (string, error) {
+	return k.keyring.CurrentID(), nil
+}
+
+func (k static) Wrap(_ context.Context, plaintext, aad []byte) (string, []byte, error) {
+	return k.keyring.Wrap(plaintext, aad)
+}
+
+func (k static) Unwrap(_ context.Context, keyID string, wrapped, aad []byte) ([]byte, error) {
+	return k.keyring.Unwrap(keyID, wrapped, aad)
+}
+
+func (k static) Rotate(context.Context) (string, error) {
+	return "", ErrRotateUnsupported
+}
+
+// chain wraps with its first KMS and unwraps with whichever one knows the
+// key.
+type chain []KMS
+
+// Chain returns a KMS that wraps and rotates with primary and can still
+// unwrap material wrapped by any of the legacy ones. It is used to move from
+// one backend to another: once everything is rewrapped with primary, the
+// legacy backends can be dropped.
+func Chain(primary KMS, legacy ...KMS) KMS {
+	return append(chain{primary}, legacy...)
+}
+
+func (c chain) CurrentID(ctx context.Context) (string, error) {
+	return c[0].CurrentID(ctx)
+}
+
+func (c chain) Wrap(ctx context.Context, plaintext, aad []byte) (string, []byte, error) {
+	return c[0].Wrap(ctx, plaintext, aad)
+}
+
+func (c chain) Unwrap(ctx context.Context, keyID string, wrapped, aad []byte) ([]byte, error) {
+	var firstErr error
+	for _, k := range c {
+		plaintext, err := k.Unwrap(ctx, keyID, wrapped, aad)
+		if err == nil {
+			return plaintext, nil
+		}
+		// The same ID may name different keys in two backends, so a key that
+		// does not open the material is not the last word either
+		if !errors.Is(err, ErrUnknownKey) && !errors.Is(err, envelope.ErrCorrupt) {
+			return nil, err
+		}
+		if firstErr == nil || errors.Is(firstErr, ErrUnknownKey) {
+			firstErr = err
+		}
+	}
+	if errors.Is(firstErr, ErrUnknownKey) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	return nil, firstErr
+}
+
+func (c chain) Rotate(ctx context.Context) (string, error) {
+	return c[0].Rotate(ctx)
+}
+
+// FromEnv opens the KMS configured in the environment. KMS_BACKEND selects
+// "file" (the keyring file in KMS_KEYRING_FILE) or "vault" (the Vault transit
+// engine, see TransitConfigFromEnv). Without a backend the master keys come
+// from ENCRYPTION_MASTER_KEYS; with one, those keys are still used to unwrap
+// existing material until it is rewrapped. It returns nil if no master keys
+// are configured at all.
+func FromEnv(ctx context.Context) (KMS, error) {
+	var legacy KMS
+	var legacyKeys []envelope.MasterKey
+	if spec := os.Getenv("ENCRYPTION_MASTER_KEYS"); spec != "" {
+		var err error
+		if legacyKeys, err = envelope.ParseMasterKeys(spec); err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_MASTER_KEYS: %w", err)
+		}
+		keyring, err := envelope.NewKeyring(legacyKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_MASTER_KEYS: %w", err)
+		}
+		legacy = NewStatic(keyring)
+	}
+
+	var primary KMS
+	switch backend := os.Getenv("KMS_BACKEND"); backend {
+	case "":
+		return legacy, nil
+	case "file":
+		path := os.Getenv("KMS_KEYRING_FILE")
+		if path == "" {
+			path = DefaultKeyringFile
+		}
+		f, err := OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Keys wrapped under an ID the file uses for another key would never
+		// be rewrapped, and break once ENCRYPTION_MASTER_KEYS is unset
+		for _, mk := range legacyKeys {
+			if key, ok := f.key(mk.ID); ok && !bytes.Equal(key, mk.Key) {
+				return nil, fmt.Errorf("master key %q of ENCRYPTION_MASTER_KEYS is a different key in %s; import it with \"go run ./cmd/kms import\" or rename it", mk.ID, path)
+			}
+		}
+		primary = f
+	case "vault":
+		t, err := NewTransit(TransitConfigFromEnv())
+		if err != nil {
+			return nil, err
+		}
+		primary = t
+	default:
+		return nil, fmt.Errorf("unknown KMS_BACKEND %q, expected \"file\" or \"vault\"", backend)
+	}
+
+	// Fail at startup rather than on the first upload
+	if _, err := primary.CurrentID(ctx); err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		return Chain(primary, legacy), nil
+	}
+	return primary, nil
+}
@@ -0,0 +1,194 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TransitConfig locates a key of a HashiCorp Vault transit secrets engine.
+type TransitConfig struct {
+	Addr      string // e.g. "http://vault:8200"
+	Token     string
+	Namespace string // Vault Enterprise namespace, if any
+	Mount     string // defaults to "transit"
+	Key       string // defaults to "godatabase"
+
+	Client *http.Client // defaults to a client with a 10 second timeout
+}
+
+// TransitConfigFromEnv reads VAULT_ADDR, VAULT_TOKEN, VAULT_NAMESPACE,
+// VAULT_TRANSIT_MOUNT and VAULT_TRANSIT_KEY.
+func TransitConfigFromEnv() TransitConfig {
+	return TransitConfig{
+		Addr:      os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Mount:     os.Getenv("VAULT_TRANSIT_MOUNT"),
+		Key:       os.Getenv("VAULT_TRANSIT_KEY"),
+	}
+}
+
+// Transit is a KMS over a key of the Vault transit secrets engine. Master
+// keys never leave Vault: wrapping and unwrapping are requests to it. The key
+// should be of an AEAD type such as the default aes256-gcm96, so the
+// associated data is checked.
+type Transit struct {
+	config TransitConfig
+	base   string // URL of the mount
+	prefix string // of the key IDs of this key
+}
+
+// NewTransit returns a KMS over the transit key in config. The key must
+// already exist.
+func NewTransit(config TransitConfig) (*Transit, error) {
+	if config.Addr == "" || config.Token == "" {
+		return nil, errors.New("kms: VAULT_ADDR and VAULT_TOKEN must be set to use Vault")
+	}
+	if config.Mount == "" {
+		config.Mount = "transit"
+	}
+	if config.Key == "" {
+		config.Key = "godatabase"
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Transit{
+		config: config,
+		base:   strings.TrimRight(config.Addr, "/") + "/v1/" + strings.Trim(config.Mount, "/"),
+		prefix: "transit:" + config.Key + ":",
+	}, nil
+}
+
+// keyID turns a transit ciphertext, "vault:v3:...", into the ID of the key
+// version that made it, "transit:<key>:v3".
+func (t *Transit) keyID(ciphertext string) (string, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return "", fmt.Errorf("kms: unexpected ciphertext from Vault")
+	}
+	return t.prefix + parts[1], nil
+}
+
+func (t *Transit) CurrentID(ctx context.Context) (string, error) {
+	var resp struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+		} `json:"data"`
+	}
+	if err := t.do(ctx, http.MethodGet, "keys/"+url.PathEscape(t.config.Key), nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.Data.LatestVersion <= 0 {
+		return "", fmt.Errorf("kms: Vault transit key %s has no versions", t.config.Key)
+	}
+	return fmt.Sprintf("%sv%d", t.prefix, resp.Data.LatestVersion), nil
+}
+
+func (t *Transit) Wrap(ctx context.Context, plaintext, aad []byte) (string, []byte, error) {
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}
+	if len(aad) > 0 {
+		req["associated_data"] = base64.StdEncoding.EncodeToString(aad)
+	}
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := t.do(ctx, http.MethodPost, "encrypt/"+url.PathEscape(t.config.Key), req, &resp); err != nil {
+		return "", nil, err
+	}
+	keyID, err := t.keyID(resp.Data.Ciphertext)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyID, []byte(resp.Data.Ciphertext), nil
+}
+
+func (t *Transit) Unwrap(ctx context.Context, keyID string, wrapped, aad []byte) ([]byte, error) {
+	if !strings.HasPrefix(keyID, t.prefix) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	req := map[string]string{"ciphertext": string(wrapped)}
+	if len(aad) > 0 {
+		req["associated_data"] = base64.StdEncoding.EncodeToString(aad)
+	}
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := t.do(ctx, http.MethodPost, "decrypt/"+url.PathEscape(t.config.Key), req, &resp); err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("kms: unexpected plaintext from Vault: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Rotate asks Vault for a new version of the key.
+func (t *Transit) Rotate(ctx context.Context) (string, error) {
+	if err := t.do(ctx, http.MethodPost, "keys/"+url.PathEscape(t.config.Key)+"/rotate", nil, nil); err != nil {
+		return "", err
+	}
+	return t.CurrentID(ctx)
+}
+
+// do sends a request to the transit mount and decodes the response into out.
+func (t *Transit) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.base+"/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", t.config.Token)
+	if t.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", t.config.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.config.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms: Vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&vaultErr)
+		if len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("kms: Vault returned %d: %s", resp.StatusCode, strings.Join(vaultErr.Errors, "; "))
+		}
+		return fmt.Errorf("kms: Vault returned %d", resp.StatusCode)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("kms: reading Vault response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles another user can be granted on a file or folder. Viewers can list
+// and download; editors can also upload and move.
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+)
+
+// ownerContext is the bucket a request works in: the user's own, or that of
+// another user who shared the requested items with them.
+type ownerContext struct {
+	userID     int // owner of the bucket
+	bucketName string
+	shared     bool // working in another user's files through a grant
+}
+
+func roleAllows(granted, needed string) bool {
+	return granted == roleEditor || (granted == roleViewer && needed == roleViewer)
+}
+
+// resolveOwner picks the bucket for a request. An empty owner, or the user's
+// own ID, means their own bucket. Otherwise owner is the user ID of someone
+// who must have granted the session user at least role on every one of
+// paths. When it returns false an error response has already been written.
+func (s *Server) resolveOwner(c *gin.Context, owner, role string, paths ...string) (ownerContext, bool) {
+	userID, bucketName, ok := s.getSessionUser(c)
+	if !ok {
+		return ownerContext{}, false
+	}
+	if owner == "" || owner == strconv.Itoa(userID) {
+		if !s.checkVault(c, bucketName, paths...) {
+			return ownerContext{}, false
+		}
+		return ownerContext{userID: userID, bucketName: bucketName}, true
+	}
+
+	ownerID, err := strconv.Atoi(owner)
+	if err != nil || ownerID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid owner"})
+		return ownerContext{}, false
+	}
+	for _, p := range paths {
+		p = strings.Trim(filepath.ToSlash(filepath.Clean("/"+p)), "/")
+		// A vault is never shared, whatever was granted around it
+		if inVault(p) {
+			if has, err := s.hasVault(ownerID); err != nil || has {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this item"})
+				return ownerContext{}, false
+			}
+		}
+		granted, err := s.db.AccessRole(ownerID, userID, p)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check access", "details": err.Error()})
+			return ownerContext{}, false
+		}
+		if !roleAllows(granted, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have access to this item"})
+			return ownerContext{}, false
+		}
+	}
+	return ownerContext{userID: ownerID, bucketName: bucketForUser(ownerID), shared: true}, true
+}
+
+// bindGrantee resolves the user a grant is about from their email.
+func (s *Server) bindGrantee(c *gin.Context, ownerID int, email string) (int, bool) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email cannot be empty"})
+		return 0, false
+	}
+	granteeID, err := s.db.GetUserIDByEmail(email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return 0, false
+	}
+	if granteeID == ownerID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot share with yourself"})
+		return 0, false
+	}
+	return granteeID, true
+}
+
+// grantAccessHandler shares a file or folder with another registered user
+// as viewer or editor. Granting again changes the role.
+func (s *Server) grantAccessHandler(c *gin.Context) {
+	userID, bucketName, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path  string `json:"path"`
+		Type  string `json:"type"` // "file" or "folder"
+		Email string `json:"email"`
+		Role  string `json:"role"` // "viewer" or "editor"
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	itemPath := strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.Path)), "/")
+	if itemPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path cannot be empty"})
+		return
+	}
+	if req.Role != roleViewer && req.Role != roleEditor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be viewer or editor"})
+		return
+	}
+	if !s.checkVaultNotShared(c, userID, itemPath) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var exists bool
+	var err error
+	switch req.Type {
+	case "file":
+		exists, err = s.objectExists(ctx, bucketName, itemPath)
+	case "folder":
+		exists, err = s.prefixExists(ctx, bucketName, itemPath+"/")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check item", "details": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	granteeID, ok := s.bindGrantee(c, userID, req.Email)
+	if !ok {
+		return
+	}
+	if err := s.db.GrantAccess(userID, granteeID, itemPath, req.Type, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share item", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Item shared", "path": itemPath, "role": req.Role})
+}
+
+// revokeAccessHandler stops sharing a path with a user.
+func (s *Server) revokeAccessHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path  string `json:"path"`
+		Email string `json:"email"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	granteeID, ok := s.bindGrantee(c, userID, req.Email)
+	if !ok {
+		return
+	}
+
+	itemPath := strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.Path)), "/")
+	err := s.db.RevokeAccess(userID, granteeID, itemPath)
+	if errors.Is(err, database.ErrGrantNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item is not shared with this user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop sharing", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Access removed"})
+}
+
+// listGrantsHandler lists who the user has shared items with, or only the
+// grants on ?path=.
+func (s *Server) listGrantsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	grants, err := s.db.ListGrants(userID, strings.Trim(c.Query("path"), "/"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list shared items", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": grants})
+}
+
+// sharedWithMeHandler lists the files and folders other users have shared
+// with the user. Each entry's ownerId is passed as "owner" to browse it.
+func (s *Server) sharedWithMeHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	grants, err := s.db.SharedWithUser(userID)
+	if err != nil {
+		log.Printf("Error listing items shared with user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list shared items", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": grants})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"goDatabase/internal/archive"
+	"goDatabase/internal/contenttype"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openArchive opens the archive named by the "path" query parameter for
+// reading through range requests, writing an error response if it cannot.
+func (s *Server) openArchive(c *gin.Context, bucketName string) (storedObject, archive.Format, int64, bool) {
+	archiveKey := strings.Trim(filepath.ToSlash(filepath.Clean("/"+c.Query("path"))), "/")
+	if archiveKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path cannot be empty"})
+		return nil, archive.Unknown, 0, false
+	}
+	format := archive.Detect(archiveKey)
+	if format == archive.Unknown {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a .zip, .tar or .tar.gz archive"})
+		return nil, archive.Unknown, 0, false
+	}
+	if !s.checkVault(c, bucketName, archiveKey) {
+		return nil, archive.Unknown, 0, false
+	}
+
+	object, info, err := s.getObject(c.Request.Context(), bucketName, archiveKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Archive not found"})
+		return nil, archive.Unknown, 0, false
+	}
+	return object, format, info.Size, true
+}
+
+// archiveListHandler lists the files and folders inside an archive without
+// downloading it.
+func (s *Server) archiveListHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	object, format, size, ok := s.openArchive(c, bucketName)
+	if !ok {
+		return
+	}
+	defer object.Close()
+
+	entries, total, err := archive.Scan(format, object, size, archiveLimits)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read archive", "details": err.Error()})
+		return
+	}
+	if entries == nil {
+		entries = []archive.Entry{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":   entries,
+		"count":     len(entries),
+		"totalSize": total,
+	})
+}
+
+// archiveEntryHandler streams a single file out of an archive. Like
+// downloads, it is shown inline when safe unless ?download is set.
+func (s *Server) archiveEntryHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	entryName := c.Query("entry")
+	if entryName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Entry cannot be empty"})
+		return
+	}
+
+	object, format, size, ok := s.openArchive(c, bucketName)
+	if !ok {
+		return
+	}
+	defer object.Close()
+
+	written := false
+	err := archive.Find(format, object, size, archiveLimits, entryName, func(e archive.Entry, data io.Reader) error {
+		br := bufio.NewReaderSize(data, contenttype.SniffLen)
+		head, _ := br.Peek(contenttype.SniffLen)
+		contentType := contenttype.Resolve(e.Name, "", head)
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", contenttype.Disposition(contentType, e.Name, c.Query("download") == ""))
+		c.Header("Content-Length", strconv.FormatInt(e.Size, 10))
+		c.Header("X-Content-Type-Options", "nosniff")
+		if !e.Modified.IsZero() {
+			c.Header("Last-Modified", e.Modified.UTC().Format(http.TimeFormat))
+		}
+		c.Status(http.StatusOK)
+		written = true
+
+		_, err := io.Copy(c.Writer, br)
+		return err
+	})
+
+	switch {
+	case err == nil:
+	case written:
+		log.Printf("Error streaming %s from archive: %v", entryName, err)
+	case errors.Is(err, archive.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found in archive"})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot read archive", "details": err.Error()})
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Number of items a batch request works on at the same time
+	batchWorkers = 4
+	// Largest number of items accepted in one batch request
+	maxBatchItems = 1000
+)
+
+// batchItem is one selected file or folder in a batch request.
+type batchItem struct {
+	Path string `json:"path"`
+	Type string `json:"type"` // "file" or "folder"
+}
+
+// batchResult reports what happened to one item of a batch request.
+type batchResult struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Success bool   `json:"success"`
+	NewPath string `json:"newPath,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runBatch calls fn for every index in [0, n) using at most batchWorkers
+// goroutines at a time and waits for all of them to finish.
+func runBatch(n int, fn func(i int)) {
+	sem := make(chan struct{}, batchWorkers)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// bindBatchItems parses and cleans the "items" list of a batch request. When
+// it returns false an error response has already been written.
+func bindBatchItems(c *gin.Context, req interface{}, items *[]batchItem) bool {
+	if err := c.BindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return false
+	}
+	if len(*items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No items selected"})
+		return false
+	}
+	if len(*items) > maxBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many items selected"})
+		return false
+	}
+
+	for i := range *items {
+		item := &(*items)[i]
+		item.Path = strings.Trim(item.Path, "/")
+		if item.Path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Item path cannot be empty"})
+			return false
+		}
+		if item.Type != "file" && item.Type != "folder" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type for " + item.Path})
+			return false
+		}
+	}
+	return true
+}
+
+// nestedItem returns the path of an item that is also selected through a
+// folder containing it, or that is selected twice, and "" if there is none.
+func nestedItem(items []batchItem) string {
+	selected := make(map[string]bool)
+	for _, item := range items {
+		if selected[item.Path] {
+			return item.Path
+		}
+		selected[item.Path] = true
+	}
+	for _, item := range items {
+		for dir := parentPath(item.Path); dir != ""; dir = parentPath(dir) {
+			if selected[dir] {
+				return item.Path
+			}
+		}
+	}
+	return ""
+}
+
+// itemPaths returns the paths of items.
+func itemPaths(items []batchItem) []string {
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = item.Path
+	}
+	return paths
+}
+
+func newBatchResults(items []batchItem) []batchResult {
+	results := make([]batchResult, len(items))
+	for i, item := range items {
+		results[i] = batchResult{Path: item.Path, Type: item.Type, Success: true}
+	}
+	return results
+}
+
+func batchResponse(message string, results []batchResult) gin.H {
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
+		}
+	}
+	return gin.H{
+		"message":      message,
+		"results":      results,
+		"total":        len(results),
+		"total_failed": failed,
+	}
+}
+
+func (s *Server) batchDeleteHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Items []batchItem `json:"items"`
+	}
+	if !bindBatchItems(c, &req, &req.Items) {
+		return
+	}
+	if !s.checkVault(c, bucketName, itemPaths(req.Items)...) {
+		return
+	}
+
+	ctx := context.Background()
+	results := newBatchResults(req.Items)
+
+	// Work out which objects make up each item
+	keysPerItem := make([][]string, len(req.Items))
+	runBatch(len(req.Items), func(i int) {
+		item := req.Items[i]
+		if item.Type == "file" {
+			exists, err := s.objectExists(ctx, bucketName, item.Path)
+			if err != nil {
+				results[i].Success = false
+				results[i].Error = err.Error()
+				return
+			}
+			if !exists {
+				results[i].Success = false
+				results[i].Error = "file not found"
+				return
+			}
+			keysPerItem[i] = []string{item.Path}
+			return
+		}
+
+		objects, err := s.listPrefix(ctx, bucketName, item.Path+"/")
+		if err != nil {
+			results[i].Success = false
+			results[i].Error = err.Error()
+			return
+		}
+		if len(objects) == 0 {
+			results[i].Success = false
+			results[i].Error = "folder not found"
+			return
+		}
+		for _, object := range objects {
+			keysPerItem[i] = append(keysPerItem[i], object.Key)
+		}
+	})
+
+	// Remove everything in one bulk request and map failures back to items
+	var keys []string
+	owner := make(map[string]int)
+	for i, itemKeys := range keysPerItem {
+		for _, key := range itemKeys {
+			owner[key] = i
+			keys = append(keys, key)
+		}
+	}
+
+	for key, err := range s.removeObjects(ctx, bucketName, keys) {
+		log.Printf("Error deleting object %s: %v", key, err)
+		i := owner[key]
+		results[i].Success = false
+		if results[i].Error == "" {
+			results[i].Error = err.Error()
+		}
+	}
+
+	for i, item := range req.Items {
+		if results[i].Success {
+			s.unindexItem(bucketName, item.Path, item.Type)
+		}
+	}
+
+	c.JSON(http.StatusOK, batchResponse("Batch delete finished", results))
+}
+
+func (s *Server) batchMoveHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Items           []batchItem `json:"items"`
+		DestinationPath string      `json:"destinationPath"`
+		Conflict        string      `json:"conflict"` // "fail" (the default) or "keepBoth"
+	}
+	if !bindBatchItems(c, &req, &req.Items) {
+		return
+	}
+	if req.Conflict == "" {
+		req.Conflict = conflictFail
+	}
+	if req.Conflict != conflictFail && req.Conflict != conflictKeepBoth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown conflict policy " + req.Conflict})
+		return
+	}
+	// Items run in parallel, so one must not move along with another
+	if nested := nestedItem(req.Items); nested != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Selected items overlap at " + nested})
+		return
+	}
+	if !s.checkVault(c, bucketName, append(itemPaths(req.Items), req.DestinationPath)...) {
+		return
+	}
+
+	ctx := context.Background()
+	results := newBatchResults(req.Items)
+	destination := strings.Trim(req.DestinationPath, "/")
+
+	// Pick every new path up front so items cannot land on each other or on
+	// anything already stored in the destination
+	taken := make(map[string]bool)
+	for i, item := range req.Items {
+		newPath, err := s.batchMovePath(ctx, bucketName, item, destination, req.Conflict, taken)
+		if err != nil {
+			results[i].Success = false
+			results[i].Error = err.Error()
+			continue
+		}
+		taken[newPath] = true
+		results[i].NewPath = newPath
+	}
+
+	runBatch(len(req.Items), func(i int) {
+		if !results[i].Success {
+			return
+		}
+		item := req.Items[i]
+		if err := s.moveItemTo(ctx, bucketName, item.Path, results[i].NewPath, item.Type); err != nil {
+			log.Printf("Error moving %s: %v", item.Path, err)
+			results[i].Success = false
+			results[i].NewPath = ""
+			results[i].Error = err.Error()
+		}
+	})
+
+	c.JSON(http.StatusOK, batchResponse("Batch move finished", results))
+}
+
+var errItemExists = errors.New("an item with that name already exists")
+
+// batchMovePath picks the path item moves to inside destination, following
+// the conflict policy when the name is in use. Paths in taken were picked for
+// earlier items of the same batch.
+func (s *Server) batchMovePath(ctx context.Context, bucketName string, item batchItem, destination, policy string, taken map[string]bool) (string, error) {
+	name := filepath.Base(item.Path)
+	newPath := joinKey(destination, name)
+	if newPath == item.Path {
+		return newPath, nil
+	}
+
+	exists, err := s.pathTaken(ctx, bucketName, newPath, taken)
+	if err != nil || !exists {
+		return newPath, err
+	}
+	if policy == conflictKeepBoth {
+		return s.uniqueItemPath(ctx, bucketName, destination, name, item.Type == "folder", taken)
+	}
+	return "", errItemExists
+}
+
+func (s *Server) batchDownloadHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Items []batchItem `json:"items"`
+	}
+	if !bindBatchItems(c, &req, &req.Items) {
+		return
+	}
+	if !s.checkVault(c, bucketName, itemPaths(req.Items)...) {
+		return
+	}
+
+	s.streamZip(c, bucketName, req.Items)
+}
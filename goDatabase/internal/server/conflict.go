@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// What an upload does when an item with the same name already exists.
+const (
+	conflictOverwrite = "overwrite" // Replace the existing file (the default)
+	conflictKeepBoth  = "keepBoth"  // Store the upload as "name (1).ext"
+	conflictSkip      = "skip"      // Leave the existing file and drop the upload
+	conflictFail      = "fail"      // Report the file as failed
+)
+
+// uploadConflict records how a name clash was resolved for one upload.
+type uploadConflict struct {
+	File   string `json:"file"`
+	Path   string `json:"path"`
+	Action string `json:"action"` // "replaced", "renamed", "skipped" or "failed"
+}
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictOverwrite, conflictKeepBoth, conflictSkip, conflictFail:
+		return true
+	}
+	return false
+}
+
+// parseConflictPolicies reads the request wide "conflict" form value and the
+// optional "conflicts" JSON object that overrides it per file name
+// (the relative path for folder uploads).
+func parseConflictPolicies(defaultPolicy, perFile string) (string, map[string]string, error) {
+	if defaultPolicy == "" {
+		defaultPolicy = conflictOverwrite
+	}
+	if !validConflictPolicy(defaultPolicy) {
+		return "", nil, fmt.Errorf("unknown conflict policy %q", defaultPolicy)
+	}
+
+	policies := make(map[string]string)
+	if perFile != "" {
+		if err := json.Unmarshal([]byte(perFile), &policies); err != nil {
+			return "", nil, fmt.Errorf("invalid conflicts: %v", err)
+		}
+		for name, policy := range policies {
+			if !validConflictPolicy(policy) {
+				return "", nil, fmt.Errorf("unknown conflict policy %q for %s", policy, name)
+			}
+		}
+	}
+	return defaultPolicy, policies, nil
+}
+
+// resolveUploadConflict decides where an upload meant for objectName should be
+// stored. It returns the final object name and the action taken, which is
+// empty when nothing was in the way. An empty object name means the file must
+// not be uploaded. Names in taken were chosen by earlier files of the same
+// request and clash like stored files do.
+func (s *Server) resolveUploadConflict(ctx context.Context, bucketName, objectName, policy string, taken map[string]bool) (string, string, error) {
+	exists, err := s.pathTaken(ctx, bucketName, objectName, taken)
+	if err != nil || !exists {
+		return objectName, "", err
+	}
+
+	switch policy {
+	case conflictKeepBoth:
+		return s.renameUpload(ctx, bucketName, objectName, taken)
+	case conflictSkip:
+		return "", "skipped", nil
+	case conflictFail:
+		return "", "failed", nil
+	}
+
+	// An upload does not replace another file of the same request
+	if taken[objectName] {
+		return s.renameUpload(ctx, bucketName, objectName, taken)
+	}
+
+	// Overwriting only works for files; a folder of the same name is left alone
+	isFile, err := s.objectExists(ctx, bucketName, objectName)
+	if err != nil {
+		return "", "", err
+	}
+	if !isFile {
+		return "", "failed", nil
+	}
+	return objectName, "replaced", nil
+}
+
+// renameUpload picks a free "name (N).ext" next to objectName.
+func (s *Server) renameUpload(ctx context.Context, bucketName, objectName string, taken map[string]bool) (string, string, error) {
+	newName, err := s.uniqueItemPath(ctx, bucketName, parentPath(objectName), filepath.Base(objectName), false, taken)
+	if err != nil {
+		return "", "", err
+	}
+	return newName, "renamed", nil
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// Folders with more objects than this are copied in the background and
+// report their progress through /api/jobs/:id.
+const copyJobThreshold = 100
+
+// Matches the " (N)" suffix added to duplicated names.
+var copySuffix = regexp.MustCompile(`^(.*) \((\d+)\)$`)
+
+// joinKey joins a folder path and a name into an object key.
+func joinKey(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// uniqueItemPath returns a path for name inside dir that no file or folder
+// uses yet, adding " (1)", " (2)", ... before the extension when needed.
+// Paths in taken count as used even before they are written.
+func (s *Server) uniqueItemPath(ctx context.Context, bucketName, dir, name string, isFolder bool, taken map[string]bool) (string, error) {
+	candidate := joinKey(dir, name)
+	exists, err := s.pathTaken(ctx, bucketName, candidate, taken)
+	if err != nil || !exists {
+		return candidate, err
+	}
+
+	stem, ext := name, ""
+	if !isFolder {
+		ext = filepath.Ext(name)
+		if ext == name {
+			// Dotfiles such as ".env" have no extension to preserve
+			ext = ""
+		}
+		stem = strings.TrimSuffix(name, ext)
+	}
+	// Duplicating "a (1).txt" should give "a (2).txt", not "a (1) (1).txt"
+	if m := copySuffix.FindStringSubmatch(stem); m != nil {
+		stem = m[1]
+	}
+
+	for n := 1; n < 10000; n++ {
+		candidate = joinKey(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		exists, err := s.pathTaken(ctx, bucketName, candidate, taken)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s in %q", name, dir)
+}
+
+// pathTaken reports whether itemPath is in taken or already exists.
+func (s *Server) pathTaken(ctx context.Context, bucketName, itemPath string, taken map[string]bool) (bool, error) {
+	if taken[itemPath] {
+		return true, nil
+	}
+	return s.itemExists(ctx, bucketName, itemPath)
+}
+
+func (s *Server) copyHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		SourcePath      string `json:"sourcePath"`
+		DestinationPath string `json:"destinationPath"` // Folder to copy into, "" for the root
+		Type            string `json:"type"`            // "file" or "folder"
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	sourcePath := strings.Trim(req.SourcePath, "/")
+	destinationPath := strings.Trim(req.DestinationPath, "/")
+	if sourcePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source path cannot be empty"})
+		return
+	}
+	if !s.checkVaultMove(c, bucketName, sourcePath, joinKey(destinationPath, filepath.Base(sourcePath))) {
+		return
+	}
+
+	ctx := context.Background()
+	currentSize := s.bucketSize(ctx, bucketName)
+
+	switch req.Type {
+	case "file":
+		objInfo, err := s.minioClient.StatObject(ctx, bucketName, sourcePath, minio.StatObjectOptions{})
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		if currentSize+plainSize(objInfo.Size, objInfo.Metadata.Get("X-Amz-Meta-"+encryptionMeta)) > STORAGE_LIMIT_BYTES {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Copy would exceed storage limit of 100MB"})
+			return
+		}
+
+		newPath, err := s.uniqueItemPath(ctx, bucketName, destinationPath, filepath.Base(sourcePath), false, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to choose a name for the copy", "details": err.Error()})
+			return
+		}
+
+		src := minio.CopySrcOptions{
+			Bucket: bucketName,
+			Object: sourcePath,
+		}
+		dst := minio.CopyDestOptions{
+			Bucket: bucketName,
+			Object: newPath,
+		}
+		if _, err := s.minioClient.CopyObject(ctx, dst, src); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy file"})
+			return
+		}
+
+		s.reindexCopiedItem(bucketName, sourcePath, newPath, "file")
+
+		c.JSON(http.StatusOK, gin.H{"message": "File copied successfully", "newPath": newPath})
+
+	case "folder":
+		srcPrefix := sourcePath + "/"
+		if strings.HasPrefix(destinationPath+"/", srcPrefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot copy a folder into itself"})
+			return
+		}
+
+		objects, err := s.listPrefix(ctx, bucketName, srcPrefix)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list folder", "details": err.Error()})
+			return
+		}
+		if len(objects) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		if currentSize+s.prefixSize(ctx, bucketName, srcPrefix) > STORAGE_LIMIT_BYTES {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Copy would exceed storage limit of 100MB"})
+			return
+		}
+
+		newPath, err := s.uniqueItemPath(ctx, bucketName, destinationPath, filepath.Base(sourcePath), true, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to choose a name for the copy", "details": err.Error()})
+			return
+		}
+
+		// Large folders are copied in the background so the client can show progress
+		if len(objects) > copyJobThreshold {
+			j, err := s.jobs.start(bucketName, "copy", len(objects))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start copy", "details": err.Error()})
+				return
+			}
+			go func() {
+				_, err := s.copyObjects(ctx, bucketName, objects, srcPrefix, newPath+"/", j.step)
+				if err != nil {
+					log.Printf("Error copying folder %s: %v", sourcePath, err)
+				} else {
+					s.reindexCopiedItem(bucketName, sourcePath, newPath, "folder")
+				}
+				j.finish(gin.H{"newPath": newPath}, err)
+			}()
+
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": "Copy started",
+				"jobId":   j.id,
+				"total":   len(objects),
+				"newPath": newPath,
+			})
+			return
+		}
+
+		if _, err := s.copyObjects(ctx, bucketName, objects, srcPrefix, newPath+"/", nil); err != nil {
+			log.Printf("Error copying folder %s: %v", sourcePath, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy folder"})
+			return
+		}
+
+		s.reindexCopiedItem(bucketName, sourcePath, newPath, "folder")
+
+		c.JSON(http.StatusOK, gin.H{"message": "Folder copied successfully", "newPath": newPath})
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+	}
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goDatabase/internal/database"
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/kms"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Object metadata marking encrypted objects and whose data key they use
+	encryptionMeta      = "Encryption"
+	encryptionOwnerMeta = "Encryption-Owner"
+	encryptionScheme    = "aes-256-gcm-chunked"
+
+	// Where re-encrypted objects are written before replacing the original
+	reencryptPrefix = ".encryption/"
+
+	// Data keys rewrapped per database round trip
+	rewrapBatchSize = 100
+	// How often the KMS is asked whether the master key was rotated
+	masterKeyCheckInterval = 5 * time.Minute
+)
+
+// errEncryptionDisabled is returned when reading an encrypted object while
+// no master keys are configured.
+var errEncryptionDisabled = errors.New("file is encrypted but encryption is not configured")
+
+// storedObject is an object opened for reading, decrypted if it is stored
+// encrypted.
+type storedObject interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// decryptedObject closes the underlying object of a decrypting reader.
+type decryptedObject struct {
+	*envelope.Reader
+	object *minio.Object
+}
+
+func (o decryptedObject) Close() error { return o.object.Close() }
+
+// dataKeyCache keeps unwrapped data keys by user and version, so objects are
+// not a database round trip away from being read.
+type dataKeyCache struct {
+	mu   sync.Mutex
+	keys map[int]map[int][]byte
+}
+
+func (c *dataKeyCache) get(userID int) map[int][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keys[userID]
+}
+
+func (c *dataKeyCache) set(userID int, keys map[int][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[int]map[int][]byte)
+	}
+	c.keys[userID] = keys
+}
+
+// EnableEncryption encrypts every file stored from now on with the owner's
+// data key, wrapped by masterKeys. Data and vault keys wrapped with an older
+// master key are rewrapped with the current one in the background, at start
+// and whenever the master key is rotated.
+func (s *Server) EnableEncryption(masterKeys kms.KMS) {
+	s.masterKeys = masterKeys
+	go s.watchMasterKey()
+}
+
+// watchMasterKey rewraps data and vault keys whenever the current master key
+// changes.
+func (s *Server) watchMasterKey() {
+	ctx := context.Background()
+	rewrappedFor := ""
+	for {
+		current, err := s.masterKeys.CurrentID(ctx)
+		if err != nil {
+			log.Printf("Error getting current master key: %v", err)
+		} else if current != rewrappedFor {
+			s.rewrapDataKeys(ctx, current)
+			s.rewrapVaults(ctx, current)
+			rewrappedFor = current
+		}
+		time.Sleep(masterKeyCheckInterval)
+	}
+}
+
+// wrapContext binds a wrapped data key to its owner and version, so it cannot
+// be moved to another user's row.
+func wrapContext(userID, version int) []byte {
+	return []byte(fmt.Sprintf("user:%d:version:%d", userID, version))
+}
+
+// loadDataKeys unwraps every version of a user's data key. Keys that fail to
+// unwrap are an error rather than skipped, so files are never written with a
+// stale version.
+func (s *Server) loadDataKeys(userID int, refresh bool) (map[int][]byte, error) {
+	if keys := s.dataKeys.get(userID); keys != nil && !refresh {
+		return keys, nil
+	}
+
+	stored, err := s.db.DataKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	keys := make(map[int][]byte, len(stored))
+	for _, k := range stored {
+		key, err := s.masterKeys.Unwrap(ctx, k.MasterKeyID, k.WrappedKey, wrapContext(userID, k.Version))
+		if err != nil {
+			return nil, fmt.Errorf("unwrapping data key %d of user %d: %w", k.Version, userID, err)
+		}
+		keys[k.Version] = key
+	}
+	s.dataKeys.set(userID, keys)
+	return keys, nil
+}
+
+// addDataKey creates and stores version of a user's data key. If another
+// request created it first, that key is used instead.
+func (s *Server) addDataKey(userID, version int) ([]byte, error) {
+	key, err := envelope.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	masterKeyID, wrapped, err := s.masterKeys.Wrap(context.Background(), key, wrapContext(userID, version))
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.AddDataKey(userID, version, wrapped, masterKeyID)
+	if errors.Is(err, database.ErrDataKeyExists) {
+		keys, err := s.loadDataKeys(userID, true)
+		if err != nil {
+			return nil, err
+		}
+		return keys[version], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.loadDataKeys(userID, true); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// currentDataKey returns the newest version of a user's data key, creating
+// the first one on their first encrypted upload.
+func (s *Server) currentDataKey(userID int) (int, []byte, error) {
+	keys, err := s.loadDataKeys(userID, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	version := 0
+	for v := range keys {
+		version = max(version, v)
+	}
+	if version == 0 {
+		key, err := s.addDataKey(userID, 1)
+		return 1, key, err
+	}
+	return version, keys[version], nil
+}
+
+// dataKey returns one version of a user's data key. Versions created by
+// another server since the cache was filled are looked up again.
+func (s *Server) dataKey(userID, version int) ([]byte, error) {
+	keys, err := s.loadDataKeys(userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := keys[version]; ok {
+		return key, nil
+	}
+	if keys, err = s.loadDataKeys(userID, true); err != nil {
+		return nil, err
+	}
+	if key, ok := keys[version]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("user %d has no data key version %d", userID, version)
+}
+
+// putObject stores an object in a user's bucket, encrypted with their data
+// key when encryption is enabled. Files in the user's vault are encrypted
+// with the vault key carried by ctx instead, and refused without it. size
+// must be known.
+func (s *Server) putObject(ctx context.Context, bucketName, key string, r io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	userID, err := userIDFromBucket(bucketName)
+	if inVault(key) && err == nil {
+		if vaultKey, ok := vaultKeyFrom(ctx, userID); ok {
+			return s.putEncrypted(ctx, bucketName, key, r, size, opts, vaultKey, 1, map[string]string{
+				encryptionOwnerMeta: strconv.Itoa(userID),
+				vaultKeyMeta:        vaultKeyName,
+			})
+		}
+		has, err := s.hasVault(userID)
+		if err != nil {
+			return minio.UploadInfo{}, err
+		}
+		if has {
+			return minio.UploadInfo{}, errVaultLocked
+		}
+	}
+
+	if s.masterKeys == nil {
+		return s.minioClient.PutObject(ctx, bucketName, key, r, size, opts)
+	}
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	return s.putObjectFor(ctx, userID, bucketName, key, r, size, opts)
+}
+
+// putObjectFor stores an object encrypted with the data key of userID, who
+// need not own the bucket; thumbnails are kept in a shared one.
+func (s *Server) putObjectFor(ctx context.Context, userID int, bucketName, key string, r io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	if s.masterKeys == nil {
+		return s.minioClient.PutObject(ctx, bucketName, key, r, size, opts)
+	}
+
+	version, dataKey, err := s.currentDataKey(userID)
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("getting data key: %v", err)
+	}
+	return s.putEncrypted(ctx, bucketName, key, r, size, opts, dataKey, version, map[string]string{
+		encryptionOwnerMeta: strconv.Itoa(userID),
+	})
+}
+
+// putEncrypted stores an object encrypted with key, adding keyMeta to its
+// metadata so getObject can find the key again.
+func (s *Server) putEncrypted(ctx context.Context, bucketName, key string, r io.Reader, size int64, opts minio.PutObjectOptions, dataKey []byte, version int, keyMeta map[string]string) (minio.UploadInfo, error) {
+	if size < 0 {
+		return minio.UploadInfo{}, errors.New("encrypted uploads need a known size")
+	}
+	encrypted, err := envelope.NewEncrypter(r, dataKey, uint32(version))
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	metadata := make(map[string]string, len(opts.UserMetadata)+len(keyMeta)+1)
+	for k, v := range opts.UserMetadata {
+		metadata[k] = v
+	}
+	for k, v := range keyMeta {
+		metadata[k] = v
+	}
+	metadata[encryptionMeta] = encryptionScheme
+	opts.UserMetadata = metadata
+	return s.minioClient.PutObject(ctx, bucketName, key, encrypted, envelope.EncryptedSize(size), opts)
+}
+
+// plainSize returns the size of the content of an object stored with size
+// bytes, given its encryption metadata. Encrypted objects carry a header and
+// a tag per chunk, which do not count against the quota.
+func plainSize(size int64, scheme string) int64 {
+	if scheme == encryptionScheme {
+		if plain, err := envelope.PlainSize(size); err == nil {
+			return plain
+		}
+	}
+	return size
+}
+
+// getObject opens an object for reading. Encrypted objects are decrypted as
+// they are read, and the returned info has their plaintext size. Reads are
+// lazy, so seeking only fetches the part of the object that is read. Vault
+// objects need the vault key carried by ctx; without it getObject returns
+// errVaultLocked.
+func (s *Server) getObject(ctx context.Context, bucketName, key string) (storedObject, minio.ObjectInfo, error) {
+	object, err := s.minioClient.GetObject(ctx, bucketName, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, minio.ObjectInfo{}, err
+	}
+	if info.Metadata.Get("X-Amz-Meta-"+encryptionMeta) != encryptionScheme {
+		return object, info, nil
+	}
+
+	if s.masterKeys == nil {
+		object.Close()
+		return nil, info, errEncryptionDisabled
+	}
+	owner, err := strconv.Atoi(info.Metadata.Get("X-Amz-Meta-" + encryptionOwnerMeta))
+	if err != nil {
+		object.Close()
+		return nil, info, fmt.Errorf("encrypted object %s has no key owner", key)
+	}
+	dataKey := func(version uint32) ([]byte, error) {
+		return s.dataKey(owner, int(version))
+	}
+	if info.Metadata.Get("X-Amz-Meta-"+vaultKeyMeta) == vaultKeyName {
+		vaultKey, ok := vaultKeyFrom(ctx, owner)
+		if !ok {
+			object.Close()
+			return nil, info, errVaultLocked
+		}
+		dataKey = func(uint32) ([]byte, error) { return vaultKey, nil }
+	}
+	reader, err := envelope.NewReader(object, info.Size, dataKey)
+	if err != nil {
+		object.Close()
+		return nil, info, fmt.Errorf("decrypting %s: %w", key, err)
+	}
+	info.Size = reader.Size()
+	return decryptedObject{Reader: reader, object: object}, info, nil
+}
+
+// rewrapDataKeys wraps every data key still wrapped by an older master key
+// with the current one. Once it logs that it is done, older master keys can
+// be removed from the configuration.
+func (s *Server) rewrapDataKeys(ctx context.Context, current string) {
+	rewrapped := 0
+	for {
+		keys, err := s.db.DataKeysNotWrappedBy(current, rewrapBatchSize)
+		if err != nil {
+			log.Printf("Error listing data keys to rewrap: %v", err)
+			return
+		}
+		progress := 0
+		for _, k := range keys {
+			aad := wrapContext(k.UserID, k.Version)
+			key, err := s.masterKeys.Unwrap(ctx, k.MasterKeyID, k.WrappedKey, aad)
+			if err != nil {
+				log.Printf("Error unwrapping data key %d of user %d: %v", k.Version, k.UserID, err)
+				continue
+			}
+			keyID, wrapped, err := s.masterKeys.Wrap(ctx, key, aad)
+			if err == nil {
+				err = s.db.RewrapDataKey(k.UserID, k.Version, wrapped, keyID)
+			}
+			if err != nil {
+				log.Printf("Error rewrapping data key %d of user %d: %v", k.Version, k.UserID, err)
+				continue
+			}
+			progress++
+		}
+		rewrapped += progress
+		if len(keys) < rewrapBatchSize || progress == 0 {
+			break
+		}
+	}
+	if rewrapped > 0 {
+		log.Printf("Rewrapped %d data keys with master key %s", rewrapped, current)
+	}
+}
+
+// encryptionStatusHandler tells whether the user's files are encrypted and
+// with which version of their data key.
+func (s *Server) encryptionStatusHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	if s.masterKeys == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	keys, err := s.loadDataKeys(userID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get encryption keys", "details": err.Error()})
+		return
+	}
+	version := 0
+	for v := range keys {
+		version = max(version, v)
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "keyVersion": version})
+}
+
+// rotateEncryptionHandler starts a new version of the user's data key and
+// re-encrypts their files with it in a background job. Files stored before
+// encryption was enabled are encrypted by the same job, and the plaintext
+// index of their contents is dropped. Older key versions
+// are kept so files the job has not reached yet stay readable. The vault has
+// its own key and is left alone.
+func (s *Server) rotateEncryptionHandler(c *gin.Context) {
+	userID, bucketName, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	if s.masterKeys == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "File encryption is not enabled on this server"})
+		return
+	}
+
+	version, _, err := s.currentDataKey(userID)
+	if err == nil {
+		_, err = s.addDataKey(userID, version+1)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate encryption key", "details": err.Error()})
+		return
+	}
+
+	objects, err := s.listPrefix(c.Request.Context(), bucketName, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files", "details": err.Error()})
+		return
+	}
+	var keys []string
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, "/") && !strings.HasPrefix(object.Key, reencryptPrefix) && !inVault(object.Key) {
+			keys = append(keys, object.Key)
+		}
+	}
+
+	j, err := s.jobs.start(bucketName, "reencrypt", len(keys))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start re-encryption", "details": err.Error()})
+		return
+	}
+	go func() {
+		if err := s.db.ClearUserFileContent(userID); err != nil {
+			log.Printf("Error clearing content index of user %d: %v", userID, err)
+		}
+		reencrypted, failed := 0, make([]string, 0)
+		for _, key := range keys {
+			changed, err := s.reencryptObject(context.Background(), bucketName, key, version+1)
+			switch {
+			case err != nil:
+				log.Printf("Error re-encrypting %s/%s: %v", bucketName, key, err)
+				failed = append(failed, key)
+			case changed:
+				reencrypted++
+			}
+			j.step()
+		}
+		j.finish(gin.H{"keyVersion": version + 1, "reencrypted": reencrypted, "failed_files": failed}, nil)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"jobId": j.id, "keyVersion": version + 1})
+}
+
+// reencryptObject rewrites an object encrypted with the current data key.
+// The new copy is written aside and then copied over the original, unless
+// the original changed in the meantime. It reports false for objects that
+// already use version.
+func (s *Server) reencryptObject(ctx context.Context, bucketName, key string, version int) (bool, error) {
+	object, info, err := s.getObject(ctx, bucketName, key)
+	if err != nil {
+		return false, err
+	}
+	defer object.Close()
+	if d, ok := object.(decryptedObject); ok && int(d.KeyVersion()) >= version {
+		return false, nil
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range info.Metadata {
+		name, ok := strings.CutPrefix(k, "X-Amz-Meta-")
+		if ok && name != encryptionMeta && name != encryptionOwnerMeta && len(v) > 0 {
+			metadata[name] = v[0]
+		}
+	}
+
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return false, err
+	}
+	tempKey := reencryptPrefix + hex.EncodeToString(suffix)
+	defer s.minioClient.RemoveObject(context.Background(), bucketName, tempKey, minio.RemoveObjectOptions{})
+
+	_, err = s.putObject(ctx, bucketName, tempKey, object, info.Size, minio.PutObjectOptions{
+		ContentType:  info.ContentType,
+		UserMetadata: metadata,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	current, err := s.minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	if current.ETag != info.ETag {
+		return false, fmt.Errorf("file changed while it was re-encrypted")
+	}
+	dst := minio.CopyDestOptions{Bucket: bucketName, Object: key}
+	src := minio.CopySrcOptions{Bucket: bucketName, Object: tempKey}
+	if _, err := s.minioClient.CopyObject(ctx, dst, src); err != nil {
+		return false, err
+	}
+	return true, nil
+}
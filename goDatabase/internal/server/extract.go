@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goDatabase/internal/archive"
+	"goDatabase/internal/contenttype"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// Limits on what a single archive may unpack to, against zip bombs. The
+// total size is further bounded by the user's remaining quota.
+var archiveLimits = archive.Limits{
+	MaxEntries: 10000,
+	MaxSize:    STORAGE_LIMIT_BYTES,
+	MaxRatio:   200,
+}
+
+var errArchiveNotFound = errors.New("archive not found")
+
+// errArchiveUnreadable wraps failures to read an archive from storage, as
+// opposed to archives that are invalid or too large.
+var errArchiveUnreadable = errors.New("cannot read archive")
+
+// extractPlan is an archive that has been checked and is ready to unpack.
+type extractPlan struct {
+	archiveKey  string
+	format      archive.Format
+	size        int64
+	destination string // folder the entries are written into, without slash
+	entries     []archive.Entry
+	files       int
+	total       int64 // uncompressed bytes
+}
+
+// planExtraction reads the entry list of an archive and checks it against the
+// archive limits, the object key rules and the storage quota, so nothing is
+// written for an archive that cannot be unpacked in full. An empty
+// destination means a new folder named after the archive next to it.
+func (s *Server) planExtraction(ctx context.Context, bucketName, archiveKey, destination string) (*extractPlan, error) {
+	format := archive.Detect(archiveKey)
+	if format == archive.Unknown {
+		return nil, fmt.Errorf("%s is not a .zip, .tar or .tar.gz archive", filepath.Base(archiveKey))
+	}
+
+	object, info, err := s.getObject(ctx, bucketName, archiveKey)
+	if isNotFound(err) {
+		return nil, errArchiveNotFound
+	}
+	if err == errVaultLocked {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errArchiveUnreadable, err)
+	}
+	defer object.Close()
+
+	r := &readAtErrRecorder{r: object}
+	entries, total, err := archive.Scan(format, r, info.Size, archiveLimits)
+	if r.err != nil {
+		return nil, fmt.Errorf("%w: %v", errArchiveUnreadable, r.err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if destination == "" {
+		destination, err = s.uniqueItemPath(ctx, bucketName, parentPath(archiveKey), archive.BaseName(filepath.Base(archiveKey)), true, nil)
+		if err != nil {
+			return nil, fmt.Errorf("choosing a destination folder: %v", err)
+		}
+	}
+
+	plan := &extractPlan{archiveKey: archiveKey, format: format, size: info.Size, destination: destination, entries: entries, total: total}
+	for _, e := range entries {
+		if _, err := cleanRelativePath(e.Name); err != nil {
+			return nil, err
+		}
+		if len(destination)+1+len(e.Name) > maxObjectKeyLength {
+			return nil, fmt.Errorf("%s: path too long", e.Name)
+		}
+		if !e.IsDir {
+			plan.files++
+		}
+	}
+
+	if s.bucketSize(ctx, bucketName)+total > STORAGE_LIMIT_BYTES {
+		return nil, errors.New("extracting would exceed storage limit of 100MB")
+	}
+	return plan, nil
+}
+
+// startExtraction unpacks a planned archive in the background. Progress is
+// counted in files and reported through /api/jobs/:id. The job keeps the
+// values of ctx, such as an unlocked vault key, but not its cancellation.
+func (s *Server) startExtraction(ctx context.Context, bucketName string, plan *extractPlan, policy string) (*job, error) {
+	ctx = context.WithoutCancel(ctx)
+	j, err := s.jobs.start(bucketName, "extract", plan.files)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		result, err := s.extractArchive(ctx, bucketName, plan, policy, j.step)
+		if err != nil {
+			log.Printf("Error extracting %s: %v", plan.archiveKey, err)
+		}
+		j.finish(result, err)
+	}()
+	return j, nil
+}
+
+// extractArchive writes the entries of an archive below its destination
+// folder, resolving name clashes with policy like an upload does. It returns
+// what was written even when it stops early.
+func (s *Server) extractArchive(ctx context.Context, bucketName string, plan *extractPlan, policy string, step func()) (gin.H, error) {
+	extracted := make([]string, 0)
+	failed := make([]string, 0)
+	conflicts := make([]uploadConflict, 0)
+	result := func() gin.H {
+		return gin.H{
+			"archive":         plan.archiveKey,
+			"destination":     plan.destination,
+			"extracted_files": extracted,
+			"total_extracted": len(extracted),
+			"failed_files":    failed,
+			"conflicts":       conflicts,
+		}
+	}
+
+	// Folders first, including empty ones and the destination itself
+	base := parentPath(plan.destination)
+	if base != "" {
+		base += "/"
+	}
+	keys := []string{plan.destination + "/"}
+	for _, e := range plan.entries {
+		key := plan.destination + "/" + e.Name
+		if e.IsDir {
+			key += "/"
+		}
+		keys = append(keys, key)
+	}
+	createdFolders, err := s.createUploadFolders(ctx, bucketName, base, keys)
+	if err != nil {
+		return result(), fmt.Errorf("creating folders: %v", err)
+	}
+
+	object, _, err := s.getObject(ctx, bucketName, plan.archiveKey)
+	if err != nil {
+		return result(), err
+	}
+	defer object.Close()
+
+	// Entries may not grow past the sizes checked against the quota
+	limits := archiveLimits
+	limits.MaxSize = plan.total
+	err = archive.Walk(plan.format, object, plan.size, limits, func(e archive.Entry, data io.Reader) error {
+		if e.IsDir {
+			return nil
+		}
+		defer step()
+
+		objectName := plan.destination + "/" + e.Name
+		targetName, action, err := s.resolveUploadConflict(ctx, bucketName, objectName, policy, nil)
+		if err != nil {
+			log.Printf("Failed to check for existing file %s: %v", objectName, err)
+			failed = append(failed, e.Name)
+			return nil
+		}
+		if action != "" {
+			conflictPath := objectName
+			if action == "renamed" {
+				conflictPath = targetName
+			}
+			conflicts = append(conflicts, uploadConflict{File: e.Name, Path: conflictPath, Action: action})
+		}
+		if action == "failed" {
+			failed = append(failed, e.Name)
+			return nil
+		}
+		if action == "skipped" {
+			return nil
+		}
+
+		contentType := contenttype.ByName(e.Name)
+		src := &readErrRecorder{r: data}
+		_, err = s.putObject(ctx, bucketName, targetName, src, e.Size, minio.PutObjectOptions{ContentType: contentType})
+		if src.err != nil {
+			// A broken archive, or one that lied about its sizes, cannot
+			// be read any further
+			failed = append(failed, e.Name)
+			return fmt.Errorf("%s: %v", e.Name, src.err)
+		}
+		if err != nil {
+			log.Printf("Failed to extract %s: %v", targetName, err)
+			failed = append(failed, e.Name)
+			return nil
+		}
+
+		extracted = append(extracted, targetName)
+		s.indexFile(bucketName, targetName, contentType, e.Size, time.Now())
+		s.recordActivity(bucketName, targetName, "extract")
+		s.queueUploadProcessing(bucketName, targetName, contentType)
+		return nil
+	})
+
+	res := result()
+	res["created_folders"] = createdFolders
+	return res, err
+}
+
+// extractHandler unpacks a .zip, .tar or .tar.gz object already in the bucket.
+func (s *Server) extractHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path        string `json:"path"`        // Archive to unpack
+		Destination string `json:"destination"` // Folder to unpack into, "" for a new folder next to the archive
+		Conflict    string `json:"conflict"`    // Policy for names that are taken, as for uploads
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	archiveKey := strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.Path)), "/")
+	if archiveKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path cannot be empty"})
+		return
+	}
+	destination := ""
+	if req.Destination != "" {
+		destination = strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.Destination)), "/")
+	}
+
+	policy, _, err := parseConflictPolicies(req.Conflict, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conflict policy", "details": err.Error()})
+		return
+	}
+	if !s.checkVault(c, bucketName, archiveKey, destination) {
+		return
+	}
+
+	plan, err := s.planExtraction(c.Request.Context(), bucketName, archiveKey, destination)
+	if errors.Is(err, errArchiveNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Archive not found"})
+		return
+	}
+	if err == errVaultLocked {
+		c.JSON(http.StatusLocked, gin.H{"error": "Vault is locked", "details": "Verify your face to unlock it"})
+		return
+	}
+	if errors.Is(err, errArchiveUnreadable) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read archive", "details": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot extract archive", "details": err.Error()})
+		return
+	}
+	if err := s.checkVaultBoundary(bucketName, archiveKey, plan.destination); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot extract archive", "details": err.Error()})
+		return
+	}
+
+	j, err := s.startExtraction(c.Request.Context(), bucketName, plan, policy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start extraction", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "Extraction started",
+		"jobId":       j.id,
+		"total":       plan.files,
+		"destination": plan.destination,
+		"size":        plan.total,
+	})
+}
+
+// extractUploads starts extracting every archive among the uploaded objects,
+// for uploads sent with extract=true. Archives that cannot be extracted are
+// reported but stay uploaded.
+func (s *Server) extractUploads(ctx context.Context, bucketName string, objectNames []string, policy string) []gin.H {
+	jobs := make([]gin.H, 0)
+	for _, name := range objectNames {
+		if archive.Detect(name) == archive.Unknown {
+			continue
+		}
+		plan, err := s.planExtraction(ctx, bucketName, name, "")
+		if err != nil {
+			jobs = append(jobs, gin.H{"archive": name, "error": err.Error()})
+			continue
+		}
+		j, err := s.startExtraction(ctx, bucketName, plan, policy)
+		if err != nil {
+			jobs = append(jobs, gin.H{"archive": name, "error": err.Error()})
+			continue
+		}
+		jobs = append(jobs, gin.H{"archive": name, "jobId": j.id, "total": plan.files, "destination": plan.destination})
+	}
+	return jobs
+}
+
+// readErrRecorder remembers the first error of the reader it wraps, to tell
+// failures of the archive apart from failures to store an entry.
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (rr *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if err != nil && err != io.EOF && rr.err == nil {
+		rr.err = err
+	}
+	return n, err
+}
+
+// readAtErrRecorder is readErrRecorder for random access reads.
+type readAtErrRecorder struct {
+	r   io.ReaderAt
+	err error
+}
+
+func (rr *readAtErrRecorder) ReadAt(p []byte, off int64) (int, error) {
+	n, err := rr.r.ReadAt(p, off)
+	if err != nil && err != io.EOF && rr.err == nil {
+		rr.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"goDatabase/internal/contenttype"
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Most allowed types a file request can list
+	maxAllowedTypes = 20
+	// Longest uploader name or email kept with a file
+	maxUploaderLength = 255
+	// Room for multipart headers on top of the file bytes of an upload
+	multipartOverhead = 1 << 20
+)
+
+// fileRequestResponse adds the public URL path to a file request.
+func fileRequestResponse(request database.FileRequest) gin.H {
+	return gin.H{"request": request, "url": "/r/" + request.Token}
+}
+
+// normalizeAllowedTypes checks the types a file request accepts: extensions
+// such as ".pdf", MIME types such as "application/pdf", or groups such as
+// "image/*".
+func normalizeAllowedTypes(types []string) ([]string, error) {
+	if len(types) > maxAllowedTypes {
+		return nil, fmt.Errorf("at most %d allowed types", maxAllowedTypes)
+	}
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		valid := len(t) > 1 && len(t) <= 100 && !strings.ContainsAny(t, ", ;") &&
+			(strings.HasPrefix(t, ".") || strings.Count(t, "/") == 1)
+		if !valid {
+			return nil, fmt.Errorf("invalid type %q", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+	return normalized, nil
+}
+
+// allowedType reports whether a file name matches the types a file request
+// accepts. Types are decided from the name, the same way uploads are stored.
+func allowedType(name string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(name))
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(contenttype.ByName(name), ";")[0]))
+	for _, a := range allowed {
+		switch {
+		case strings.HasPrefix(a, "."):
+			if ext == a {
+				return true
+			}
+		case strings.HasSuffix(a, "/*"):
+			if strings.HasPrefix(ct, strings.TrimSuffix(a, "*")) {
+				return true
+			}
+		case ct == a:
+			return true
+		}
+	}
+	return false
+}
+
+// createFileRequestHandler creates a link through which people without an
+// account can upload into one of the user's folders.
+func (s *Server) createFileRequestHandler(c *gin.Context) {
+	userID, bucketName, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path          string     `json:"path"` // Folder uploads go into
+		Title         string     `json:"title"`
+		ExpiresAt     *time.Time `json:"expiresAt"`
+		ExpiresIn     int        `json:"expiresIn"` // seconds, used when expiresAt is not set
+		MaxTotalBytes int64      `json:"maxTotalBytes"`
+		AllowedTypes  []string   `json:"allowedTypes"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	folderPath := strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.Path)), "/")
+	if folderPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Choose a folder for the uploads"})
+		return
+	}
+	if !s.checkVaultNotShared(c, userID, folderPath) {
+		return
+	}
+	if len(req.Title) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Title is too long"})
+		return
+	}
+	if req.MaxTotalBytes < 0 || req.ExpiresIn < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits cannot be negative"})
+		return
+	}
+	allowedTypes, err := normalizeAllowedTypes(req.AllowedTypes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid allowed types", "details": err.Error()})
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if expiresAt == nil && req.ExpiresIn > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	exists, err := s.prefixExists(c.Request.Context(), bucketName, folderPath+"/")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check folder", "details": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file request", "details": err.Error()})
+		return
+	}
+
+	request, err := s.db.CreateFileRequest(database.FileRequest{
+		Token:         token,
+		UserID:        userID,
+		FolderPath:    folderPath,
+		Title:         strings.TrimSpace(req.Title),
+		ExpiresAt:     expiresAt,
+		MaxTotalBytes: req.MaxTotalBytes,
+		AllowedTypes:  allowedTypes,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file request", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, fileRequestResponse(request))
+}
+
+// listFileRequestsHandler lists the user's file requests.
+func (s *Server) listFileRequestsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	requests, err := s.db.ListFileRequests(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list file requests", "details": err.Error()})
+		return
+	}
+	resp := make([]gin.H, len(requests))
+	for i, request := range requests {
+		resp[i] = fileRequestResponse(request)
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": resp})
+}
+
+// revokeFileRequestHandler closes a file request to further uploads.
+func (s *Server) revokeFileRequestHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	err := s.db.RevokeFileRequest(userID, c.Param("token"))
+	if errors.Is(err, database.ErrFileRequestNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File request not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke file request", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "File request closed"})
+}
+
+// openFileRequest looks up the file request of a public request and checks
+// that it still takes uploads. When it returns false an error response has
+// already been written.
+func (s *Server) openFileRequest(c *gin.Context) (database.FileRequest, bool) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+
+	request, err := s.db.GetFileRequest(c.Param("token"))
+	if errors.Is(err, database.ErrFileRequestNotFound) || (err == nil && request.Revoked) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File request not found"})
+		return request, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file request"})
+		return request, false
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "File request has expired"})
+		return request, false
+	}
+	return request, true
+}
+
+// remainingRequestBytes is how much more can be uploaded through a request:
+// what is left of its own limit and of the owner's storage.
+func (s *Server) remainingRequestBytes(ctx context.Context, request database.FileRequest) int64 {
+	remaining := STORAGE_LIMIT_BYTES - s.bucketSize(ctx, bucketForUser(request.UserID))
+	if request.MaxTotalBytes > 0 {
+		remaining = min(remaining, request.MaxTotalBytes-request.UploadedBytes)
+	}
+	return max(remaining, 0)
+}
+
+// fileRequestInfoHandler describes a file request to visitors. Nothing about
+// the folder's contents is revealed.
+func (s *Server) fileRequestInfoHandler(c *gin.Context) {
+	request, ok := s.openFileRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"title":          request.Title,
+		"folder":         path.Base(request.FolderPath),
+		"expiresAt":      request.ExpiresAt,
+		"allowedTypes":   request.AllowedTypes,
+		"remainingBytes": s.remainingRequestBytes(c.Request.Context(), request),
+	})
+}
+
+// fileRequestUploadHandler stores files sent through a file request. Files
+// never replace anything in the folder; clashing names get a " (1)" suffix.
+// The uploader's name and email are kept as object metadata, and the owner
+// is notified.
+func (s *Server) fileRequestUploadHandler(c *gin.Context) {
+	request, ok := s.openFileRequest(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	bucketName := bucketForUser(request.UserID)
+
+	remaining := s.remainingRequestBytes(ctx, request)
+	if remaining == 0 {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "This request does not take more files"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, remaining+multipartOverhead)
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is larger than this request allows"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form", "details": err.Error()})
+		return
+	}
+	form := c.Request.MultipartForm
+
+	uploaderName := strings.TrimSpace(c.Request.FormValue("name"))
+	uploaderEmail := strings.TrimSpace(c.Request.FormValue("email"))
+	if uploaderName == "" && uploaderEmail == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Enter your name or email"})
+		return
+	}
+	if len(uploaderName) > maxUploaderLength || len(uploaderEmail) > maxUploaderLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name or email is too long"})
+		return
+	}
+	if uploaderEmail != "" {
+		if _, err := mail.ParseAddress(uploaderEmail); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email"})
+			return
+		}
+	}
+
+	files := form.File["files"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded"})
+		return
+	}
+	fileNames := make([]string, len(files))
+	var totalSize int64
+	for i, fileHeader := range files {
+		name := path.Base(strings.ReplaceAll(fileHeader.Filename, `\`, "/"))
+		if err := validateItemName(name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file name", "details": err.Error()})
+			return
+		}
+		if !allowedType(name, request.AllowedTypes) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "File type not accepted", "details": name})
+			return
+		}
+		fileNames[i] = name
+		totalSize += fileHeader.Size
+	}
+
+	// Quota first, then the request's own allowance, reserved so parallel
+	// uploads cannot both take the last of it
+	if s.bucketSize(ctx, bucketName)+totalSize > STORAGE_LIMIT_BYTES {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is larger than this request allows"})
+		return
+	}
+	reserved, err := s.db.ReserveFileRequestBytes(request.RequestID, totalSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check upload size"})
+		return
+	}
+	if !reserved {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is larger than this request allows"})
+		return
+	}
+
+	metadata := map[string]string{"File-Request": strconv.Itoa(request.RequestID)}
+	if uploaderName != "" {
+		metadata["Uploader-Name"] = mime.QEncoding.Encode("utf-8", uploaderName)
+	}
+	if uploaderEmail != "" {
+		metadata["Uploader-Email"] = uploaderEmail
+	}
+
+	uploaded := make([]string, 0)
+	failed := make([]string, 0)
+	var unused int64
+	for i, fileHeader := range files {
+		objectName, err := s.uniqueItemPath(ctx, bucketName, request.FolderPath, fileNames[i], false, nil)
+		if err != nil {
+			log.Printf("Failed to choose a name for %s: %v", fileNames[i], err)
+			failed = append(failed, fileNames[i])
+			unused += fileHeader.Size
+			continue
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			failed = append(failed, fileNames[i])
+			unused += fileHeader.Size
+			continue
+		}
+		contentType := contenttype.ByName(fileNames[i])
+		_, err = s.putObject(ctx, bucketName, objectName, file, fileHeader.Size, minio.PutObjectOptions{
+			ContentType:  contentType,
+			UserMetadata: metadata,
+		})
+		file.Close()
+		if err != nil {
+			log.Printf("Failed to store %s from file request %d: %v", objectName, request.RequestID, err)
+			failed = append(failed, fileNames[i])
+			unused += fileHeader.Size
+			continue
+		}
+
+		uploaded = append(uploaded, fileNames[i])
+		s.indexFile(bucketName, objectName, contentType, fileHeader.Size, time.Now())
+		s.queueUploadProcessing(bucketName, objectName, contentType)
+	}
+
+	if unused > 0 {
+		if err := s.db.ReleaseFileRequestBytes(request.RequestID, unused); err != nil {
+			log.Printf("Error releasing upload size of file request %d: %v", request.RequestID, err)
+		}
+	}
+	if len(uploaded) > 0 {
+		s.notifyFileRequestUpload(request, uploaderName, uploaderEmail, len(uploaded))
+	}
+
+	response := gin.H{"uploaded_files": uploaded, "total_uploaded": len(uploaded)}
+	if len(failed) > 0 {
+		response["failed_files"] = failed
+		response["total_failed"] = len(failed)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// notifyFileRequestUpload tells the owner of a file request that files came in.
+func (s *Server) notifyFileRequestUpload(request database.FileRequest, name, email string, count int) {
+	who := name
+	switch {
+	case who == "":
+		who = email
+	case email != "":
+		who = fmt.Sprintf("%s <%s>", name, email)
+	}
+	files := "files"
+	if count == 1 {
+		files = "file"
+	}
+	message := fmt.Sprintf("%s uploaded %d %s to %s", who, count, files, request.FolderPath)
+	if request.Title != "" {
+		message += fmt.Sprintf(" (%s)", request.Title)
+	}
+
+	if err := s.db.AddNotification(request.UserID, "file_request", message, request.FolderPath); err != nil {
+		log.Printf("Error notifying user %d of file request upload: %v", request.UserID, err)
+	}
+}
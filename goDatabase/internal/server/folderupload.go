@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Deepest folder nesting accepted in an uploaded relative path
+	maxUploadDepth = 32
+	// Longest object key an upload may produce
+	maxObjectKeyLength = 1024
+)
+
+// cleanRelativePath validates the relative path of an uploaded file, as sent
+// by browsers for folder uploads ("photos/2024/img.jpg"), and returns it with
+// forward slashes.
+func cleanRelativePath(relPath string) (string, error) {
+	relPath = strings.Trim(strings.ReplaceAll(relPath, `\`, "/"), "/")
+	parts := strings.Split(relPath, "/")
+	if len(parts) > maxUploadDepth {
+		return "", fmt.Errorf("%s is nested too deeply", relPath)
+	}
+	for _, part := range parts {
+		if err := validateItemName(part); err != nil {
+			return "", fmt.Errorf("%s: %v", relPath, err)
+		}
+	}
+	return relPath, nil
+}
+
+// createFolderMarker stores the empty object that represents a folder and
+// indexes it. folderPath must end with a slash.
+func (s *Server) createFolderMarker(ctx context.Context, bucketName, folderPath string) error {
+	_, err := s.minioClient.PutObject(
+		ctx,
+		bucketName,
+		folderPath,
+		bytes.NewReader([]byte{}),
+		0,
+		minio.PutObjectOptions{ContentType: "application/x-directory"},
+	)
+	if err != nil {
+		return err
+	}
+	s.indexFolder(bucketName, folderPath)
+	return nil
+}
+
+// createUploadFolders creates a marker for every folder leading up to the
+// given object keys that does not have one yet, parents first, and returns
+// the folders it created.
+func (s *Server) createUploadFolders(ctx context.Context, bucketName, basePath string, keys []string) ([]string, error) {
+	needed := make(map[string]bool)
+	for _, key := range keys {
+		for dir := parentPath(key); dir != "" && dir+"/" != basePath && strings.HasPrefix(dir+"/", basePath); dir = parentPath(dir) {
+			needed[dir] = true
+		}
+	}
+
+	folders := make([]string, 0, len(needed))
+	for dir := range needed {
+		folders = append(folders, dir)
+	}
+	sort.Strings(folders)
+
+	created := make([]string, 0)
+	for _, dir := range folders {
+		exists, err := s.objectExists(ctx, bucketName, dir+"/")
+		if err != nil {
+			return created, err
+		}
+		if exists {
+			continue
+		}
+		if err := s.createFolderMarker(ctx, bucketName, dir+"/"); err != nil {
+			return created, fmt.Errorf("creating folder %s: %v", dir, err)
+		}
+		created = append(created, dir)
+	}
+	return created, nil
+}
+
+// uploadTreeNode is one entry of the tree summary returned by an upload.
+type uploadTreeNode struct {
+	Name     string            `json:"name"`
+	Path     string            `json:"path"`
+	Type     string            `json:"type"` // "file" or "folder"
+	Created  bool              `json:"created,omitempty"`
+	Children []*uploadTreeNode `json:"children,omitempty"`
+}
+
+// buildUploadTree arranges the uploaded files and created folders below
+// basePath into a tree. Folders that already existed appear only as parents.
+func buildUploadTree(basePath string, files, createdFolders []string) []*uploadTreeNode {
+	root := &uploadTreeNode{}
+	nodes := map[string]*uploadTreeNode{"": root}
+
+	// node returns the tree entry for the relative path rel, adding it and
+	// its parents if needed
+	var node func(rel, itemType string) *uploadTreeNode
+	node = func(rel, itemType string) *uploadTreeNode {
+		if n, ok := nodes[rel]; ok {
+			return n
+		}
+		parent, name := "", rel
+		if i := strings.LastIndex(rel, "/"); i >= 0 {
+			parent, name = rel[:i], rel[i+1:]
+		}
+		n := &uploadTreeNode{Name: name, Path: basePath + rel, Type: itemType}
+		nodes[rel] = n
+		parentNode := node(parent, "folder")
+		parentNode.Children = append(parentNode.Children, n)
+		return n
+	}
+
+	for _, dir := range createdFolders {
+		node(strings.TrimPrefix(dir, basePath), "folder").Created = true
+	}
+	for _, key := range files {
+		node(strings.TrimPrefix(key, basePath), "file").Created = true
+	}
+	return root.Children
+}
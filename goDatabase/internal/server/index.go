@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goDatabase/internal/contenttype"
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// The Folder and Files tables mirror what is stored in MinIO so that listings
+// and searches do not need to scan buckets. MinIO stays the source of truth:
+// index updates are best effort. A failed one is logged and marks the bucket
+// stale, and the next listing rebuilds its index from MinIO.
+
+// staleBuckets holds the buckets whose index missed an update.
+type staleBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]bool
+}
+
+func (b *staleBuckets) mark(bucketName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buckets == nil {
+		b.buckets = make(map[string]bool)
+	}
+	b.buckets[bucketName] = true
+}
+
+// take reports whether bucketName is stale and clears the mark.
+func (b *staleBuckets) take(bucketName string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stale := b.buckets[bucketName]
+	delete(b.buckets, bucketName)
+	return stale
+}
+
+// userIDFromBucket recovers the user ID from a "user-<id>" bucket name.
+func userIDFromBucket(bucketName string) (int, error) {
+	var userID int
+	if _, err := fmt.Sscanf(bucketName, "user-%d", &userID); err != nil {
+		return 0, fmt.Errorf("not a user bucket: %s", bucketName)
+	}
+	return userID, nil
+}
+
+// indexFile records an uploaded or overwritten object in the index.
+func (s *Server) indexFile(bucketName, key, contentType string, size int64, lastModified time.Time) {
+	if strings.HasSuffix(key, "/") {
+		return
+	}
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		log.Printf("Error indexing %s: %v", key, err)
+		return
+	}
+	if err := s.db.UpsertFile(userID, key, contentType, size, lastModified); err != nil {
+		log.Printf("Error indexing %s: %v", key, err)
+		s.staleIndexes.mark(bucketName)
+	}
+}
+
+// indexFolder records a newly created folder in the index.
+func (s *Server) indexFolder(bucketName, folderPath string) {
+	folderPath = strings.Trim(folderPath, "/")
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		log.Printf("Error indexing folder %s: %v", folderPath, err)
+		return
+	}
+	if _, err := s.db.EnsureFolder(userID, folderPath); err != nil {
+		log.Printf("Error indexing folder %s: %v", folderPath, err)
+		s.staleIndexes.mark(bucketName)
+	}
+}
+
+// unindexItem drops a deleted file or folder from the index, along with its
+// thumbnails.
+func (s *Server) unindexItem(bucketName, itemPath, itemType string) {
+	s.invalidateThumbnails(bucketName, itemPath, itemType)
+
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		log.Printf("Error unindexing %s: %v", itemPath, err)
+		return
+	}
+
+	itemPath = strings.Trim(itemPath, "/")
+	if itemType == "folder" {
+		err = s.db.DeleteFolder(userID, itemPath)
+	} else {
+		err = s.db.DeleteFile(userID, itemPath)
+	}
+	if err != nil {
+		log.Printf("Error unindexing %s: %v", itemPath, err)
+		s.staleIndexes.mark(bucketName)
+	}
+}
+
+// reindexMovedItem points the index entries, share links, grants and file
+// requests of a moved or renamed item at its new path. Thumbnails at the old
+// path are dropped and rendered again on demand.
+func (s *Server) reindexMovedItem(bucketName, oldPath, newPath, itemType string) {
+	s.invalidateThumbnails(bucketName, oldPath, itemType)
+
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reindexing %s: %v", oldPath, err)
+		return
+	}
+
+	if itemType == "folder" {
+		err = s.db.MoveFolder(userID, oldPath, newPath)
+	} else {
+		err = s.db.MoveFile(userID, oldPath, newPath)
+	}
+	if err != nil {
+		log.Printf("Error reindexing %s: %v", oldPath, err)
+		s.staleIndexes.mark(bucketName)
+	}
+
+	// Share links, grants and file requests follow the item to its new path
+	if err := s.db.MoveShareLinks(userID, oldPath, newPath); err != nil {
+		log.Printf("Error moving share links of %s: %v", oldPath, err)
+	}
+	if err := s.db.MoveAccessGrants(userID, oldPath, newPath); err != nil {
+		log.Printf("Error moving access grants of %s: %v", oldPath, err)
+	}
+	if itemType == "folder" {
+		if err := s.db.MoveFileRequests(userID, oldPath, newPath); err != nil {
+			log.Printf("Error moving file requests into %s: %v", oldPath, err)
+		}
+	}
+}
+
+// reindexCopiedItem adds index entries for a copy of an item.
+func (s *Server) reindexCopiedItem(bucketName, srcPath, newPath, itemType string) {
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		log.Printf("Error indexing copy of %s: %v", srcPath, err)
+		return
+	}
+
+	if itemType == "folder" {
+		err = s.db.CopyFolder(userID, srcPath, newPath)
+	} else {
+		err = s.db.CopyFile(userID, srcPath, newPath)
+	}
+	if err != nil {
+		log.Printf("Error indexing copy of %s: %v", srcPath, err)
+		s.staleIndexes.mark(bucketName)
+	}
+}
+
+// reindexBucket rebuilds the index of a bucket from a full listing and queues
+// every file for content extraction again. Encrypted files are indexed with
+// the size of their plaintext; copies left behind by re-encryption are not
+// indexed. It returns the number of folders and files found.
+func (s *Server) reindexBucket(ctx context.Context, bucketName string) (int, int, error) {
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var folders []string
+	var files []database.FileRecord
+
+	for object := range s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if object.Err != nil {
+			return 0, 0, object.Err
+		}
+		if strings.HasPrefix(object.Key, reencryptPrefix) {
+			continue
+		}
+
+		if strings.HasSuffix(object.Key, "/") {
+			folders = append(folders, object.Key)
+			continue
+		}
+
+		contentType := object.UserMetadata["content-type"]
+		if contentType == "" {
+			contentType = object.UserMetadata["Content-Type"]
+		}
+		if contentType == "" {
+			contentType = contenttype.ByName(object.Key)
+		}
+
+		files = append(files, database.FileRecord{
+			ObjectKey:    object.Key,
+			FileType:     contentType,
+			FileSize:     plainSize(object.Size, object.UserMetadata["X-Amz-Meta-"+encryptionMeta]),
+			LastModified: object.LastModified,
+		})
+	}
+
+	if err := s.db.ReindexUser(userID, folders, files); err != nil {
+		return 0, 0, err
+	}
+
+	for _, f := range files {
+		s.queueUploadProcessing(bucketName, f.ObjectKey, f.FileType)
+	}
+
+	return len(folders), len(files), nil
+}
+
+// ensureIndexed builds the index of a bucket the first time it is needed,
+// e.g. for buckets filled before the index existed, and rebuilds it after an
+// update was missed.
+func (s *Server) ensureIndexed(ctx context.Context, bucketName string) error {
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if s.staleIndexes.take(bucketName) {
+		if _, _, err := s.reindexBucket(ctx, bucketName); err != nil {
+			s.staleIndexes.mark(bucketName)
+			return err
+		}
+		return nil
+	}
+	indexed, err := s.db.HasIndexedItems(userID)
+	if err != nil || indexed {
+		return err
+	}
+	_, _, err = s.reindexBucket(ctx, bucketName)
+	return err
+}
+
+func (s *Server) reindexHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	folders, files, err := s.reindexBucket(context.Background(), bucketName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild index", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Index rebuilt successfully",
+		"folders": folders,
+		"files":   files,
+	})
+}
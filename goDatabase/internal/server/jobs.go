@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long finished jobs stay around for clients to poll their result.
+const jobRetention = time.Hour
+
+// job tracks the progress of a long running operation, such as copying a
+// large folder, so clients can poll it instead of holding a request open.
+type job struct {
+	mu sync.Mutex
+
+	id         string
+	bucketName string
+	kind       string
+	status     string // "running", "done" or "failed"
+	done       int
+	total      int
+	err        string
+	result     gin.H
+	updated    time.Time
+}
+
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*job)}
+}
+
+// start registers a new running job owned by bucketName.
+func (js *jobStore) start(bucketName, kind string, total int) (*job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	j := &job{
+		id:         hex.EncodeToString(id),
+		bucketName: bucketName,
+		kind:       kind,
+		status:     "running",
+		total:      total,
+		updated:    time.Now(),
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	// Drop finished jobs nobody has asked about for a while
+	for id, old := range js.jobs {
+		old.mu.Lock()
+		expired := old.status != "running" && time.Since(old.updated) > jobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(js.jobs, id)
+		}
+	}
+
+	js.jobs[j.id] = j
+	return j, nil
+}
+
+func (js *jobStore) get(id string) *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.jobs[id]
+}
+
+// step records that one more unit of work has been completed.
+func (j *job) step() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done++
+	j.updated = time.Now()
+}
+
+// finish marks the job as done with the given result, or failed if err is set.
+func (j *job) finish(result gin.H, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		j.status = "failed"
+		j.err = err.Error()
+	} else {
+		j.status = "done"
+	}
+	j.result = result
+	j.updated = time.Now()
+}
+
+func (j *job) snapshot() gin.H {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	resp := gin.H{
+		"jobId":  j.id,
+		"kind":   j.kind,
+		"status": j.status,
+		"done":   j.done,
+		"total":  j.total,
+	}
+	if j.err != "" {
+		resp["error"] = j.err
+	}
+	if j.result != nil {
+		resp["result"] = j.result
+	}
+	return resp
+}
+
+func (s *Server) jobStatusHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	j := s.jobs.get(c.Param("id"))
+	if j == nil || j.bucketName != bucketName {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, j.snapshot())
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Listings are paged so that folders with thousands of entries do not come
+// back in one response. Clients that send neither a limit nor a token get the
+// whole folder, as before paging existed.
+const maxListLimit = 1000
+
+// listToken is the continuation token of a listing. It carries the listing
+// it belongs to so it cannot be replayed against another folder or order.
+type listToken struct {
+	Folder string               `json:"f"`
+	Sort   string               `json:"s"`
+	Desc   bool                 `json:"d"`
+	Tags   []string             `json:"t,omitempty"`
+	After  *database.ListCursor `json:"a"`
+}
+
+func encodeListToken(t listToken) string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListToken(s string) (listToken, error) {
+	var t listToken
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, fmt.Errorf("invalid token")
+	}
+	if err := json.Unmarshal(data, &t); err != nil || t.After == nil {
+		return t, fmt.Errorf("invalid token")
+	}
+	return t, nil
+}
+
+// parseListOptions reads the "limit", "token", "sort" and "order" parameters
+// and the tag filter of a listing of folder. Without a limit or token the
+// listing is not paged.
+func parseListOptions(c *gin.Context, folder string) (database.ListOptions, error) {
+	opts := database.ListOptions{Sort: "name"}
+	if c.Query("token") != "" {
+		opts.Limit = maxListLimit
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("invalid limit %q", v)
+		}
+		opts.Limit = min(n, maxListLimit)
+	}
+
+	switch v := c.DefaultQuery("sort", "name"); v {
+	case "name", "size", "modified", "type":
+		opts.Sort = v
+	default:
+		return opts, fmt.Errorf("invalid sort %q", v)
+	}
+
+	switch v := strings.ToLower(c.DefaultQuery("order", "asc")); v {
+	case "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("invalid order %q", v)
+	}
+
+	tags, err := parseTagQuery(c)
+	if err != nil {
+		return opts, err
+	}
+	opts.Tags = tags
+
+	if v := c.Query("token"); v != "" {
+		t, err := decodeListToken(v)
+		if err != nil {
+			return opts, err
+		}
+		if t.Folder != folder || t.Sort != opts.Sort || t.Desc != opts.Desc ||
+			strings.Join(t.Tags, ",") != strings.Join(opts.Tags, ",") {
+			return opts, fmt.Errorf("token does not belong to this listing")
+		}
+		opts.After = t.After
+	}
+
+	return opts, nil
+}
+
+// nextListToken returns the token for the page after the one described by
+// opts, or "" if there is none.
+func nextListToken(folder string, opts database.ListOptions, next *database.ListCursor) string {
+	if next == nil {
+		return ""
+	}
+	return encodeListToken(listToken{
+		Folder: folder,
+		Sort:   opts.Sort,
+		Desc:   opts.Desc,
+		Tags:   opts.Tags,
+		After:  next,
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// listNotificationsHandler lists the user's notifications, newest first.
+// ?unread=true leaves out those already read; the unread count is always
+// included.
+func (s *Server) listNotificationsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	limit := defaultNotificationLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, maxNotificationLimit)
+	}
+	unreadOnly := c.Query("unread") == "true"
+
+	notifications, unread, err := s.db.ListNotifications(userID, unreadOnly, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list notifications", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications, "unread": unread})
+}
+
+// markNotificationsReadHandler marks the given notifications as read, or all
+// of them when no ids are sent.
+func (s *Server) markNotificationsReadHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := s.db.MarkNotificationsRead(userID, req.IDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notifications", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read"})
+}
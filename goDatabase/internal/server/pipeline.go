@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+
+	"goDatabase/internal/textextract"
+)
+
+// Uploaded files are processed in the background after the upload request has
+// returned. Tasks that do not fit in the queue are dropped; a reindex picks
+// them up again.
+const (
+	uploadQueueSize    = 1024
+	uploadQueueWorkers = 2
+)
+
+type uploadTask struct {
+	bucketName  string
+	key         string
+	contentType string
+}
+
+// startUploadPipeline starts the workers that process uploaded files.
+func (s *Server) startUploadPipeline() {
+	tasks := make(chan uploadTask, uploadQueueSize)
+	s.uploadTasks = tasks
+	for i := 0; i < uploadQueueWorkers; i++ {
+		go func() {
+			for task := range tasks {
+				s.processUpload(task)
+			}
+		}()
+	}
+}
+
+// DisableUploadPipeline stops background processing of uploaded files, so
+// every write comes from the request that caused it. Call it before serving
+// requests; tests use it to check what a request stored.
+func (s *Server) DisableUploadPipeline() {
+	if s.uploadTasks != nil {
+		close(s.uploadTasks)
+		s.uploadTasks = nil
+	}
+}
+
+// queueUploadProcessing schedules background work for a newly stored object.
+func (s *Server) queueUploadProcessing(bucketName, key, contentType string) {
+	if s.uploadTasks == nil {
+		return
+	}
+	select {
+	case s.uploadTasks <- uploadTask{bucketName: bucketName, key: key, contentType: contentType}:
+	default:
+		log.Printf("Upload queue full, not processing %s/%s", bucketName, key)
+	}
+}
+
+func (s *Server) processUpload(task uploadTask) {
+	// Text and thumbnails of vault files would be readable without the vault
+	// key, so there are none
+	if s.inUserVault(task.bucketName, task.key) {
+		return
+	}
+	s.indexFileContent(task)
+	s.updateThumbnails(task)
+}
+
+// indexFileContent extracts the text of a stored file into the full-text index.
+// The index keeps the text in plaintext, so nothing is indexed while files are
+// encrypted.
+func (s *Server) indexFileContent(task uploadTask) {
+	userID, err := userIDFromBucket(task.bucketName)
+	if err != nil {
+		log.Printf("Error indexing content of %s: %v", task.key, err)
+		return
+	}
+
+	if s.masterKeys != nil || !textextract.Supported(task.key, task.contentType) {
+		// An overwrite may have replaced an indexed document
+		if err := s.db.ClearFileContent(userID, task.key); err != nil {
+			log.Printf("Error clearing content of %s: %v", task.key, err)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	object, objInfo, err := s.getObject(ctx, task.bucketName, task.key)
+	if err != nil {
+		log.Printf("Error getting %s for indexing: %v", task.key, err)
+		return
+	}
+	defer object.Close()
+	if objInfo.Size > textextract.MaxFileSize {
+		return
+	}
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		log.Printf("Error reading %s for indexing: %v", task.key, err)
+		return
+	}
+
+	text, err := textextract.Extract(task.key, task.contentType, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		log.Printf("Could not extract text from %s: %v", task.key, err)
+		return
+	}
+
+	if err := s.db.SetFileContent(userID, task.key, text); err != nil {
+		log.Printf("Error indexing content of %s: %v", task.key, err)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// How long a presigned download URL stays valid
+	presignExpiry = time.Hour
+	// Largest number of URLs minted in one request
+	maxPresignPaths = 200
+)
+
+// downloadURL is the path of the download endpoint for key.
+func downloadURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/api/downloadFile/" + strings.Join(segments, "/")
+}
+
+// presignHandler mints presigned download URLs for files, so listings do not
+// have to sign a URL for every object up front. GET takes a single "path"
+// parameter, POST a JSON list of paths. With file encryption enabled the URLs
+// point at the download endpoint instead, which decrypts.
+func (s *Server) presignHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	var paths []string
+	if c.Request.Method == http.MethodGet {
+		paths = []string{c.Query("path")}
+	} else {
+		var req struct {
+			Paths []string `json:"paths"`
+		}
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		paths = req.Paths
+	}
+
+	if len(paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files selected"})
+		return
+	}
+	if len(paths) > maxPresignPaths {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many files selected"})
+		return
+	}
+
+	if !s.checkVault(c, bucketName, paths...) {
+		return
+	}
+
+	ctx := context.Background()
+	urls := make(map[string]string, len(paths))
+	for _, p := range paths {
+		key := strings.Trim(p, "/")
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File path cannot be empty"})
+			return
+		}
+
+		// Encrypted files must go through the server to be decrypted
+		if s.masterKeys != nil {
+			urls[key] = downloadURL(key)
+			continue
+		}
+
+		// Signing is local once minio-go has cached the bucket region
+		u, err := s.minioClient.PresignedGetObject(ctx, bucketName, key, presignExpiry, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download URL", "details": err.Error()})
+			return
+		}
+		urls[key] = u.String()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"urls":      urls,
+		"expiresAt": time.Now().Add(presignExpiry),
+	})
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+const maxItemNameLength = 255
+
+// validateItemName checks that name can be used as a single file or folder
+// name inside a bucket.
+func validateItemName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name cannot be empty")
+	}
+	if name == "." || name == ".." {
+		return errors.New("name cannot be . or ..")
+	}
+	if len(name) > maxItemNameLength {
+		return fmt.Errorf("name cannot be longer than %d bytes", maxItemNameLength)
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return errors.New("name cannot contain slashes")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return errors.New("name cannot contain control characters")
+		}
+	}
+	return nil
+}
+
+// parentPath returns the folder containing key, or "" for the bucket root.
+func parentPath(key string) string {
+	parent := filepath.ToSlash(filepath.Dir(key))
+	if parent == "." || parent == "/" {
+		return ""
+	}
+	return parent
+}
+
+// objectExists reports whether an object with exactly this key exists.
+func (s *Server) objectExists(ctx context.Context, bucketName, key string) (bool, error) {
+	_, err := s.minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// prefixExists reports whether at least one object is stored under prefix.
+func (s *Server) prefixExists(ctx context.Context, bucketName, prefix string) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+		MaxKeys:   1,
+	}) {
+		if object.Err != nil {
+			return false, object.Err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// itemExists reports whether either a file or a folder is stored at itemPath.
+func (s *Server) itemExists(ctx context.Context, bucketName, itemPath string) (bool, error) {
+	exists, err := s.objectExists(ctx, bucketName, itemPath)
+	if err != nil || exists {
+		return exists, err
+	}
+	return s.prefixExists(ctx, bucketName, itemPath+"/")
+}
+
+// isNotFound reports whether err is MinIO saying the object or its bucket
+// does not exist.
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NoSuchBucket"
+}
+
+// listPrefix returns every object stored under prefix.
+func (s *Server) listPrefix(ctx context.Context, bucketName, prefix string) ([]minio.ObjectInfo, error) {
+	var objects []minio.ObjectInfo
+	for object := range s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// copyPrefix copies every object under srcPrefix to the same relative key
+// under dstPrefix. Folder markers keep their trailing slash. If a copy fails,
+// the objects copied so far are removed again and the error is returned.
+func (s *Server) copyPrefix(ctx context.Context, bucketName, srcPrefix, dstPrefix string) ([]string, error) {
+	objects, err := s.listPrefix(ctx, bucketName, srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return s.copyObjects(ctx, bucketName, objects, srcPrefix, dstPrefix, nil)
+}
+
+// copyObjects copies the listed objects from srcPrefix to dstPrefix, calling
+// progress after each one. On failure the copies made so far are removed.
+func (s *Server) copyObjects(ctx context.Context, bucketName string, objects []minio.ObjectInfo, srcPrefix, dstPrefix string, progress func()) ([]string, error) {
+	copied := make([]string, 0, len(objects))
+
+	for _, object := range objects {
+		destObjectName := dstPrefix + strings.TrimPrefix(object.Key, srcPrefix)
+
+		src := minio.CopySrcOptions{
+			Bucket: bucketName,
+			Object: object.Key,
+		}
+		dst := minio.CopyDestOptions{
+			Bucket: bucketName,
+			Object: destObjectName,
+		}
+
+		if _, err := s.minioClient.CopyObject(ctx, dst, src); err != nil {
+			s.removeKeys(ctx, bucketName, copied)
+			return nil, fmt.Errorf("copying %s to %s: %v", object.Key, destObjectName, err)
+		}
+		copied = append(copied, destObjectName)
+
+		if progress != nil {
+			progress()
+		}
+	}
+
+	return copied, nil
+}
+
+// removeKeys deletes the given objects, logging any failures.
+func (s *Server) removeKeys(ctx context.Context, bucketName string, keys []string) {
+	for key, err := range s.removeObjects(ctx, bucketName, keys) {
+		log.Printf("Error deleting object %s: %v", key, err)
+	}
+}
+
+func (s *Server) renameHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		Path    string `json:"path"`    // Current path of the file or folder
+		NewName string `json:"newName"` // New base name, without any folders
+		Type    string `json:"type"`    // "file" or "folder"
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	sourcePath := strings.Trim(req.Path, "/")
+	if sourcePath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path cannot be empty"})
+		return
+	}
+
+	if err := validateItemName(req.NewName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid name", "details": err.Error()})
+		return
+	}
+
+	newPath := joinKey(parentPath(sourcePath), req.NewName)
+
+	if newPath == sourcePath {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New name is the same as the current name"})
+		return
+	}
+	if !s.checkVaultMove(c, bucketName, sourcePath, newPath) {
+		return
+	}
+
+	ctx := context.Background()
+
+	// Refuse to overwrite anything that already uses the new name
+	exists, err := s.itemExists(ctx, bucketName, newPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check destination", "details": err.Error()})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "An item with that name already exists"})
+		return
+	}
+
+	switch req.Type {
+	case "file":
+		exists, err := s.objectExists(ctx, bucketName, sourcePath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check file", "details": err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		src := minio.CopySrcOptions{
+			Bucket: bucketName,
+			Object: sourcePath,
+		}
+		dst := minio.CopyDestOptions{
+			Bucket: bucketName,
+			Object: newPath,
+		}
+		if _, err := s.minioClient.CopyObject(ctx, dst, src); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename file"})
+			return
+		}
+
+		if err := s.minioClient.RemoveObject(ctx, bucketName, sourcePath, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Error deleting source file %s: %v", sourcePath, err)
+		}
+
+	case "folder":
+		srcPrefix := sourcePath + "/"
+		exists, err := s.prefixExists(ctx, bucketName, srcPrefix)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check folder", "details": err.Error()})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		// Copy everything, including the folder marker, before removing the source
+		if _, err := s.copyPrefix(ctx, bucketName, srcPrefix, newPath+"/"); err != nil {
+			log.Printf("Error renaming folder %s: %v", sourcePath, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename folder"})
+			return
+		}
+
+		if err := s.deleteObjects(ctx, bucketName, srcPrefix); err != nil {
+			log.Printf("Error deleting source folder %s: %v", srcPrefix, err)
+		}
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+		return
+	}
+
+	s.reindexMovedItem(bucketName, sourcePath, newPath, req.Type)
+	if req.Type == "file" {
+		s.recordActivity(bucketName, newPath, "rename")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Item renamed successfully",
+		"newPath": newPath,
+	})
+}
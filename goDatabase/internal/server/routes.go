@@ -1,14 +1,12 @@
 package server
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,6 +16,7 @@ import (
 	"time"
 
 	"goDatabase/internal/auth"
+	"goDatabase/internal/contenttype"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -31,7 +30,7 @@ func (s *Server) RegisterRoutes() *gin.Engine {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:8000", "http://localhost:4269"}, // Add both domains
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Share-Password"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
@@ -50,20 +49,76 @@ func (s *Server) RegisterRoutes() *gin.Engine {
 
 	r.POST("/api/uploadFile", s.uploadFileHandler)
 	r.GET("/api/check-image", s.checkImageHandler)
-	r.POST("/api/encrypt", s.encryptHandler)
-	r.POST("/api/decrypt", s.decryptHandler)
+	r.GET("/api/encryption", s.encryptionStatusHandler)
+	r.POST("/api/encryption/rotate", s.rotateEncryptionHandler)
+	r.GET("/api/vault", s.vaultStatusHandler)
+	r.POST("/api/vault", s.createVaultHandler)
+	r.POST("/api/vault/unlock", s.unlockVaultHandler)
+	r.POST("/api/vault/lock", s.lockVaultHandler)
 	r.GET("/api/downloadFile/*path", s.downloadFileHandler)
 
 	r.GET("/api/listBucket", s.listBucket)
+	r.GET("/api/presign", s.presignHandler)
+	r.POST("/api/presign", s.presignHandler)
+	r.GET("/api/thumbnail/*path", s.thumbnailHandler)
+	r.GET("/api/search", s.searchHandler)
+	r.GET("/api/search/content", s.contentSearchHandler)
+	r.POST("/api/reindex", s.reindexHandler)
+
+	r.GET("/api/tags", s.listTagsHandler)
+	r.GET("/api/tags/files", s.fileTagsHandler)
+	r.POST("/api/tags/add", s.addTagsHandler)
+	r.POST("/api/tags/remove", s.removeTagsHandler)
+
+	r.POST("/api/star", s.starHandler)
+	r.DELETE("/api/star", s.starHandler)
+	r.GET("/api/starred", s.starredHandler)
+	r.GET("/api/recent", s.recentHandler)
 
 	r.POST("/api/deleteFile", s.deleteFileHandler)
 
 	r.POST("/api/createFolder", s.createFolderHandler)
 
 	r.GET("/api/downloadFolderAsZip/:path", s.downloadFolderAsZip)
+	r.GET("/api/downloadZip", s.zipDownloadHandler)
+	r.POST("/api/extract", s.extractHandler)
+	r.GET("/api/archive/list", s.archiveListHandler)
+	r.GET("/api/archive/entry", s.archiveEntryHandler)
 
 	// **Add the new endpoint for moving files/folders**
 	r.POST("/api/moveFile", s.moveFileHandler)
+	r.POST("/api/rename", s.renameHandler)
+	r.POST("/api/copy", s.copyHandler)
+	r.GET("/api/jobs/:id", s.jobStatusHandler)
+
+	r.POST("/api/shares", s.createShareHandler)
+	r.GET("/api/shares", s.listSharesHandler)
+	r.DELETE("/api/shares/:token", s.revokeShareHandler)
+	r.GET("/api/shares/:token/access", s.shareAccessHandler)
+
+	r.POST("/api/access", s.grantAccessHandler)
+	r.DELETE("/api/access", s.revokeAccessHandler)
+	r.GET("/api/access", s.listGrantsHandler)
+	r.GET("/api/sharedWithMe", s.sharedWithMeHandler)
+
+	r.POST("/api/fileRequests", s.createFileRequestHandler)
+	r.GET("/api/fileRequests", s.listFileRequestsHandler)
+	r.DELETE("/api/fileRequests/:token", s.revokeFileRequestHandler)
+
+	r.GET("/api/notifications", s.listNotificationsHandler)
+	r.POST("/api/notifications/read", s.markNotificationsReadHandler)
+
+	// Public share links, no session needed
+	r.GET("/s/:token", s.publicShareHandler)
+	r.POST("/s/:token", s.publicShareHandler)
+
+	// Public file request links, upload only
+	r.GET("/r/:token", s.fileRequestInfoHandler)
+	r.POST("/r/:token", s.fileRequestUploadHandler)
+
+	r.POST("/api/batch/delete", s.batchDeleteHandler)
+	r.POST("/api/batch/move", s.batchMoveHandler)
+	r.POST("/api/batch/download", s.batchDownloadHandler)
 
 	r.GET("/api/bucket-stats", s.getBucketStats)
 
@@ -88,6 +143,65 @@ func (s *Server) getBucketNameByEmail(userEmail string) (string, error) {
 	return bucketName, nil
 }
 
+// getSessionUser resolves the internal ID and bucket of the logged-in user.
+// When it returns false an error response has already been written.
+func (s *Server) getSessionUser(c *gin.Context) (int, string, bool) {
+	session, err := auth.Store.Get(c.Request, auth.SessionName)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return 0, "", false
+	}
+
+	userEmail, ok := session.Values["user_email"].(string)
+	if !ok || userEmail == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not logged in"})
+		return 0, "", false
+	}
+
+	internalUserID, err := s.db.GetUserIDByEmail(userEmail)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting bucket name"})
+		return 0, "", false
+	}
+	return internalUserID, fmt.Sprintf("user-%d", internalUserID), true
+}
+
+// getSessionBucket resolves the bucket of the logged-in user. When it returns
+// false an error response has already been written.
+func (s *Server) getSessionBucket(c *gin.Context) (string, bool) {
+	_, bucketName, ok := s.getSessionUser(c)
+	return bucketName, ok
+}
+
+// bucketSize returns the total size in bytes of every object in the bucket.
+func (s *Server) bucketSize(ctx context.Context, bucketName string) int64 {
+	return s.prefixSize(ctx, bucketName, "")
+}
+
+// prefixSize returns the total size in bytes of the objects under prefix.
+// Encrypted objects count with the size of their plaintext.
+func (s *Server) prefixSize(ctx context.Context, bucketName, prefix string) int64 {
+	var totalSize int64 = 0
+
+	objectCh := s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    true,
+		WithMetadata: true,
+	})
+
+	for object := range objectCh {
+		if object.Err != nil {
+			continue
+		}
+		// Re-encrypted copies replace an object that is already counted
+		if strings.HasPrefix(object.Key, reencryptPrefix) {
+			continue
+		}
+		totalSize += plainSize(object.Size, object.UserMetadata["X-Amz-Meta-"+encryptionMeta])
+	}
+	return totalSize
+}
+
 func (s *Server) HelloWorldHandler(c *gin.Context) {
 	resp := make(map[string]string)
 	resp["message"] = "Hello World"
@@ -187,6 +301,9 @@ func (s *Server) logoutHandler(c *gin.Context) {
 		return
 	}
 
+	// Logging out locks the vault
+	s.lockSessionVault(c)
+
 	session.Values = make(map[interface{}]interface{})
 	session.Options.MaxAge = -1
 	session.Options.SameSite = http.SameSiteNoneMode
@@ -343,20 +460,7 @@ func (s *Server) getBucketStats(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	var totalSize int64 = 0
-
-	// List all objects in the bucket
-	objectCh := s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Recursive: true,
-	})
-
-	for object := range objectCh {
-		if object.Err != nil {
-			continue
-		}
-		totalSize += object.Size
-	}
+	totalSize := s.bucketSize(context.Background(), bucketName)
 
 	// Convert to MB for frontend display
 	usedStorageMB := float64(totalSize) / 1024 / 1024
@@ -385,13 +489,6 @@ func (s *Server) uploadFileHandler(c *gin.Context) {
 		return
 	}
 
-	// Get bucket name
-	bucketName, err := s.getBucketNameByEmail(userEmail)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting bucket name", "details": err.Error()})
-		return
-	}
-
 	// Parse multipart form with a larger memory limit (32MB)
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form", "details": err.Error()})
@@ -408,68 +505,147 @@ func (s *Server) uploadFileHandler(c *gin.Context) {
 		currentPath += "/"
 	}
 
-	files := form.File["files"]
+	// Own bucket, or a folder another user shared as editor ("owner" is
+	// their user ID). Uploads count against the owner's storage.
+	owner, ok := s.resolveOwner(c, c.Request.FormValue("owner"), roleEditor, currentPath)
+	if !ok {
+		return
+	}
+	bucketName := owner.bucketName
 
-	// Get current bucket size
-	ctx := context.Background()
-	var currentSize int64 = 0
-	objectCh := s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Recursive: true,
-	})
+	files := form.File["files"]
 
-	for object := range objectCh {
-		if object.Err != nil {
-			continue
+	// Folder uploads send the relative path of each file ("photos/2024/a.jpg")
+	// as a "relativePaths" value, in the same order as the files
+	relativePaths := form.Value["relativePaths"]
+	if len(relativePaths) > 0 && len(relativePaths) != len(files) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected one relative path per file"})
+		return
+	}
+	fileNames := make([]string, len(files))
+	for i, fileHeader := range files {
+		name := fileHeader.Filename
+		if len(relativePaths) > 0 && relativePaths[i] != "" {
+			name = relativePaths[i]
+		}
+		cleaned, err := cleanRelativePath(name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path", "details": err.Error()})
+			return
+		}
+		if len(currentPath)+len(cleaned) > maxObjectKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File path too long", "details": cleaned})
+			return
 		}
-		currentSize += object.Size
+		fileNames[i] = cleaned
 	}
 
-	// Calculate total upload size
+	// Decide what happens when an uploaded name is already taken
+	conflictPolicy, filePolicies, err := parseConflictPolicies(c.Request.FormValue("conflict"), c.Request.FormValue("conflicts"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conflict policy", "details": err.Error()})
+		return
+	}
+
+	// Both lists hold object keys: where a file was stored, or where it was
+	// meant to go. Skipped files count as not uploaded; "conflicts" says why.
+	uploadedFiles := make([]string, 0)
+	failedFiles := make([]string, 0)
+	conflicts := make([]uploadConflict, 0)
+
+	// Settle name clashes first, so folders and quota only account for the
+	// files that are actually written
+	type plannedUpload struct {
+		index      int
+		objectName string
+	}
+	var planned []plannedUpload
+	taken := make(map[string]bool)
 	var totalUploadSize int64 = 0
-	for _, fileHeader := range files {
+	for i, fileHeader := range files {
+		objectName := currentPath + fileNames[i]
+
+		policy, ok := filePolicies[fileNames[i]]
+		if !ok {
+			policy = conflictPolicy
+		}
+
+		targetName, action, err := s.resolveUploadConflict(context.Background(), bucketName, objectName, policy, taken)
+		if err != nil {
+			log.Printf("Failed to check for existing file %s: %v", objectName, err)
+			failedFiles = append(failedFiles, objectName)
+			continue
+		}
+		if action != "" {
+			conflictPath := objectName
+			if action == "renamed" {
+				conflictPath = targetName
+			}
+			conflicts = append(conflicts, uploadConflict{File: fileNames[i], Path: conflictPath, Action: action})
+		}
+		if action == "failed" || action == "skipped" {
+			failedFiles = append(failedFiles, objectName)
+			continue
+		}
+
 		totalUploadSize += fileHeader.Size
+		if action == "replaced" {
+			// The replaced file no longer counts against the quota
+			if info, err := s.minioClient.StatObject(context.Background(), bucketName, targetName, minio.StatObjectOptions{}); err == nil {
+				totalUploadSize -= info.Size
+			}
+		}
+		planned = append(planned, plannedUpload{index: i, objectName: targetName})
+		taken[targetName] = true
 	}
 
 	// Check if upload would exceed limit
-	if currentSize+totalUploadSize > STORAGE_LIMIT_BYTES {
+	currentSize := s.bucketSize(context.Background(), bucketName)
+	if totalUploadSize > 0 && currentSize+totalUploadSize > STORAGE_LIMIT_BYTES {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Upload would exceed storage limit of 100MB",
 		})
 		return
 	}
 
-	uploadedFiles := make([]string, 0)
-	failedFiles := make([]string, 0)
+	// Create the folders leading up to the files, like createFolderHandler
+	objectNames := make([]string, len(planned))
+	for i, p := range planned {
+		objectNames[i] = p.objectName
+	}
+	createdFolders, err := s.createUploadFolders(context.Background(), bucketName, currentPath, objectNames)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folders", "details": err.Error()})
+		return
+	}
+
+	for _, p := range planned {
+		fileHeader := files[p.index]
+		objectName := p.objectName
 
-	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
-			failedFiles = append(failedFiles, fileHeader.Filename)
+			failedFiles = append(failedFiles, objectName)
 			continue
 		}
-		defer file.Close()
 
 		fileBytes, err := io.ReadAll(file)
+		file.Close()
 		if err != nil {
-			failedFiles = append(failedFiles, fileHeader.Filename)
+			failedFiles = append(failedFiles, objectName)
 			continue
 		}
 
-		// Construct the object name with the current path
-		objectName := filepath.Join(currentPath, fileHeader.Filename)
-		// Convert to forward slashes for MinIO
-		objectName = filepath.ToSlash(objectName)
-
 		// Upload the file to MinIO
 		reader := bytes.NewReader(fileBytes)
 		objectSize := int64(len(fileBytes))
 		contentType := fileHeader.Header.Get("Content-Type")
-		if contentType == "" {
-			contentType = "application/octet-stream"
+		if contenttype.NeedsSniffing(contentType) {
+			contentType = contenttype.ByName(fileNames[p.index])
 		}
 
-		_, err = s.minioClient.PutObject(
-			context.Background(),
+		_, err = s.putObject(
+			c.Request.Context(),
 			bucketName,
 			objectName,
 			reader,
@@ -479,10 +655,15 @@ func (s *Server) uploadFileHandler(c *gin.Context) {
 
 		if err != nil {
 			log.Printf("Failed to upload file %s: %v", objectName, err)
-			failedFiles = append(failedFiles, fileHeader.Filename)
+			failedFiles = append(failedFiles, objectName)
 		} else {
 			log.Printf("Successfully uploaded file: %s", objectName)
 			uploadedFiles = append(uploadedFiles, objectName)
+			s.indexFile(bucketName, objectName, contentType, objectSize, time.Now())
+			if !owner.shared {
+				s.recordActivity(bucketName, objectName, "upload")
+			}
+			s.queueUploadProcessing(bucketName, objectName, contentType)
 		}
 	}
 
@@ -496,6 +677,18 @@ func (s *Server) uploadFileHandler(c *gin.Context) {
 		response["total_failed"] = len(failedFiles)
 	}
 
+	if len(conflicts) > 0 {
+		response["conflicts"] = conflicts
+	}
+
+	response["created_folders"] = createdFolders
+	response["tree"] = buildUploadTree(currentPath, uploadedFiles, createdFolders)
+
+	// Uploads sent with extract=true unpack any archives among the files
+	if c.Request.FormValue("extract") == "true" {
+		response["extract_jobs"] = s.extractUploads(c.Request.Context(), bucketName, uploadedFiles, conflictPolicy)
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
@@ -541,57 +734,16 @@ func (s *Server) downloadFolderAsZip(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder path"})
 		return
 	}
-	folderPath = filepath.Clean(folderPath)
-
-	zipFile, err := ioutil.TempFile("", "*.zip")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temporary file"})
+	folderPath = strings.Trim(filepath.Clean("/"+folderPath), "/")
+	if folderPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder path"})
 		return
 	}
-	defer zipFile.Close()
-	defer os.Remove(zipFile.Name())
-
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	// List all objects in the folder
-	ctx := context.Background()
-	objectsCh := s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix:    folderPath + "/",
-		Recursive: true,
-	})
-
-	for object := range objectsCh {
-		if object.Err != nil {
-			log.Printf("Error listing objects: %v", object.Err)
-			continue
-		}
-
-		objectReader, err := s.minioClient.GetObject(ctx, bucketName, object.Key, minio.GetObjectOptions{})
-		if err != nil {
-			log.Printf("Error getting object: %v", err)
-			continue
-		}
-		defer objectReader.Close()
-
-		zipEntry, err := zipWriter.Create(object.Key)
-		if err != nil {
-			log.Printf("Error creating zip entry: %v", err)
-			continue
-		}
-
-		_, err = io.Copy(zipEntry, objectReader)
-		if err != nil {
-			log.Printf("Error copying object to zip: %v", err)
-		}
+	if !s.checkVault(c, bucketName, folderPath) {
+		return
 	}
 
-	zipWriter.Close()
-
-	c.Header("Content-Disposition", "attachment; filename=\"folder.zip\"")
-	c.Header("Content-Type", "application/zip")
-	zipFile.Seek(0, 0)
-	io.Copy(c.Writer, zipFile)
+	s.streamZip(c, bucketName, []batchItem{{Path: folderPath, Type: "folder"}})
 }
 
 func (s *Server) userCookieInfo(c *gin.Context) {
@@ -674,7 +826,7 @@ func (s *Server) uploadHandler(c *gin.Context) {
 	objectSize := int64(len(fileBytes))
 	contentType := header.Header.Get("Content-Type")
 
-	_, err = s.minioClient.PutObject(context.Background(), bucketName, fileName, reader, objectSize, minio.PutObjectOptions{ContentType: contentType})
+	_, err = s.putObject(context.Background(), bucketName, fileName, reader, objectSize, minio.PutObjectOptions{ContentType: contentType})
 	if err != nil {
 		log.Printf("Error uploading file to MinIO: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error uploading file"})
@@ -704,54 +856,7 @@ func (s *Server) checkImageHandler(c *gin.Context) {
 	}
 }
 
-func (s *Server) encryptHandler(c *gin.Context) {
-	log.Println("Received encrypt request")
-
-	cmd := exec.Command("python3", "./pythonFacialRec/encrypt.py")
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("Error running encrypt.py: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Encryption process completed successfully"})
-}
-
-func (s *Server) decryptHandler(c *gin.Context) {
-	log.Println("Received decrypt request")
-
-	cmd := exec.Command("python3", "./pythonFacialRec/decrypt.py")
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("Error running decrypt.py: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Decryption failed"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Decryption process completed successfully"})
-}
-
 func (s *Server) downloadFileHandler(c *gin.Context) {
-	session, err := auth.Store.Get(c.Request, auth.SessionName)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-		return
-	}
-
-	userEmail, ok := session.Values["user_email"].(string)
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
-
-	// Get bucket name
-	bucketName, err := s.db.GetBucketNameByEmail(userEmail)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting bucket name", "details": err.Error()})
-		return
-	}
-
 	// Get file path from URL parameter
 	filePath := c.Param("path")
 
@@ -762,54 +867,83 @@ func (s *Server) downloadFileHandler(c *gin.Context) {
 		return
 	}
 
-	// Clean the object name to prevent path traversal
-	objectName = filepath.Clean(objectName)
+	// Clean the object name to prevent path traversal; keys have no leading slash
+	objectName = strings.TrimPrefix(filepath.Clean("/"+objectName), "/")
 
-	// Get object from MinIO
-	object, err := s.minioClient.GetObject(context.Background(), bucketName, objectName, minio.GetObjectOptions{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file", "details": err.Error()})
+	// Own file, or one another user shared ("owner" is their user ID)
+	owner, ok := s.resolveOwner(c, c.Query("owner"), roleViewer, objectName)
+	if !ok {
 		return
 	}
-	defer object.Close()
+	bucketName := owner.bucketName
 
-	// Get object info to set headers
-	objectInfo, err := object.Stat()
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	object, objectInfo, ok := s.openObject(c, bucketName, objectName)
+	if !ok {
 		return
 	}
+	defer object.Close()
 
-	// Set the headers for streaming the file
-	contentType := "application/octet-stream"
-	if filepath.Ext(objectName) == ".pdf" {
-		contentType = "application/pdf"
-	}
-
-	c.Header("Content-Type", contentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", objectInfo.Size))
+	s.serveObject(c, object, objectInfo, objectName)
 
-	// Stream the file to response
-	if _, err := io.Copy(c.Writer, object); err != nil {
-		log.Printf("Error streaming file: %v", err)
+	// Count whole downloads and the first request of a seek/resume, not every
+	// range or revalidation. Downloads of shared files are not the owner's
+	// activity.
+	status := c.Writer.Status()
+	if !owner.shared && (status == http.StatusOK || (status == http.StatusPartialContent && strings.HasPrefix(c.GetHeader("Range"), "bytes=0-"))) {
+		s.recordActivity(bucketName, objectName, "download")
 	}
 }
 
-func (s *Server) listBucket(c *gin.Context) {
-	// Get the session
-	session, err := auth.Store.Get(c.Request, auth.SessionName)
+// openObject opens an object for serving, decrypting it if needed. Reads are
+// lazy, so seeking to a range only fetches that part of the object. When it
+// returns false an error response has already been written.
+func (s *Server) openObject(c *gin.Context, bucketName, objectName string) (storedObject, minio.ObjectInfo, bool) {
+	object, objectInfo, err := s.getObject(c.Request.Context(), bucketName, objectName)
+	if isNotFound(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return nil, minio.ObjectInfo{}, false
+	}
+	if err == errVaultLocked {
+		c.JSON(http.StatusLocked, gin.H{"error": "Vault is locked", "details": "Verify your face to unlock it"})
+		return nil, minio.ObjectInfo{}, false
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
-		return
+		// Storage is unavailable or the object cannot be decrypted
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file", "details": err.Error()})
+		return nil, minio.ObjectInfo{}, false
 	}
+	return object, objectInfo, true
+}
 
-	// Get userEmail from session
-	userEmail, ok := session.Values["user_email"].(string)
-	if !ok || userEmail == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not logged in"})
-		return
+// serveObject writes an opened object as a download named after objectName.
+func (s *Server) serveObject(c *gin.Context, object storedObject, objectInfo minio.ObjectInfo, objectName string) {
+	// Use the stored type, sniffing the first bytes if it is generic
+	contentType := objectInfo.ContentType
+	if contenttype.NeedsSniffing(contentType) {
+		head := make([]byte, contenttype.SniffLen)
+		n, _ := io.ReadFull(object, head)
+		if _, err := object.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file", "details": err.Error()})
+			return
+		}
+		contentType = contenttype.Resolve(objectName, contentType, head[:n])
 	}
 
+	// Only safe types are shown inline; "?download=1" always downloads
+	inline := c.Query("download") == ""
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", contenttype.Disposition(contentType, objectName, inline))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("ETag", `"`+objectInfo.ETag+`"`)
+	c.Header("Accept-Ranges", "bytes")
+
+	// ServeContent handles Range, If-Range, If-None-Match and
+	// If-Modified-Since, and sets Content-Length
+	http.ServeContent(c.Writer, c.Request, "", objectInfo.LastModified, object)
+}
+
+func (s *Server) listBucket(c *gin.Context) {
 	// Get and clean the path
 	currentPath := strings.TrimSpace(c.Query("path"))
 	currentPath = strings.Trim(currentPath, "/")
@@ -817,12 +951,20 @@ func (s *Server) listBucket(c *gin.Context) {
 		currentPath += "/"
 	}
 
-	// Get bucket name
-	bucketName, err := s.getBucketNameByEmail(userEmail)
+	// Paging, order and tag filter
+	folderPath := strings.TrimSuffix(currentPath, "/")
+	opts, err := parseListOptions(c, folderPath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting bucket name"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid listing parameters", "details": err.Error()})
+		return
+	}
+
+	// Own bucket, or a folder another user shared ("owner" is their user ID)
+	owner, ok := s.resolveOwner(c, c.Query("owner"), roleViewer, folderPath)
+	if !ok {
 		return
 	}
+	bucketName, userID := owner.bucketName, owner.userID
 
 	ctx := context.Background()
 
@@ -838,107 +980,49 @@ func (s *Server) listBucket(c *gin.Context) {
 		return
 	}
 
-	// List all objects with the prefix
-	objectCh := s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix:    currentPath,
-		Recursive: false,
-	})
-
-	var objects []map[string]interface{}
-	seenFolders := make(map[string]bool)
-
-	for object := range objectCh {
-		if object.Err != nil {
-			log.Printf("Error listing object: %v", object.Err)
-			continue
-		}
-
-		name := strings.TrimPrefix(object.Key, currentPath)
-		if name == "" || object.Key == currentPath {
-			continue
-		}
-
-		// Handle folders
-		if strings.Contains(name, "/") {
-			folderName := strings.Split(name, "/")[0]
-			if !seenFolders[folderName] {
-				seenFolders[folderName] = true
-				objects = append(objects, map[string]interface{}{
-					"name":         folderName,
-					"lastModified": object.LastModified,
-					"size":         0,
-					"type":         "folder",
-					"contentType":  "folder",
-					"path":         filepath.Join(currentPath, folderName),
-				})
-			}
-			continue
-		}
+	// Buckets filled before the index existed are indexed on first use
+	if err := s.ensureIndexed(ctx, bucketName); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error indexing bucket", "details": err.Error()})
+		return
+	}
 
-		// Improved content type detection for files
-		var contentType string
-		ext := strings.ToLower(filepath.Ext(name))
-
-		// Map common extensions to MIME types
-		switch ext {
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		case ".gif":
-			contentType = "image/gif"
-		case ".pdf":
-			contentType = "application/pdf"
-		case ".doc", ".docx":
-			contentType = "application/msword"
-		case ".xls", ".xlsx":
-			contentType = "application/vnd.ms-excel"
-		case ".txt":
-			contentType = "text/plain"
-		case ".mp3":
-			contentType = "audio/mpeg"
-		case ".mp4":
-			contentType = "video/mp4"
-		case ".zip":
-			contentType = "application/zip"
-		default:
-			// Try to detect content type, fallback to octet-stream
-			if mimeType := mime.TypeByExtension(ext); mimeType != "" {
-				contentType = mimeType
-			} else {
-				contentType = "application/octet-stream"
-			}
-		}
+	// List one page of the folder from the metadata index
+	folders, files, next, err := s.db.ListFolder(userID, folderPath, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing folder", "details": err.Error()})
+		return
+	}
 
-		// Get object info to ensure we have content type
-		objInfo, err := s.minioClient.StatObject(ctx, bucketName, object.Key, minio.StatObjectOptions{})
-		if err == nil && objInfo.ContentType != "" {
-			contentType = objInfo.ContentType
-		}
+	objects := make([]map[string]interface{}, 0, len(folders)+len(files))
 
-		// Create presigned URL for direct access
-		url, err := s.minioClient.PresignedGetObject(ctx, bucketName, object.Key, time.Hour, nil)
-		var urlString string
-		if err == nil {
-			urlString = url.String()
-		}
+	for _, folder := range folders {
+		objects = append(objects, map[string]interface{}{
+			"name":         folder.FolderName,
+			"lastModified": folder.CreationDate,
+			"size":         0,
+			"type":         "folder",
+			"contentType":  "folder",
+			"path":         folder.FolderPath,
+		})
+	}
 
+	// Download URLs are minted on demand through /api/presign
+	for _, file := range files {
 		objects = append(objects, map[string]interface{}{
-			"name":         name,
-			"lastModified": object.LastModified,
-			"size":         object.Size,
+			"name":         file.FileName,
+			"lastModified": file.LastModified,
+			"size":         file.FileSize,
 			"type":         "file",
-			"contentType":  contentType,
-			"url":          urlString,
-			"path":         object.Key,
+			"contentType":  file.FileType,
+			"path":         file.ObjectKey,
+			"tags":         file.Tags,
 		})
-
-		log.Printf("Added file: %s with content type: %s", name, contentType)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"files":       objects,
 		"currentPath": currentPath,
+		"nextToken":   nextListToken(folderPath, opts, next),
 	})
 }
 
@@ -972,6 +1056,9 @@ func (s *Server) deleteFileHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting bucket name"})
 		return
 	}
+	if !s.checkVault(c, bucketName, req.Path) {
+		return
+	}
 
 	ctx := context.Background()
 
@@ -1001,19 +1088,10 @@ func (s *Server) deleteFileHandler(c *gin.Context) {
 			return
 		}
 
-		// Delete all objects
-		for _, objectKey := range objects {
-			err := s.minioClient.RemoveObject(ctx, bucketName, objectKey, minio.RemoveObjectOptions{})
-			if err != nil {
-				log.Printf("Error deleting object %s: %v", objectKey, err)
-			}
-		}
-
-		// Also delete the folder marker if it exists
+		// Delete all objects, including the folder marker, in bulk
 		folderMarker := strings.TrimSuffix(req.Path, "/") + "/"
-		err = s.minioClient.RemoveObject(ctx, bucketName, folderMarker, minio.RemoveObjectOptions{})
-		if err != nil {
-			log.Printf("Error deleting folder marker: %v", err)
+		for key, err := range s.removeObjects(ctx, bucketName, append(objects, folderMarker)) {
+			log.Printf("Error deleting object %s: %v", key, err)
 		}
 
 	} else {
@@ -1027,6 +1105,8 @@ func (s *Server) deleteFileHandler(c *gin.Context) {
 		}
 	}
 
+	s.unindexItem(bucketName, req.Path, req.Type)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted successfully"})
 }
 
@@ -1074,17 +1154,12 @@ func (s *Server) createFolderHandler(c *gin.Context) {
 
 	// Convert Windows-style paths to forward slashes
 	folderPath = filepath.ToSlash(folderPath)
+	if !s.checkVault(c, bucketName, folderPath) {
+		return
+	}
 
 	// Create an empty object with the folder name (this is how MinIO handles folders)
-	_, err = s.minioClient.PutObject(
-		context.Background(),
-		bucketName,
-		folderPath,
-		bytes.NewReader([]byte{}),
-		0,
-		minio.PutObjectOptions{ContentType: "application/x-directory"},
-	)
-	if err != nil {
+	if err := s.createFolderMarker(context.Background(), bucketName, folderPath); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
 		return
 	}
@@ -1115,84 +1190,103 @@ func (s *Server) moveFileHandler(c *gin.Context) {
 	var req struct {
 		SourcePath      string `json:"sourcePath"`
 		DestinationPath string `json:"destinationPath"`
-		Type            string `json:"type"` // "file" or "folder"
+		Type            string `json:"type"`  // "file" or "folder"
+		Owner           string `json:"owner"` // User ID of whoever shared the item, "" for own files
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	// Get bucket name
-	bucketName, err := s.getBucketNameByEmail(userEmail)
+	// Moving another user's items needs editor rights on both ends
+	owner, ok := s.resolveOwner(c, req.Owner, roleEditor, req.SourcePath, req.DestinationPath)
+	if !ok {
+		return
+	}
+	bucketName := owner.bucketName
+
+	if strings.Trim(req.SourcePath, "/") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source path cannot be empty"})
+		return
+	}
+	if req.Type != "file" && req.Type != "folder" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+		return
+	}
+
+	newPath, err := s.moveItem(context.Background(), bucketName, req.SourcePath, req.DestinationPath, req.Type)
+	if err == errMoveIntoItself {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot move a folder into itself"})
+		return
+	}
+	if err == errVaultBoundary {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Items cannot be moved into or out of the vault"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting bucket name"})
+		log.Printf("Error moving %s: %v", req.SourcePath, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item", "details": err.Error()})
 		return
 	}
 
-	ctx := context.Background()
+	if req.Type == "file" && !owner.shared {
+		s.recordActivity(bucketName, newPath, "move")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item moved successfully", "newPath": newPath})
+}
+
+var errMoveIntoItself = errors.New("cannot move a folder into itself")
 
+// moveItem moves a file or folder into destinationPath, keeping its name, and
+// returns its new path.
+func (s *Server) moveItem(ctx context.Context, bucketName, sourcePath, destinationPath, itemType string) (string, error) {
 	// Clean and prepare paths
-	sourcePath := strings.Trim(req.SourcePath, "/")
-	destinationPath := strings.Trim(req.DestinationPath, "/")
+	sourcePath = strings.Trim(sourcePath, "/")
+	destinationPath = strings.Trim(destinationPath, "/")
 
 	if sourcePath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Source path cannot be empty"})
-		return
+		return "", fmt.Errorf("source path cannot be empty")
+	}
+
+	newPath := joinKey(destinationPath, filepath.Base(sourcePath))
+	if err := s.moveItemTo(ctx, bucketName, sourcePath, newPath, itemType); err != nil {
+		return "", err
 	}
+	return newPath, nil
+}
 
-	// For root destination, destinationPath will be empty
-	if destinationPath != "" {
-		destinationPath += "/"
+// moveItemTo moves a file or folder to newPath. Whatever is stored at newPath
+// already is replaced, so callers check for clashes first.
+func (s *Server) moveItemTo(ctx context.Context, bucketName, sourcePath, newPath, itemType string) error {
+	if err := s.checkVaultBoundary(bucketName, sourcePath, newPath); err != nil {
+		return err
 	}
 
-	if req.Type == "folder" {
+	switch itemType {
+	case "folder":
 		// Move folder by copying all objects under the source folder to the destination folder
 		srcPrefix := sourcePath + "/"
-		objectsCh := s.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-			Prefix:    srcPrefix,
-			Recursive: true,
-		})
-
-		for object := range objectsCh {
-			if object.Err != nil {
-				log.Printf("Error listing objects: %v", object.Err)
-				continue
-			}
-
-			// Prepare the destination object name
-			relativePath := strings.TrimPrefix(object.Key, srcPrefix)
-			destObjectName := filepath.Join(destinationPath, sourcePath, relativePath)
-			destObjectName = filepath.ToSlash(destObjectName)
-
-			// Copy the object
-			src := minio.CopySrcOptions{
-				Bucket: bucketName,
-				Object: object.Key,
-			}
-			dst := minio.CopyDestOptions{
-				Bucket: bucketName,
-				Object: destObjectName,
-			}
+		if strings.HasPrefix(parentPath(newPath)+"/", srcPrefix) {
+			return errMoveIntoItself
+		}
+		if newPath == sourcePath {
+			return nil
+		}
 
-			_, err := s.minioClient.CopyObject(ctx, dst, src)
-			if err != nil {
-				log.Printf("Error copying object %s to %s: %v", object.Key, destObjectName, err)
-				continue
-			}
+		if _, err := s.copyPrefix(ctx, bucketName, srcPrefix, newPath+"/"); err != nil {
+			return err
 		}
 
 		// Delete the source folder and its contents
-		err = s.deleteObjects(ctx, bucketName, srcPrefix)
-		if err != nil {
+		if err := s.deleteObjects(ctx, bucketName, srcPrefix); err != nil {
 			log.Printf("Error deleting source folder %s: %v", srcPrefix, err)
 		}
 
-	} else if req.Type == "file" {
-		// Move a single file
-		// Prepare the destination object name
-		fileName := filepath.Base(sourcePath)
-		destObjectName := filepath.Join(destinationPath, fileName)
-		destObjectName = filepath.ToSlash(destObjectName)
+	case "file":
+		if newPath == sourcePath {
+			return nil
+		}
 
 		// Copy the object
 		src := minio.CopySrcOptions{
@@ -1201,26 +1295,46 @@ func (s *Server) moveFileHandler(c *gin.Context) {
 		}
 		dst := minio.CopyDestOptions{
 			Bucket: bucketName,
-			Object: destObjectName,
+			Object: newPath,
 		}
 
-		_, err := s.minioClient.CopyObject(ctx, dst, src)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move file"})
-			return
+		if _, err := s.minioClient.CopyObject(ctx, dst, src); err != nil {
+			return fmt.Errorf("failed to move file: %v", err)
 		}
 
 		// Delete the source object
-		err = s.minioClient.RemoveObject(ctx, bucketName, sourcePath, minio.RemoveObjectOptions{})
-		if err != nil {
+		if err := s.minioClient.RemoveObject(ctx, bucketName, sourcePath, minio.RemoveObjectOptions{}); err != nil {
 			log.Printf("Error deleting source file %s: %v", sourcePath, err)
 		}
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
-		return
+
+	default:
+		return fmt.Errorf("invalid type %q", itemType)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Item moved successfully"})
+	s.reindexMovedItem(bucketName, sourcePath, newPath, itemType)
+
+	return nil
+}
+
+// removeObjects deletes keys in bulk with RemoveObjects and returns the
+// failures keyed by object name.
+func (s *Server) removeObjects(ctx context.Context, bucketName string, keys []string) map[string]error {
+	objectsCh := make(chan minio.ObjectInfo)
+
+	go func() {
+		defer close(objectsCh)
+		for _, key := range keys {
+			objectsCh <- minio.ObjectInfo{Key: key}
+		}
+	}()
+
+	failed := make(map[string]error)
+	for err := range s.minioClient.RemoveObjects(ctx, bucketName, objectsCh, minio.RemoveObjectsOptions{}) {
+		if err.Err != nil {
+			failed[err.ObjectName] = err.Err
+		}
+	}
+	return failed
 }
 
 // **Helper function to delete multiple objects**
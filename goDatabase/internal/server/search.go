@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// parseSearchTime accepts RFC 3339 timestamps or plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseSearchTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parsePage reads the 1-based "page" and the "pageSize" query parameters.
+func parsePage(c *gin.Context) (int, int, error) {
+	page, pageSize := 1, defaultPageSize
+
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q", v)
+		}
+		page = n
+	}
+	if v := c.Query("pageSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid pageSize %q", v)
+		}
+		if n > maxPageSize {
+			n = maxPageSize
+		}
+		pageSize = n
+	}
+	return page, pageSize, nil
+}
+
+// parseFileSearch builds the index filters from the query string.
+func parseFileSearch(c *gin.Context) (database.FileSearch, error) {
+	search := database.FileSearch{
+		Name:        strings.TrimSpace(c.Query("q")),
+		ContentType: strings.ToLower(strings.TrimSpace(c.Query("type"))),
+	}
+
+	// "image" is shorthand for every image/* type
+	if search.ContentType != "" && !strings.Contains(search.ContentType, "/") {
+		search.ContentType += "/"
+	}
+
+	var err error
+	if v := c.Query("minSize"); v != "" {
+		if search.MinSize, err = strconv.ParseInt(v, 10, 64); err != nil || search.MinSize < 0 {
+			return search, fmt.Errorf("invalid minSize %q", v)
+		}
+	}
+	if v := c.Query("maxSize"); v != "" {
+		if search.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil || search.MaxSize < 0 {
+			return search, fmt.Errorf("invalid maxSize %q", v)
+		}
+	}
+	if v := c.Query("modifiedAfter"); v != "" {
+		if search.ModifiedAfter, err = parseSearchTime(v, false); err != nil {
+			return search, err
+		}
+	}
+	if v := c.Query("modifiedBefore"); v != "" {
+		if search.ModifiedBefore, err = parseSearchTime(v, true); err != nil {
+			return search, err
+		}
+	}
+
+	if folder := strings.Trim(c.Query("folder"), "/"); folder != "" {
+		search.Folder = folder + "/"
+	}
+
+	if search.Tags, err = parseTagQuery(c); err != nil {
+		return search, err
+	}
+
+	page, pageSize, err := parsePage(c)
+	if err != nil {
+		return search, err
+	}
+	search.Limit = pageSize
+	search.Offset = (page - 1) * pageSize
+
+	return search, nil
+}
+
+func (s *Server) searchHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	search, err := parseFileSearch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid search", "details": err.Error()})
+		return
+	}
+	if s.vaultLocked(c, userID) {
+		search.ExcludeFolder = vaultFolder + "/"
+	}
+
+	files, total, err := s.db.SearchFiles(userID, search)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":  files,
+		"total":    total,
+		"page":     search.Offset/search.Limit + 1,
+		"pageSize": search.Limit,
+	})
+}
+
+func (s *Server) contentSearchHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	if s.masterKeys != nil {
+		// The index would hold the text of encrypted files in plaintext
+		c.JSON(http.StatusConflict, gin.H{"error": "Content search is not available while files are encrypted"})
+		return
+	}
+
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search text cannot be empty"})
+		return
+	}
+
+	folder := strings.Trim(c.Query("folder"), "/")
+	if folder != "" {
+		folder += "/"
+	}
+
+	page, pageSize, err := parsePage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid search", "details": err.Error()})
+		return
+	}
+
+	matches, total, err := s.db.SearchFileContent(userID, text, folder, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":  matches,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
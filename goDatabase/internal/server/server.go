@@ -6,11 +6,13 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
 	"goDatabase/internal/database"
+	"goDatabase/internal/kms"
 
 	"github.com/minio/minio-go/v7"                 // MinIO SDK import
 	"github.com/minio/minio-go/v7/pkg/credentials" // MinIO credentials import
@@ -22,9 +24,42 @@ type Server struct {
 	db database.Service
 
 	minioClient *minio.Client // Added MinIO client to Server struct
+
+	jobs *jobStore // Progress of long running operations
+
+	uploadTasks chan uploadTask // Background processing of uploaded files
+
+	thumbnailBucketReady atomic.Bool // Sidecar thumbnail bucket has been created
+
+	staleIndexes staleBuckets // Buckets whose index missed an update
+
+	masterKeys kms.KMS      // Wraps data keys for file encryption, nil when disabled
+	dataKeys   dataKeyCache // Unwrapped per-user data keys
+
+	faceSecret []byte     // Shared with the face recognition service, nil when vaults are disabled
+	vaults     vaultStore // Vault keys of unlocked sessions
+
+	sharePasswords sharePasswordLimiter // Wrong passwords per share link and client
 }
 
-func NewServer() *http.Server {
+// New creates a Server on top of an existing database service and MinIO
+// client and starts its background workers.
+func New(db database.Service, minioClient *minio.Client) *Server {
+	s := &Server{
+		db: db,
+
+		minioClient: minioClient,
+
+		jobs: newJobStore(),
+	}
+
+	s.startUploadPipeline()
+	return s
+}
+
+// NewServer configures a Server from the environment. Files are encrypted
+// with data keys wrapped by masterKeys, or stored unencrypted if it is nil.
+func NewServer(masterKeys kms.KMS) *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
 
 	// Initialize MinIO client
@@ -40,12 +75,20 @@ func NewServer() *http.Server {
 		log.Fatalf("Failed to initialize MinIO client: %v", err)
 	}
 
-	NewServer := &Server{
-		port: port,
+	NewServer := New(database.New(), minioClient)
+	NewServer.port = port
 
-		db: database.New(),
+	// Files are encrypted at rest once master keys are configured
+	if masterKeys != nil {
+		NewServer.EnableEncryption(masterKeys)
+	} else {
+		log.Println("No master keys configured, files are stored unencrypted")
+	}
 
-		minioClient: minioClient, // Assign MinIO client to Server struct
+	// Vaults are unlocked with face verifications signed by the face
+	// recognition service
+	if secret := os.Getenv("FACE_VERIFICATION_SECRET"); secret != "" {
+		NewServer.EnableFaceVerification([]byte(secret))
 	}
 
 	// Declare Server config
@@ -0,0 +1,478 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Random bytes in a share token, before base64 encoding
+	shareTokenBytes = 24
+	// bcrypt ignores everything past 72 bytes, so longer passwords are refused
+	maxSharePasswordLength = 72
+	// Most access log entries returned at once
+	maxShareAccessLimit = 500
+	// Wrong passwords a link accepts from one client within
+	// sharePasswordWindow before it refuses to check more of theirs
+	maxSharePasswordFailures = 5
+	// Wrong passwords a link accepts from all clients together, which caps
+	// guessing spread over many addresses
+	maxShareLinkPasswordFailures = 50
+	sharePasswordWindow          = 15 * time.Minute
+)
+
+// Outcomes recorded in the share access log.
+const (
+	shareDownload         = "download"
+	sharePasswordRequired = "password_required"
+	shareWrongPassword    = "wrong_password"
+	shareThrottled        = "throttled"
+	shareExpired          = "expired"
+	shareLimitReached     = "limit_reached"
+	shareRevoked          = "revoked"
+	shareMissing          = "missing"
+)
+
+// bucketForUser returns the bucket that holds a user's files.
+func bucketForUser(userID int) string {
+	return "user-" + strconv.Itoa(userID)
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// shareResponse adds the public URL path to a share link.
+func shareResponse(link database.ShareLink) gin.H {
+	return gin.H{"link": link, "url": "/s/" + link.Token}
+}
+
+// createShareHandler creates a public link to a file or folder. The link can
+// be protected with a password, expire at a given time and allow a limited
+// number of downloads.
+func (s *Server) createShareHandler(c *gin.Context) {
+	userID, bucketName, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path         string     `json:"path"`
+		Type         string     `json:"type"` // "file" or "folder"
+		Password     string     `json:"password"`
+		ExpiresAt    *time.Time `json:"expiresAt"`
+		ExpiresIn    int        `json:"expiresIn"` // seconds, used when expiresAt is not set
+		MaxDownloads int        `json:"maxDownloads"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	itemPath := strings.Trim(filepath.ToSlash(filepath.Clean("/"+req.Path)), "/")
+	if itemPath == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path cannot be empty"})
+		return
+	}
+	if req.MaxDownloads < 0 || req.ExpiresIn < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits cannot be negative"})
+		return
+	}
+	if len(req.Password) > maxSharePasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is too long"})
+		return
+	}
+	if !s.checkVaultNotShared(c, userID, itemPath) {
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if expiresAt == nil && req.ExpiresIn > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var exists bool
+	var err error
+	switch req.Type {
+	case "file":
+		exists, err = s.objectExists(ctx, bucketName, itemPath)
+	case "folder":
+		exists, err = s.prefixExists(ctx, bucketName, itemPath+"/")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check item", "details": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link", "details": err.Error()})
+		return
+	}
+
+	link := database.ShareLink{
+		Token:        token,
+		UserID:       userID,
+		ItemPath:     itemPath,
+		ItemType:     req.Type,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	link, err = s.db.CreateShareLink(link)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, shareResponse(link))
+}
+
+// listSharesHandler lists the user's share links, or those for ?path=.
+func (s *Server) listSharesHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	itemPath := strings.Trim(c.Query("path"), "/")
+	links, err := s.db.ListShareLinks(userID, itemPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list share links", "details": err.Error()})
+		return
+	}
+
+	shares := make([]gin.H, len(links))
+	for i, link := range links {
+		shares[i] = shareResponse(link)
+	}
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
+
+// revokeShareHandler stops a share link from working. The link and its
+// access log are kept.
+func (s *Server) revokeShareHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	err := s.db.RevokeShareLink(userID, c.Param("token"))
+	if errors.Is(err, database.ErrShareNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// shareAccessHandler returns the most recent uses of a share link.
+func (s *Server) shareAccessHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxShareAccessLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	accesses, err := s.db.ListShareAccess(userID, c.Param("token"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list share access", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"access": accesses})
+}
+
+// publicShareHandler serves a share link to anyone who has it: the file
+// itself, or a zip of a folder. Passwords are sent as a "password" form value
+// or an X-Share-Password header, never in the URL. Every access is logged.
+func (s *Server) publicShareHandler(c *gin.Context) {
+	// The token is the credential; keep it out of caches and referrers
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+
+	link, err := s.db.GetShareLink(c.Param("token"))
+	if errors.Is(err, database.ErrShareNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get share link"})
+		return
+	}
+
+	logAccess := func(outcome string) {
+		if err := s.db.LogShareAccess(link.LinkID, c.ClientIP(), c.Request.UserAgent(), outcome); err != nil {
+			log.Printf("Error logging access to share link %d: %v", link.LinkID, err)
+		}
+	}
+
+	switch {
+	case link.Revoked:
+		// Revoked links look like they never existed
+		logAccess(shareRevoked)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	case link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()):
+		logAccess(shareExpired)
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has expired"})
+		return
+	case link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads:
+		logAccess(shareLimitReached)
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has no downloads left"})
+		return
+	}
+
+	if link.HasPassword {
+		password := c.PostForm("password")
+		if password == "" {
+			password = c.GetHeader("X-Share-Password")
+		}
+		if password == "" {
+			logAccess(sharePasswordRequired)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Password required"})
+			return
+		}
+		if until := s.sharePasswords.blockedUntil(link.LinkID, c.ClientIP(), time.Now()); !until.IsZero() {
+			logAccess(shareThrottled)
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong passwords", "details": "Try again later"})
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			s.sharePasswords.fail(link.LinkID, c.ClientIP(), time.Now())
+			logAccess(shareWrongPassword)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong password"})
+			return
+		}
+	}
+
+	bucketName := bucketForUser(link.UserID)
+	ctx := c.Request.Context()
+
+	if link.ItemType == "folder" {
+		exists, err := s.prefixExists(ctx, bucketName, link.ItemPath+"/")
+		if err != nil || !exists {
+			logAccess(shareMissing)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Shared folder not found"})
+			return
+		}
+		w := s.claimOnDownload(c, link, logAccess)
+		s.streamZip(c, bucketName, []batchItem{{Path: link.ItemPath, Type: "folder"}})
+		if w.claimed {
+			logAccess(shareDownload)
+		}
+		return
+	}
+
+	object, objectInfo, ok := s.openObject(c, bucketName, link.ItemPath)
+	if !ok {
+		logAccess(shareMissing)
+		return
+	}
+	defer object.Close()
+
+	// Every download counts, so partial requests are answered with the whole
+	// file rather than letting ranges be fetched uncounted
+	c.Request.Header.Del("Range")
+	c.Request.Header.Del("If-Range")
+	w := s.claimOnDownload(c, link, logAccess)
+	s.serveObject(c, object, objectInfo, link.ItemPath)
+	if w.claimed {
+		logAccess(shareDownload)
+	}
+}
+
+// claimOnDownload makes the response count a download against the link once
+// it starts sending content (200 or 206). Answers such as 304 Not Modified or
+// errors do not use up a limited link. If the link ran out since it was looked
+// up, the content is replaced by an error response.
+func (s *Server) claimOnDownload(c *gin.Context, link database.ShareLink, logAccess func(string)) *claimingWriter {
+	w := &claimingWriter{ResponseWriter: c.Writer}
+	w.claim = func() bool {
+		claimed, err := s.db.ClaimShareDownload(link.LinkID)
+		if err == nil && claimed {
+			return true
+		}
+
+		// Drop what was set up for the content and answer on the real writer
+		c.Writer = w.ResponseWriter
+		for _, h := range []string{"Content-Length", "Content-Disposition", "Content-Range", "ETag", "Last-Modified", "Accept-Ranges"} {
+			c.Writer.Header().Del(h)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get share link"})
+		} else {
+			logAccess(shareLimitReached)
+			c.JSON(http.StatusGone, gin.H{"error": "Share link has no downloads left"})
+		}
+		return false
+	}
+	c.Writer = w
+	return w
+}
+
+// errDownloadRefused stops writing content the link has no downloads left for.
+var errDownloadRefused = errors.New("share download refused")
+
+// claimingWriter claims a share download when the status of the response is
+// decided, see claimOnDownload.
+type claimingWriter struct {
+	gin.ResponseWriter
+	claim   func() bool
+	decided bool
+	claimed bool
+	refused bool
+}
+
+// decide claims the download the first time the status is known to be code,
+// and reports whether content may be written.
+func (w *claimingWriter) decide(code int) bool {
+	if !w.decided {
+		w.decided = true
+		if code == http.StatusOK || code == http.StatusPartialContent {
+			w.claimed = w.claim()
+			w.refused = !w.claimed
+		}
+	}
+	return !w.refused
+}
+
+func (w *claimingWriter) WriteHeader(code int) {
+	if w.decide(code) {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *claimingWriter) WriteHeaderNow() {
+	if w.decide(w.ResponseWriter.Status()) {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *claimingWriter) Write(p []byte) (int, error) {
+	if !w.decide(w.ResponseWriter.Status()) {
+		return 0, errDownloadRefused
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *claimingWriter) WriteString(s string) (int, error) {
+	if !w.decide(w.ResponseWriter.Status()) {
+		return 0, errDownloadRefused
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// sharePasswordLimiter counts wrong passwords per share link and client, so
+// a password cannot be guessed at the rate bcrypt allows. Clients are counted
+// apart so one of them cannot lock everyone else out of a link.
+type sharePasswordLimiter struct {
+	mu       sync.Mutex
+	failures map[sharePasswordKey][]time.Time // wrong passwords within the window
+}
+
+// sharePasswordKey identifies the failures of one client on a link. An empty
+// client IP counts the failures of all clients.
+type sharePasswordKey struct {
+	linkID   int
+	clientIP string
+}
+
+// recent drops failures older than the window. The caller holds the lock.
+func (l *sharePasswordLimiter) recent(key sharePasswordKey, now time.Time) []time.Time {
+	failures := l.failures[key]
+	for len(failures) > 0 && now.Sub(failures[0]) >= sharePasswordWindow {
+		failures = failures[1:]
+	}
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = failures
+	return failures
+}
+
+// blockedUntil returns when the link accepts passwords from the client again,
+// or the zero time if it does now.
+func (l *sharePasswordLimiter) blockedUntil(linkID int, clientIP string, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var until time.Time
+	for key, max := range map[sharePasswordKey]int{
+		{linkID, clientIP}: maxSharePasswordFailures,
+		{linkID, ""}:       maxShareLinkPasswordFailures,
+	} {
+		failures := l.recent(key, now)
+		if len(failures) < max {
+			continue
+		}
+		if t := failures[len(failures)-max].Add(sharePasswordWindow); t.After(until) {
+			until = t
+		}
+	}
+	return until
+}
+
+// fail records a wrong password from the client for the link.
+func (l *sharePasswordLimiter) fail(linkID int, clientIP string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures == nil {
+		l.failures = make(map[sharePasswordKey][]time.Time)
+	}
+	for key := range l.failures {
+		l.recent(key, now)
+	}
+	for _, key := range []sharePasswordKey{{linkID, clientIP}, {linkID, ""}} {
+		l.failures[key] = append(l.failures[key], now)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"goDatabase/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRecentLimit = 20
+	maxRecentLimit     = 100
+)
+
+// recordActivity notes that a file was uploaded, downloaded, moved or renamed
+// for the recent-activity view. Like index updates it is best effort.
+func (s *Server) recordActivity(bucketName, key, action string) {
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		log.Printf("Error recording %s of %s: %v", action, key, err)
+		return
+	}
+	if err := s.db.RecordActivity(userID, key, action); err != nil {
+		log.Printf("Error recording %s of %s: %v", action, key, err)
+	}
+}
+
+// starHandler stars (POST) or unstars (DELETE) a file or folder.
+func (s *Server) starHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+		Type string `json:"type"` // "file" or "folder"
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	req.Path = strings.Trim(req.Path, "/")
+	if req.Path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path cannot be empty"})
+		return
+	}
+	if req.Type != "file" && req.Type != "folder" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type"})
+		return
+	}
+	if !s.checkVault(c, bucketForUser(userID), req.Path) {
+		return
+	}
+
+	var err error
+	if c.Request.Method == http.MethodDelete {
+		err = s.db.UnstarItem(userID, req.Path, req.Type == "folder")
+	} else {
+		err = s.db.StarItem(userID, req.Path, req.Type == "folder")
+	}
+	if err == database.ErrNotIndexed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating star", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":    req.Path,
+		"type":    req.Type,
+		"starred": c.Request.Method != http.MethodDelete,
+	})
+}
+
+func (s *Server) starredHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	items, err := s.db.ListStarred(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing starred items", "details": err.Error()})
+		return
+	}
+	if s.vaultLocked(c, userID) {
+		items = slices.DeleteFunc(items, func(item database.StarredItem) bool { return inVault(item.Path) })
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// recentHandler returns the files the user most recently uploaded,
+// downloaded, moved or renamed.
+func (s *Server) recentHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	limit := defaultRecentLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(n, maxRecentLimit)
+	}
+
+	files, err := s.db.RecentFiles(userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing recent files", "details": err.Error()})
+		return
+	}
+	if s.vaultLocked(c, userID) {
+		files = slices.DeleteFunc(files, func(f database.RecentFile) bool { return inVault(f.ObjectKey) })
+	}
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxTagLength   = 64
+	maxTagsPerCall = 20
+)
+
+// normalizeTags lower-cases, trims and de-duplicates tag names and rejects
+// names that are empty, too long or contain commas or control characters.
+func normalizeTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool)
+	tags := make([]string, 0, len(raw))
+
+	for _, tag := range raw {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tag cannot be empty")
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if strings.ContainsFunc(tag, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+			return nil, fmt.Errorf("tag %q contains invalid characters", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTagsPerCall {
+		return nil, fmt.Errorf("too many tags")
+	}
+	return tags, nil
+}
+
+// parseTagQuery reads the tag filter of a listing or search. Tags can be
+// given as repeated "tag" parameters or comma separated.
+func parseTagQuery(c *gin.Context) ([]string, error) {
+	var raw []string
+	for _, v := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(v, ",") {
+			if strings.TrimSpace(tag) != "" {
+				raw = append(raw, tag)
+			}
+		}
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return normalizeTags(raw)
+}
+
+type tagRequest struct {
+	Paths []string `json:"paths"`
+	Tags  []string `json:"tags"`
+}
+
+// bindTagRequest parses and cleans a request to change the tags of files.
+// When it returns false an error response has already been written.
+func bindTagRequest(c *gin.Context) (tagRequest, bool) {
+	var req tagRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return req, false
+	}
+	if len(req.Paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files selected"})
+		return req, false
+	}
+	if len(req.Paths) > maxBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many files selected"})
+		return req, false
+	}
+	if len(req.Tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No tags given"})
+		return req, false
+	}
+
+	for i := range req.Paths {
+		req.Paths[i] = strings.Trim(req.Paths[i], "/")
+		if req.Paths[i] == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File path cannot be empty"})
+			return req, false
+		}
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tags", "details": err.Error()})
+		return req, false
+	}
+	req.Tags = tags
+	return req, true
+}
+
+// respondWithFileTags writes the current tags of the given files, listing
+// paths that are not indexed files separately.
+func (s *Server) respondWithFileTags(c *gin.Context, userID int, paths []string) {
+	tags, err := s.db.GetFileTags(userID, paths)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting tags", "details": err.Error()})
+		return
+	}
+
+	notFound := make([]string, 0)
+	for _, p := range paths {
+		if _, ok := tags[p]; !ok {
+			notFound = append(notFound, p)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags":     tags,
+		"notFound": notFound,
+	})
+}
+
+func (s *Server) addTagsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	req, ok := bindTagRequest(c)
+	if !ok {
+		return
+	}
+	if !s.checkVault(c, bucketForUser(userID), req.Paths...) {
+		return
+	}
+
+	if err := s.db.AddTags(userID, req.Paths, req.Tags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding tags", "details": err.Error()})
+		return
+	}
+	s.respondWithFileTags(c, userID, req.Paths)
+}
+
+func (s *Server) removeTagsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	req, ok := bindTagRequest(c)
+	if !ok {
+		return
+	}
+	if !s.checkVault(c, bucketForUser(userID), req.Paths...) {
+		return
+	}
+
+	if err := s.db.RemoveTags(userID, req.Paths, req.Tags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error removing tags", "details": err.Error()})
+		return
+	}
+	s.respondWithFileTags(c, userID, req.Paths)
+}
+
+// fileTagsHandler returns the tags of the files given as "path" parameters.
+func (s *Server) fileTagsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	paths := c.QueryArray("path")
+	if len(paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files selected"})
+		return
+	}
+	if len(paths) > maxBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many files selected"})
+		return
+	}
+	for i := range paths {
+		paths[i] = strings.Trim(paths[i], "/")
+	}
+	if !s.checkVault(c, bucketForUser(userID), paths...) {
+		return
+	}
+
+	s.respondWithFileTags(c, userID, paths)
+}
+
+// listTagsHandler returns every tag in use with the number of tagged files.
+func (s *Server) listTagsHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+
+	tags, err := s.db.ListTags(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error listing tags", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
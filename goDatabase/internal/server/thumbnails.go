@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"goDatabase/internal/thumbnail"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// Thumbnails live in a sidecar bucket so they never show up in listings or
+// count against quotas. They are keyed "<user bucket>/<size>/<object key>".
+const (
+	defaultThumbnailBucket = "thumbnails"
+	defaultThumbnailSize   = 256
+	thumbnailCacheControl  = "private, max-age=3600"
+
+	// Metadata recording the ETag of the image a thumbnail was made from
+	thumbnailSourceMeta = "Source-Etag"
+)
+
+func thumbnailBucketName() string {
+	if name := os.Getenv("THUMBNAIL_BUCKET"); name != "" {
+		return name
+	}
+	return defaultThumbnailBucket
+}
+
+func thumbnailKey(bucketName string, size int, key string) string {
+	return fmt.Sprintf("%s/%d/%s", bucketName, size, key)
+}
+
+// ensureThumbnailBucket creates the sidecar bucket the first time it is
+// needed.
+func (s *Server) ensureThumbnailBucket(ctx context.Context) error {
+	if s.thumbnailBucketReady.Load() {
+		return nil
+	}
+
+	bucket := thumbnailBucketName()
+	exists, err := s.minioClient.BucketExists(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err := s.minioClient.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})
+		if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+			return err
+		}
+	}
+
+	s.thumbnailBucketReady.Store(true)
+	return nil
+}
+
+// generateThumbnails renders and stores every thumbnail size of an image and
+// returns them keyed by size.
+func (s *Server) generateThumbnails(ctx context.Context, bucketName, key string) (map[int][]byte, error) {
+	object, info, err := s.getObject(ctx, bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	if info.Size > thumbnail.MaxFileSize {
+		return nil, fmt.Errorf("image too large for a thumbnail")
+	}
+
+	thumbs, err := thumbnail.Generate(object)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.ensureThumbnailBucket(ctx); err != nil {
+		return nil, err
+	}
+	// Thumbnails show the image, so they are encrypted like it
+	owner, err := userIDFromBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	for size, data := range thumbs {
+		_, err := s.putObjectFor(ctx, owner, thumbnailBucketName(), thumbnailKey(bucketName, size, key),
+			bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+				ContentType:  thumbnail.ContentType,
+				UserMetadata: map[string]string{thumbnailSourceMeta: info.ETag},
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return thumbs, nil
+}
+
+// updateThumbnails regenerates the thumbnails of a stored file, or drops them
+// if it was overwritten with something that is not an image.
+func (s *Server) updateThumbnails(task uploadTask) {
+	if !thumbnail.Supported(task.key, task.contentType) {
+		s.invalidateThumbnails(task.bucketName, task.key, "file")
+		return
+	}
+	if _, err := s.generateThumbnails(context.Background(), task.bucketName, task.key); err != nil {
+		log.Printf("Could not create thumbnails for %s: %v", task.key, err)
+	}
+}
+
+// invalidateThumbnails removes the thumbnails of a deleted or moved file, or
+// of every file below a folder.
+func (s *Server) invalidateThumbnails(bucketName, itemPath, itemType string) {
+	ctx := context.Background()
+	if err := s.ensureThumbnailBucket(ctx); err != nil {
+		log.Printf("Error removing thumbnails of %s: %v", itemPath, err)
+		return
+	}
+
+	itemPath = strings.Trim(itemPath, "/")
+	if itemType == "folder" {
+		for _, size := range thumbnail.Sizes {
+			s.deleteObjects(ctx, thumbnailBucketName(), thumbnailKey(bucketName, size, itemPath)+"/")
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(thumbnail.Sizes))
+	for _, size := range thumbnail.Sizes {
+		keys = append(keys, thumbnailKey(bucketName, size, itemPath))
+	}
+	for key, err := range s.removeObjects(ctx, thumbnailBucketName(), keys) {
+		log.Printf("Error removing thumbnail %s: %v", key, err)
+	}
+}
+
+// thumbnailHandler serves the thumbnail of an image, generating it on the fly
+// for images stored before thumbnails existed.
+func (s *Server) thumbnailHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	// Thumbnails of every user share one bucket, so the key must not be
+	// able to step out of the user's prefix
+	raw := strings.Trim(c.Param("path"), "/")
+	key := strings.TrimPrefix(filepath.Clean("/"+raw), "/")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File path cannot be empty"})
+		return
+	}
+	if key != raw {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path"})
+		return
+	}
+
+	// Vault files have no thumbnails, which would show them without the
+	// vault key
+	if s.inUserVault(bucketName, key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail for this file"})
+		return
+	}
+
+	size := defaultThumbnailSize
+	if v := c.Query("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid size"})
+			return
+		}
+		size = thumbnail.SizeFor(n)
+	}
+
+	ctx := c.Request.Context()
+	source, err := s.minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get thumbnail", "details": err.Error()})
+		return
+	}
+	if !thumbnail.Supported(key, source.ContentType) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail for this file"})
+		return
+	}
+
+	if err := s.ensureThumbnailBucket(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get thumbnail", "details": err.Error()})
+		return
+	}
+
+	// Serve the stored thumbnail if it was made from the current version of
+	// the image; otherwise render a new one
+	object, info, err := s.getObject(ctx, thumbnailBucketName(), thumbnailKey(bucketName, size, key))
+	if err == nil {
+		defer object.Close()
+		if info.Metadata.Get("X-Amz-Meta-"+thumbnailSourceMeta) == source.ETag {
+			c.Header("Content-Type", thumbnail.ContentType)
+			c.Header("Cache-Control", thumbnailCacheControl)
+			c.Header("ETag", `"`+info.ETag+`"`)
+			http.ServeContent(c.Writer, c.Request, "", info.LastModified, object)
+			return
+		}
+	} else if !isNotFound(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get thumbnail", "details": err.Error()})
+		return
+	}
+
+	thumbs, err := s.generateThumbnails(ctx, bucketName, key)
+	if err == thumbnail.ErrUnsupported {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No thumbnail for this file"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create thumbnail", "details": err.Error()})
+		return
+	}
+	c.Header("Content-Type", thumbnail.ContentType)
+	c.Header("Cache-Control", thumbnailCacheControl)
+	http.ServeContent(c.Writer, c.Request, "", time.Now(), bytes.NewReader(thumbs[size]))
+}
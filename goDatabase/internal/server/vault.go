@@ -0,0 +1,570 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"goDatabase/internal/auth"
+	"goDatabase/internal/database"
+	"goDatabase/internal/envelope"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A user's vault is the top level folder vaultFolder. Its files are encrypted
+// with a vault key that is only unwrapped for a session that presents a fresh
+// face verification, and is forgotten when the unlock expires.
+const (
+	vaultFolder = "Vault"
+
+	// Object metadata marking objects encrypted with the vault key
+	vaultKeyMeta = "Encryption-Key"
+	vaultKeyName = "vault"
+
+	// Session value naming the unlocked vault of a session
+	vaultSessionValue = "vault_session"
+
+	// How old a face verification may be, and how far its clock may be ahead
+	faceVerificationMaxAge = 2 * time.Minute
+	faceVerificationSkew   = 30 * time.Second
+
+	// How long a vault stays unlocked after a face verification
+	vaultUnlockDuration = 15 * time.Minute
+)
+
+var (
+	// errVaultLocked is returned when reading or writing vault files without
+	// the vault key.
+	errVaultLocked = errors.New("vault is locked")
+	// errVaultBoundary is returned when moving or copying items into or out
+	// of the vault, which would leave them under the wrong key.
+	errVaultBoundary = errors.New("items cannot be moved into or out of the vault")
+	// errVerificationUsed is returned for face verifications that already
+	// unlocked a vault.
+	errVerificationUsed = errors.New("face verification was already used")
+)
+
+// inVault reports whether an object key or path is the vault folder or lies
+// inside it.
+func inVault(key string) bool {
+	key = strings.Trim(key, "/")
+	return key == vaultFolder || strings.HasPrefix(key, vaultFolder+"/")
+}
+
+// vaultBoundary reports whether moving or copying sourcePath to newPath takes
+// an item into or out of the vault, or moves the vault folder itself.
+func vaultBoundary(sourcePath, newPath string) bool {
+	sourcePath = strings.Trim(sourcePath, "/")
+	return inVault(sourcePath) != inVault(newPath) || sourcePath == vaultFolder
+}
+
+// vaultWrapContext binds a wrapped vault key to its owner.
+func vaultWrapContext(userID int) []byte {
+	return []byte(fmt.Sprintf("user:%d:vault", userID))
+}
+
+// unlockedVault is a vault key released to one session.
+type unlockedVault struct {
+	userID    int
+	key       []byte
+	expiresAt time.Time
+}
+
+// vaultStore keeps the keys of unlocked vaults in memory only, keyed by a
+// random ID kept in the session, together with the face verifications that
+// were already used.
+type vaultStore struct {
+	mu       sync.Mutex
+	sessions map[string]unlockedVault
+	used     map[string]time.Time // verification nonce -> when it goes stale
+}
+
+// unlock releases key to a new vault session until the unlock expires. Each
+// verification nonce unlocks once.
+func (v *vaultStore) unlock(userID int, key []byte, nonce string, now time.Time) (string, time.Time, error) {
+	id := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.sessions == nil {
+		v.sessions = make(map[string]unlockedVault)
+		v.used = make(map[string]time.Time)
+	}
+	for n, stale := range v.used {
+		if now.After(stale) {
+			delete(v.used, n)
+		}
+	}
+	for sid, u := range v.sessions {
+		if now.After(u.expiresAt) {
+			delete(v.sessions, sid)
+		}
+	}
+
+	if _, ok := v.used[nonce]; ok {
+		return "", time.Time{}, errVerificationUsed
+	}
+	v.used[nonce] = now.Add(faceVerificationMaxAge + faceVerificationSkew)
+
+	sessionID := base64.RawURLEncoding.EncodeToString(id)
+	expiresAt := now.Add(vaultUnlockDuration)
+	v.sessions[sessionID] = unlockedVault{userID: userID, key: key, expiresAt: expiresAt}
+	return sessionID, expiresAt, nil
+}
+
+// get returns the unlocked vault of a session if it is still unlocked and
+// belongs to userID.
+func (v *vaultStore) get(sessionID string, userID int) (unlockedVault, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	u, ok := v.sessions[sessionID]
+	if !ok || u.userID != userID {
+		return unlockedVault{}, false
+	}
+	if time.Now().After(u.expiresAt) {
+		delete(v.sessions, sessionID)
+		return unlockedVault{}, false
+	}
+	return u, true
+}
+
+// lock forgets the key of a vault session.
+func (v *vaultStore) lock(sessionID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.sessions, sessionID)
+}
+
+type vaultKeyContextKey struct{}
+
+type vaultKey struct {
+	userID int
+	key    []byte
+}
+
+// withVaultKey carries an unlocked vault key to getObject and putObject.
+func withVaultKey(ctx context.Context, userID int, key []byte) context.Context {
+	return context.WithValue(ctx, vaultKeyContextKey{}, vaultKey{userID: userID, key: key})
+}
+
+// vaultKeyFrom returns the vault key of userID carried by ctx.
+func vaultKeyFrom(ctx context.Context, userID int) ([]byte, bool) {
+	k, ok := ctx.Value(vaultKeyContextKey{}).(vaultKey)
+	if !ok || k.userID != userID {
+		return nil, false
+	}
+	return k.key, true
+}
+
+// EnableFaceVerification lets users unlock a vault with face verifications
+// signed by the face recognition service with secret.
+func (s *Server) EnableFaceVerification(secret []byte) {
+	s.faceSecret = secret
+}
+
+// vaultsEnabled reports whether the server can keep vaults: their key is
+// wrapped by the master keys and released by face verifications.
+func (s *Server) vaultsEnabled() bool {
+	return s.masterKeys != nil && len(s.faceSecret) > 0
+}
+
+// hasVault reports whether a user has set up a vault. Until then their
+// "Vault" folder is an ordinary folder.
+func (s *Server) hasVault(userID int) (bool, error) {
+	_, err := s.db.GetVault(userID)
+	if errors.Is(err, database.ErrVaultNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// inUserVault reports whether key lies inside the vault of the bucket's owner.
+// Errors count as inside.
+func (s *Server) inUserVault(bucketName, key string) bool {
+	if !inVault(key) {
+		return false
+	}
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		return true
+	}
+	has, err := s.hasVault(userID)
+	return err != nil || has
+}
+
+// sessionVault returns the vault the request's session has unlocked, if any.
+func (s *Server) sessionVault(c *gin.Context, userID int) (string, unlockedVault, bool) {
+	session, err := auth.Store.Get(c.Request, auth.SessionName)
+	if err != nil {
+		return "", unlockedVault{}, false
+	}
+	sessionID, _ := session.Values[vaultSessionValue].(string)
+	if sessionID == "" {
+		return "", unlockedVault{}, false
+	}
+	u, ok := s.vaults.get(sessionID, userID)
+	return sessionID, u, ok
+}
+
+// vaultLocked reports whether userID has a vault that the request's session
+// has not unlocked. Errors count as locked.
+func (s *Server) vaultLocked(c *gin.Context, userID int) bool {
+	if _, _, ok := s.sessionVault(c, userID); ok {
+		return false
+	}
+	has, err := s.hasVault(userID)
+	if err != nil {
+		log.Printf("Error checking vault of user %d: %v", userID, err)
+		return true
+	}
+	return has
+}
+
+// checkVault lets a request use paths inside the vault of the bucket's owner
+// only while its session has the vault unlocked, and then hands the vault key
+// to the request's context. When it returns false an error response has
+// already been written.
+func (s *Server) checkVault(c *gin.Context, bucketName string, paths ...string) bool {
+	touchesVault := false
+	for _, p := range paths {
+		if inVault(p) {
+			touchesVault = true
+			break
+		}
+	}
+	if !touchesVault {
+		return true
+	}
+
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check vault", "details": err.Error()})
+		return false
+	}
+	if _, u, ok := s.sessionVault(c, userID); ok {
+		c.Request = c.Request.WithContext(withVaultKey(c.Request.Context(), userID, u.key))
+		return true
+	}
+
+	has, err := s.hasVault(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check vault", "details": err.Error()})
+		return false
+	}
+	if has {
+		c.JSON(http.StatusLocked, gin.H{"error": "Vault is locked", "details": "Verify your face to unlock it"})
+		return false
+	}
+	return true
+}
+
+// checkVaultBoundary returns errVaultBoundary if moving or copying sourcePath
+// to newPath would cross into or out of an existing vault.
+func (s *Server) checkVaultBoundary(bucketName, sourcePath, newPath string) error {
+	if !vaultBoundary(sourcePath, newPath) {
+		return nil
+	}
+	userID, err := userIDFromBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	has, err := s.hasVault(userID)
+	if err != nil {
+		return err
+	}
+	if has {
+		return errVaultBoundary
+	}
+	return nil
+}
+
+// checkVaultMove checks a move, copy or rename of sourcePath to newPath like
+// checkVault, and refuses it if it crosses the vault boundary. When it returns
+// false an error response has already been written.
+func (s *Server) checkVaultMove(c *gin.Context, bucketName, sourcePath, newPath string) bool {
+	if !s.checkVault(c, bucketName, sourcePath, newPath) {
+		return false
+	}
+	err := s.checkVaultBoundary(bucketName, sourcePath, newPath)
+	if err == errVaultBoundary {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Items cannot be moved into or out of the vault"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check vault", "details": err.Error()})
+		return false
+	}
+	return true
+}
+
+// checkVaultNotShared refuses to share, or request uploads into, items inside
+// an existing vault: nobody else can get its key. When it returns false an
+// error response has already been written.
+func (s *Server) checkVaultNotShared(c *gin.Context, userID int, itemPath string) bool {
+	if !inVault(itemPath) {
+		return true
+	}
+	has, err := s.hasVault(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check vault", "details": err.Error()})
+		return false
+	}
+	if has {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Items in the vault cannot be shared"})
+		return false
+	}
+	return true
+}
+
+// verifyFace checks the face verification in the request body: signed by the
+// face recognition service, for the session user, and fresh. When it returns
+// false an error response has already been written.
+func (s *Server) verifyFace(c *gin.Context, userID int) (auth.FaceVerification, bool) {
+	var req struct {
+		Verification string `json:"verification"`
+	}
+	if err := c.BindJSON(&req); err != nil || req.Verification == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A face verification is required"})
+		return auth.FaceVerification{}, false
+	}
+
+	v, err := auth.ParseFaceVerification(req.Verification, s.faceSecret)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid face verification"})
+		return auth.FaceVerification{}, false
+	}
+	if v.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Face verification is for another user"})
+		return auth.FaceVerification{}, false
+	}
+	age := time.Since(v.IssuedAt)
+	if age > faceVerificationMaxAge || age < -faceVerificationSkew {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Face verification has expired", "details": "Scan your face again"})
+		return auth.FaceVerification{}, false
+	}
+	return v, true
+}
+
+// unlockVault releases key to the session for vaultUnlockDuration and
+// responds with the vault status.
+func (s *Server) unlockVault(c *gin.Context, userID int, key []byte, v auth.FaceVerification) {
+	session, err := auth.Store.Get(c.Request, auth.SessionName)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	sessionID, expiresAt, err := s.vaults.unlock(userID, key, v.Nonce, time.Now())
+	if errors.Is(err, errVerificationUsed) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Face verification was already used", "details": "Scan your face again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock vault", "details": err.Error()})
+		return
+	}
+
+	if old, ok := session.Values[vaultSessionValue].(string); ok {
+		s.vaults.lock(old)
+	}
+	session.Values[vaultSessionValue] = sessionID
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		s.vaults.lock(sessionID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save session", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"unlocked":  true,
+		"expiresAt": expiresAt,
+		"folder":    vaultFolder,
+	})
+}
+
+// vaultStatusHandler tells whether the user has a vault and until when the
+// session has it unlocked.
+func (s *Server) vaultStatusHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	if !s.vaultsEnabled() {
+		c.JSON(http.StatusOK, gin.H{"available": false, "enabled": false, "unlocked": false})
+		return
+	}
+
+	has, err := s.hasVault(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get vault", "details": err.Error()})
+		return
+	}
+	response := gin.H{"available": true, "enabled": has, "unlocked": false, "folder": vaultFolder}
+	if _, u, ok := s.sessionVault(c, userID); ok && has {
+		response["unlocked"] = true
+		response["expiresAt"] = u.expiresAt
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// createVaultHandler sets up the user's vault and unlocks it. It needs a face
+// verification like unlocking does, and an empty or missing "Vault" folder,
+// since files already there are not encrypted with the vault key.
+func (s *Server) createVaultHandler(c *gin.Context) {
+	userID, bucketName, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	if !s.vaultsEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vaults need file encryption and face verification to be enabled on this server"})
+		return
+	}
+	v, ok := s.verifyFace(c, userID)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	objects, err := s.listPrefix(ctx, bucketName, vaultFolder+"/")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check vault folder", "details": err.Error()})
+		return
+	}
+	for _, object := range objects {
+		if object.Key != vaultFolder+"/" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Your Vault folder already has files", "details": "Move them out of it first"})
+			return
+		}
+	}
+
+	key, err := envelope.NewDataKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vault", "details": err.Error()})
+		return
+	}
+	masterKeyID, wrapped, err := s.masterKeys.Wrap(ctx, key, vaultWrapContext(userID))
+	if err == nil {
+		err = s.db.CreateVault(userID, wrapped, masterKeyID)
+	}
+	if errors.Is(err, database.ErrVaultExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have a vault"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vault", "details": err.Error()})
+		return
+	}
+
+	if len(objects) == 0 {
+		if err := s.createFolderMarker(ctx, bucketName, vaultFolder+"/"); err != nil {
+			log.Printf("Error creating vault folder of user %d: %v", userID, err)
+		}
+	}
+	s.unlockVault(c, userID, key, v)
+}
+
+// unlockVaultHandler unwraps the vault key for the session after a fresh face
+// verification.
+func (s *Server) unlockVaultHandler(c *gin.Context) {
+	userID, _, ok := s.getSessionUser(c)
+	if !ok {
+		return
+	}
+	if !s.vaultsEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vaults need file encryption and face verification to be enabled on this server"})
+		return
+	}
+	v, ok := s.verifyFace(c, userID)
+	if !ok {
+		return
+	}
+
+	vault, err := s.db.GetVault(userID)
+	if errors.Is(err, database.ErrVaultNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You do not have a vault"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get vault", "details": err.Error()})
+		return
+	}
+	key, err := s.masterKeys.Unwrap(c.Request.Context(), vault.MasterKeyID, vault.WrappedKey, vaultWrapContext(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock vault", "details": err.Error()})
+		return
+	}
+	s.unlockVault(c, userID, key, v)
+}
+
+// lockVaultHandler forgets the vault key of the session before the unlock
+// expires.
+func (s *Server) lockVaultHandler(c *gin.Context) {
+	if _, _, ok := s.getSessionUser(c); !ok {
+		return
+	}
+	s.lockSessionVault(c)
+	c.JSON(http.StatusOK, gin.H{"unlocked": false})
+}
+
+// lockSessionVault forgets the vault key of the request's session, if any.
+func (s *Server) lockSessionVault(c *gin.Context) {
+	session, err := auth.Store.Get(c.Request, auth.SessionName)
+	if err != nil {
+		return
+	}
+	if sessionID, ok := session.Values[vaultSessionValue].(string); ok {
+		s.vaults.lock(sessionID)
+	}
+}
+
+// rewrapVaults wraps every vault key still wrapped by an older master key
+// with the current one, like rewrapDataKeys.
+func (s *Server) rewrapVaults(ctx context.Context, current string) {
+	rewrapped := 0
+	for {
+		vaults, err := s.db.VaultsNotWrappedBy(current, rewrapBatchSize)
+		if err != nil {
+			log.Printf("Error listing vault keys to rewrap: %v", err)
+			return
+		}
+		progress := 0
+		for _, v := range vaults {
+			aad := vaultWrapContext(v.UserID)
+			key, err := s.masterKeys.Unwrap(ctx, v.MasterKeyID, v.WrappedKey, aad)
+			if err != nil {
+				log.Printf("Error unwrapping vault key of user %d: %v", v.UserID, err)
+				continue
+			}
+			keyID, wrapped, err := s.masterKeys.Wrap(ctx, key, aad)
+			if err == nil {
+				err = s.db.RewrapVault(v.UserID, wrapped, keyID)
+			}
+			if err != nil {
+				log.Printf("Error rewrapping vault key of user %d: %v", v.UserID, err)
+				continue
+			}
+			progress++
+		}
+		rewrapped += progress
+		if len(vaults) < rewrapBatchSize || progress == 0 {
+			break
+		}
+	}
+	if rewrapped > 0 {
+		log.Printf("Rewrapped %d vault keys with master key %s", rewrapped, current)
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"goDatabase/internal/contenttype"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// zipRoot returns the deepest folder containing every item, with a trailing
+// slash, or "" for the bucket root.
+func zipRoot(items []batchItem) string {
+	root := parentPath(items[0].Path)
+	for _, item := range items[1:] {
+		parent := parentPath(item.Path)
+		for root != "" && parent != root && !strings.HasPrefix(parent, root+"/") {
+			root = parentPath(root)
+		}
+	}
+	if root == "" {
+		return ""
+	}
+	return root + "/"
+}
+
+// zipName picks a download name for an archive of items: the item itself
+// when there is one, otherwise the folder they share.
+func zipName(items []batchItem) string {
+	if len(items) == 1 {
+		return path.Base(items[0].Path) + ".zip"
+	}
+	if root := strings.TrimSuffix(zipRoot(items), "/"); root != "" {
+		return path.Base(root) + ".zip"
+	}
+	return "files.zip"
+}
+
+// writeZip streams a zip archive of the given items to w. Entries are named
+// relative to the deepest folder containing the whole selection, so selecting
+// "a/b" and "a/c.txt" produces "b/..." and "c.txt". Objects selected twice,
+// e.g. a folder and a file inside it, are written once.
+//
+// archive/zip switches to Zip64 records on its own when an entry or the
+// archive passes 4 GiB or 65535 entries. Nothing is buffered, and the stream
+// stops as soon as ctx is cancelled.
+func (s *Server) writeZip(ctx context.Context, w io.Writer, bucketName string, items []batchItem) error {
+	zipWriter := zip.NewWriter(w)
+	root := zipRoot(items)
+	written := make(map[string]bool)
+
+	add := func(object minio.ObjectInfo) error {
+		if written[object.Key] {
+			return nil
+		}
+		written[object.Key] = true
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return s.addZipEntry(ctx, zipWriter, bucketName, object, strings.TrimPrefix(object.Key, root))
+	}
+
+	for _, item := range items {
+		if item.Type == "file" {
+			objInfo, err := s.minioClient.StatObject(ctx, bucketName, item.Path, minio.StatObjectOptions{})
+			if err != nil {
+				return fmt.Errorf("stat %s: %v", item.Path, err)
+			}
+			if err := add(objInfo); err != nil {
+				return err
+			}
+			continue
+		}
+
+		objects, err := s.listPrefix(ctx, bucketName, item.Path+"/")
+		if err != nil {
+			return fmt.Errorf("listing %s: %v", item.Path, err)
+		}
+		for _, object := range objects {
+			if err := add(object); err != nil {
+				return err
+			}
+		}
+	}
+
+	return zipWriter.Close()
+}
+
+// streamZip sends a zip archive of items as the response.
+func (s *Server) streamZip(c *gin.Context, bucketName string, items []batchItem) {
+	c.Header("Content-Disposition", contenttype.Disposition("application/zip", zipName(items), false))
+	c.Header("Content-Type", "application/zip")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	err := s.writeZip(c.Request.Context(), c.Writer, bucketName, items)
+	if err != nil && c.Request.Context().Err() != nil {
+		log.Printf("Zip download cancelled by client")
+	} else if err != nil {
+		log.Printf("Error streaming zip: %v", err)
+	}
+}
+
+// zipDownloadHandler streams an archive of the files and folders given as
+// "path" parameters. Being a GET, it works as a plain download link.
+func (s *Server) zipDownloadHandler(c *gin.Context) {
+	bucketName, ok := s.getSessionBucket(c)
+	if !ok {
+		return
+	}
+
+	paths := c.QueryArray("path")
+	if len(paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No items selected"})
+		return
+	}
+	if len(paths) > maxBatchItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many items selected"})
+		return
+	}
+	if !s.checkVault(c, bucketName, paths...) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	items := make([]batchItem, 0, len(paths))
+	for _, p := range paths {
+		p = strings.Trim(p, "/")
+		if p == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Item path cannot be empty"})
+			return
+		}
+
+		isFile, err := s.objectExists(ctx, bucketName, p)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking item", "details": err.Error()})
+			return
+		}
+		if isFile {
+			items = append(items, batchItem{Path: p, Type: "file"})
+			continue
+		}
+
+		isFolder, err := s.prefixExists(ctx, bucketName, p+"/")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking item", "details": err.Error()})
+			return
+		}
+		if !isFolder {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found", "details": p})
+			return
+		}
+		items = append(items, batchItem{Path: p, Type: "folder"})
+	}
+
+	s.streamZip(c, bucketName, items)
+}
+
+// addZipEntry copies one object into the archive under name. Folder markers
+// become directory entries.
+func (s *Server) addZipEntry(ctx context.Context, zipWriter *zip.Writer, bucketName string, object minio.ObjectInfo, name string) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: object.LastModified,
+	}
+
+	if strings.HasSuffix(object.Key, "/") {
+		header.Method = zip.Store
+		_, err := zipWriter.CreateHeader(header)
+		return err
+	}
+
+	objectReader, _, err := s.getObject(ctx, bucketName, object.Key)
+	if err != nil {
+		return fmt.Errorf("getting %s: %v", object.Key, err)
+	}
+	defer objectReader.Close()
+
+	zipEntry, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("creating zip entry %s: %v", name, err)
+	}
+
+	if _, err := io.Copy(zipEntry, objectReader); err != nil {
+		return fmt.Errorf("copying %s to zip: %v", object.Key, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package textextract pulls searchable text out of stored documents. Plain
+// text, markdown and source code are read as is; PDFs are parsed in pure Go.
+package textextract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// Files larger than this are not indexed
+	MaxFileSize = 20 * 1024 * 1024
+	// Only this much extracted text is kept per file
+	MaxTextSize = 256 * 1024
+)
+
+// ErrUnsupported is returned for files whose text cannot be extracted.
+var ErrUnsupported = errors.New("unsupported file type")
+
+// Extensions that are indexed as plain text
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".rst": true, ".csv": true,
+	".tsv": true, ".log": true, ".json": true, ".xml": true, ".yaml": true,
+	".yml": true, ".toml": true, ".ini": true, ".cfg": true, ".conf": true,
+	".html": true, ".htm": true, ".css": true, ".sql": true, ".sh": true,
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true,
+	".tsx": true, ".java": true, ".kt": true, ".c": true, ".h": true,
+	".cpp": true, ".hpp": true, ".cc": true, ".cs": true, ".rs": true,
+	".rb": true, ".php": true, ".swift": true, ".scala": true, ".lua": true,
+	".r": true, ".m": true, ".pl": true, ".tex": true,
+}
+
+// Supported reports whether Extract can handle a file with this name and
+// content type.
+func Supported(name, contentType string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".pdf" || contentType == "application/pdf" ||
+		textExtensions[ext] || strings.HasPrefix(contentType, "text/")
+}
+
+// Extract returns the text content of a file read from r.
+func Extract(name, contentType string, r io.ReaderAt, size int64) (string, error) {
+	if !Supported(name, contentType) {
+		return "", ErrUnsupported
+	}
+	if size > MaxFileSize {
+		return "", fmt.Errorf("file too large to index: %d bytes", size)
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".pdf" || contentType == "application/pdf" {
+		return extractPDF(r, size)
+	}
+	return extractText(r, size)
+}
+
+func extractText(r io.ReaderAt, size int64) (string, error) {
+	if size > MaxTextSize {
+		size = MaxTextSize
+	}
+	buf := make([]byte, size)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+
+	if bytes.IndexByte(buf, 0) >= 0 {
+		return "", ErrUnsupported // binary data with a text extension
+	}
+	return clean(buf), nil
+}
+
+func extractPDF(r io.ReaderAt, size int64) (text string, err error) {
+	// The PDF parser panics on some malformed files
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed pdf: %v", p)
+		}
+	}()
+
+	reader, err := pdf.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(plain, MaxTextSize))
+	if err != nil {
+		return "", err
+	}
+	return clean(buf), nil
+}
+
+// clean drops invalid UTF-8 and NUL bytes, which Postgres text rejects.
+func clean(buf []byte) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(string(buf), ""), "\x00", "")
+}
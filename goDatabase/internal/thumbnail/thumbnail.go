@@ -0,0 +1,125 @@
+// Package thumbnail scales images down to the fixed thumbnail sizes used by
+// the file browser. Decoding and scaling are pure Go.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"path"
+	"strings"
+
+	// Registered decoders
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes are the bounding boxes, in pixels, thumbnails are generated for.
+var Sizes = []int{128, 256, 512}
+
+const (
+	// MaxFileSize is the largest source image that is thumbnailed.
+	MaxFileSize = 50 << 20
+	// MaxPixels guards against images that are small on disk but huge once
+	// decoded.
+	MaxPixels = 50_000_000
+
+	// ContentType is the type of the generated thumbnails.
+	ContentType = "image/jpeg"
+
+	jpegQuality = 80
+)
+
+// ErrUnsupported is returned for files that are not images this package can
+// decode.
+var ErrUnsupported = errors.New("unsupported image")
+
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// Supported reports whether a file looks like an image that can be
+// thumbnailed, judging by its content type or, failing that, its name.
+func Supported(name, contentType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return imageExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// SizeFor returns the smallest thumbnail size that covers the requested one,
+// or the largest size if none does.
+func SizeFor(requested int) int {
+	for _, size := range Sizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return Sizes[len(Sizes)-1]
+}
+
+// Generate decodes an image and returns a JPEG thumbnail for each of Sizes,
+// keyed by size. Images are never scaled up, and transparent areas are
+// flattened onto white.
+func Generate(r io.Reader) (map[int][]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("image larger than %d bytes", MaxFileSize)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image dimensions %dx%d out of range", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	thumbs := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scale(src, size), &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+		}
+		thumbs[size] = buf.Bytes()
+	}
+	return thumbs, nil
+}
+
+// scale fits src into a size x size box, keeping its aspect ratio.
+func scale(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+	return dst
+}
@@ -21,29 +21,226 @@ drop table if exists Folder cascade;
 CREATE TABLE Folder (
     folderID SERIAL NOT NULL PRIMARY KEY,
     folderName VARCHAR(255) NOT NULL,
+    folderPath VARCHAR(1024) NOT NULL, -- full path inside the bucket, without trailing slash
     creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     userID INT NOT NULL, -- reference to internal userID
     parentFolderID INT,
+    UNIQUE (userID, folderPath),
     FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE,
     FOREIGN KEY (parentFolderID) REFERENCES Folder(folderID) ON DELETE SET NULL
 );
 
+CREATE INDEX folder_user_path_idx ON Folder (userID, folderPath text_pattern_ops);
+
 drop table if exists Files cascade;
 
--- Create Files table
+-- Create Files table (metadata index of the objects in each user's bucket)
 CREATE TABLE Files (
+    fileID SERIAL NOT NULL PRIMARY KEY,
     fileName VARCHAR(255) NOT NULL,
-    fileType VARCHAR(50) NOT NULL,
+    objectKey VARCHAR(1024) NOT NULL, -- full MinIO object key
+    fileType VARCHAR(255) NOT NULL, -- content type
     fileSize BIGINT NOT NULL,
     uploadDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     lastModifiedData TIMESTAMP NOT NULL,
     folderID INT,
-    userID INT,
-    PRIMARY KEY (fileName, folderID, userID),
+    userID INT NOT NULL,
+    UNIQUE (userID, objectKey),
     FOREIGN KEY (folderID) REFERENCES Folder(folderID) ON DELETE CASCADE,
     FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
 );
 
+CREATE INDEX files_user_key_idx ON Files (userID, objectKey text_pattern_ops);
+CREATE INDEX files_user_name_idx ON Files (userID, lower(fileName));
+CREATE INDEX files_user_modified_idx ON Files (userID, lastModifiedData);
+
+drop table if exists fileContent cascade;
+
+-- Create fileContent table (full-text index of the text extracted from files)
+CREATE TABLE fileContent (
+    fileID INT NOT NULL PRIMARY KEY,
+    content TEXT NOT NULL,
+    contentVector TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', content)) STORED,
+    indexedDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (fileID) REFERENCES Files(fileID) ON DELETE CASCADE
+);
+
+CREATE INDEX filecontent_vector_idx ON fileContent USING GIN (contentVector);
+
+drop table if exists Tags cascade;
+
+-- Create Tags table (user-defined labels)
+CREATE TABLE Tags (
+    tagID SERIAL NOT NULL PRIMARY KEY,
+    tagName VARCHAR(64) NOT NULL, -- stored lower case
+    userID INT NOT NULL,
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (userID, tagName),
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+drop table if exists fileTags cascade;
+
+-- Create fileTags table (links tags to indexed files)
+CREATE TABLE fileTags (
+    fileID INT NOT NULL,
+    tagID INT NOT NULL,
+    PRIMARY KEY (fileID, tagID),
+    FOREIGN KEY (fileID) REFERENCES Files(fileID) ON DELETE CASCADE,
+    FOREIGN KEY (tagID) REFERENCES Tags(tagID) ON DELETE CASCADE
+);
+
+CREATE INDEX filetags_tag_idx ON fileTags (tagID);
+
+drop table if exists stars cascade;
+
+-- Create stars table (files and folders pinned by their owner)
+CREATE TABLE stars (
+    starID SERIAL NOT NULL PRIMARY KEY,
+    userID INT NOT NULL,
+    fileID INT,
+    folderID INT,
+    starredDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (userID, fileID),
+    UNIQUE (userID, folderID),
+    CHECK ((fileID IS NULL) <> (folderID IS NULL)), -- exactly one of the two
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE,
+    FOREIGN KEY (fileID) REFERENCES Files(fileID) ON DELETE CASCADE,
+    FOREIGN KEY (folderID) REFERENCES Folder(folderID) ON DELETE CASCADE
+);
+
+drop table if exists fileActivity cascade;
+
+-- Create fileActivity table (recent uploads, downloads and changes per user)
+CREATE TABLE fileActivity (
+    activityID SERIAL NOT NULL PRIMARY KEY,
+    userID INT NOT NULL,
+    fileID INT NOT NULL,
+    action VARCHAR(16) NOT NULL, -- upload, download, move or rename
+    activityDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE,
+    FOREIGN KEY (fileID) REFERENCES Files(fileID) ON DELETE CASCADE
+);
+
+CREATE INDEX fileactivity_user_date_idx ON fileActivity (userID, activityDate DESC);
+
+drop table if exists shareLinks cascade;
+
+-- Create shareLinks table (public links to a user's file or folder)
+CREATE TABLE shareLinks (
+    linkID SERIAL NOT NULL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    userID INT NOT NULL,
+    itemPath TEXT NOT NULL,
+    itemType VARCHAR(8) NOT NULL, -- file or folder
+    passwordHash TEXT, -- bcrypt, NULL for links without a password
+    expiresAt TIMESTAMP, -- NULL for links that do not expire
+    maxDownloads INT, -- NULL for unlimited downloads
+    downloadCount INT NOT NULL DEFAULT 0,
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+CREATE INDEX sharelinks_user_path_idx ON shareLinks (userID, itemPath);
+
+drop table if exists shareAccessLog cascade;
+
+-- Create shareAccessLog table (every use of a share link)
+CREATE TABLE shareAccessLog (
+    accessID SERIAL NOT NULL PRIMARY KEY,
+    linkID INT NOT NULL,
+    accessDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ipAddress VARCHAR(64) NOT NULL,
+    userAgent TEXT NOT NULL,
+    outcome VARCHAR(32) NOT NULL, -- download, password_required, wrong_password, throttled, expired, limit_reached, revoked or missing
+    FOREIGN KEY (linkID) REFERENCES shareLinks(linkID) ON DELETE CASCADE
+);
+
+CREATE INDEX shareaccesslog_link_idx ON shareAccessLog (linkID, accessID DESC);
+
+drop table if exists accessGrants cascade;
+
+-- Create accessGrants table (files and folders shared with other users)
+CREATE TABLE accessGrants (
+    grantID SERIAL NOT NULL PRIMARY KEY,
+    ownerID INT NOT NULL,
+    granteeID INT NOT NULL,
+    itemPath TEXT NOT NULL, -- a grant on a folder covers everything inside it
+    itemType VARCHAR(8) NOT NULL, -- file or folder
+    role VARCHAR(8) NOT NULL, -- viewer or editor
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (ownerID, granteeID, itemPath),
+    CHECK (role IN ('viewer', 'editor')),
+    CHECK (ownerID <> granteeID),
+    FOREIGN KEY (ownerID) REFERENCES userInfo(userID) ON DELETE CASCADE,
+    FOREIGN KEY (granteeID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+CREATE INDEX accessgrants_grantee_idx ON accessGrants (granteeID);
+
+drop table if exists fileRequests cascade;
+
+-- Create fileRequests table (upload-only links into a user's folder)
+CREATE TABLE fileRequests (
+    requestID SERIAL NOT NULL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    userID INT NOT NULL,
+    folderPath VARCHAR(1024) NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    expiresAt TIMESTAMP, -- NULL for requests that do not expire
+    maxTotalBytes BIGINT, -- NULL when only the storage quota applies
+    uploadedBytes BIGINT NOT NULL DEFAULT 0,
+    allowedTypes TEXT NOT NULL DEFAULT '', -- comma separated extensions and MIME types, empty for any
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+drop table if exists notifications cascade;
+
+-- Create notifications table (in-app messages, such as files received)
+CREATE TABLE notifications (
+    notificationID SERIAL NOT NULL PRIMARY KEY,
+    userID INT NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    message TEXT NOT NULL,
+    itemPath TEXT NOT NULL DEFAULT '',
+    isRead BOOLEAN NOT NULL DEFAULT FALSE,
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+CREATE INDEX notifications_user_idx ON notifications (userID, notificationID DESC);
+
+drop table if exists userDataKeys cascade;
+
+-- Create userDataKeys table (file encryption keys, wrapped by a server master key)
+CREATE TABLE userDataKeys (
+    userID INT NOT NULL,
+    keyVersion INT NOT NULL, -- recorded in every file encrypted with the key
+    wrappedKey BYTEA NOT NULL,
+    masterKeyID VARCHAR(64) NOT NULL, -- master key the data key is wrapped with
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (userID, keyVersion),
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+CREATE INDEX userdatakeys_master_idx ON userDataKeys (masterKeyID);
+
+drop table if exists userVaults cascade;
+
+-- Create userVaults table (key of the face-unlocked vault folder, wrapped by a server master key)
+CREATE TABLE userVaults (
+    userID INT NOT NULL PRIMARY KEY,
+    wrappedKey BYTEA NOT NULL,
+    masterKeyID VARCHAR(64) NOT NULL,
+    creationDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES userInfo(userID) ON DELETE CASCADE
+);
+
+CREATE INDEX uservaults_master_idx ON userVaults (masterKeyID);
+
 drop table if exists faceAuthentication cascade;
 
 -- Create faceAuthentication table
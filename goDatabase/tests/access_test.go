@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goDatabase/internal/database"
+)
+
+// accessDB is a listing index where user 9 shared "team" with the test user
+// (ID 7) as viewer. It remembers whose folder was listed.
+type accessDB struct {
+	listingDB
+	listedUser int
+}
+
+func (db *accessDB) AccessRole(ownerID, granteeID int, itemPath string) (string, error) {
+	if ownerID == 9 && granteeID == 7 && (itemPath == "team" || strings.HasPrefix(itemPath, "team/")) {
+		return "viewer", nil
+	}
+	return "", nil
+}
+
+func (db *accessDB) ListFolder(userID int, folderPath string, opts database.ListOptions) ([]database.FolderRecord, []database.FileRecord, *database.ListCursor, error) {
+	db.listedUser = userID
+	return db.listingDB.ListFolder(userID, folderPath, opts)
+}
+
+func TestOwnerContext(t *testing.T) {
+	db := &accessDB{}
+	router, cookie := newTestServer(t, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every bucket exists; nothing else is needed for listings
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	get := func(target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("/api/listBucket?path=team/docs&owner=9"); code != http.StatusOK {
+		t.Fatalf("listing a shared folder returned %d, want 200", code)
+	}
+	if db.listedUser != 9 {
+		t.Errorf("listed the folder of user %d, want the owner 9", db.listedUser)
+	}
+	for _, target := range []string{
+		"/api/listBucket?path=private&owner=9",
+		"/api/listBucket?owner=9",
+		"/api/listBucket?path=team&owner=8",
+		"/api/downloadFile/private/plan.pdf?owner=9",
+	} {
+		if code := get(target); code != http.StatusForbidden {
+			t.Errorf("%s returned %d, want 403", target, code)
+		}
+	}
+
+	// Viewers cannot upload
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("path", "team")
+	form.WriteField("owner", "9")
+	w, _ := form.CreateFormFile("files", "notes.txt")
+	w.Write([]byte("hello"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/uploadFile", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("viewer upload returned %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goDatabase/internal/archive"
+)
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, content)
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveWalk(t *testing.T) {
+	files := map[string]string{"src/main.go": "package main", "README.md": "# Project"}
+	for _, tt := range []struct {
+		format archive.Format
+		data   []byte
+	}{
+		{archive.Zip, makeZip(t, files)},
+		{archive.TarGz, makeTarGz(t, files)},
+	} {
+		got := make(map[string]string)
+		err := archive.Walk(tt.format, bytes.NewReader(tt.data), int64(len(tt.data)), archive.Limits{}, func(e archive.Entry, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			got[e.Name] = string(data)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Walk(%v) failed: %v", tt.format, err)
+		}
+		if !reflect.DeepEqual(got, files) {
+			t.Errorf("Walk(%v) read %v, want %v", tt.format, got, files)
+		}
+	}
+}
+
+func TestArchiveRejectsZipSlip(t *testing.T) {
+	for _, name := range []string{"../evil.sh", "docs/../../evil.sh", "/etc/passwd", `..\evil.bat`, "C:/evil.bat"} {
+		data := makeZip(t, map[string]string{name: "x"})
+		if _, _, err := archive.Scan(archive.Zip, bytes.NewReader(data), int64(len(data)), archive.Limits{}); err == nil {
+			t.Errorf("Scan accepted entry %q", name)
+		}
+	}
+
+	if name, err := archive.CleanName("./docs//notes.txt"); err != nil || name != "docs/notes.txt" {
+		t.Errorf("CleanName = %q, %v; want docs/notes.txt", name, err)
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	data := makeZip(t, map[string]string{"a": "1", "b": "2", "c": "3"})
+	_, _, err := archive.Scan(archive.Zip, bytes.NewReader(data), int64(len(data)), archive.Limits{MaxEntries: 2})
+	if !errors.Is(err, archive.ErrTooManyEntries) {
+		t.Errorf("Scan with too many entries returned %v", err)
+	}
+
+	// Ten megabytes of zeros compress to a few kilobytes
+	bomb := makeTarGz(t, map[string]string{"zeros": string(make([]byte, 10<<20))})
+	_, _, err = archive.Scan(archive.TarGz, bytes.NewReader(bomb), int64(len(bomb)), archive.Limits{MaxRatio: 100})
+	if !errors.Is(err, archive.ErrRatio) {
+		t.Errorf("Scan of a zip bomb returned %v", err)
+	}
+}
+
+// countingWriter counts the bytes the fake S3 sends back.
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestBrowseArchive(t *testing.T) {
+	// A large incompressible entry that listing must not download
+	noise := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(noise)
+	data := makeZip(t, map[string]string{"big.bin": string(noise), "docs/readme.txt": "hello"})
+
+	var served int64
+	router, cookie := newTestServer(t, &downloadDB{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user-7/project.zip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		http.ServeContent(countingWriter{w, &served}, r, "", time.Now(), bytes.NewReader(data))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/archive/list?path=project.zip", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list returned %d: %s", rec.Code, rec.Body.String())
+	}
+	var listing struct {
+		Entries []archive.Entry `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]int64)
+	for _, e := range listing.Entries {
+		names[e.Name] = e.Size
+	}
+	if want := map[string]int64{"big.bin": int64(len(noise)), "docs/readme.txt": 5}; !reflect.DeepEqual(names, want) {
+		t.Errorf("list returned %v, want %v", names, want)
+	}
+	if served > int64(len(data))/4 {
+		t.Errorf("listing read %d of %d bytes", served, len(data))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/archive/entry?path=project.zip&entry=docs/readme.txt", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("entry returned %d %q, want 200 \"hello\"", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("entry Content-Type is %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/archive/entry?path=project.zip&entry=missing.txt", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing entry returned %d, want 404", rec.Code)
+	}
+}
+
+func TestExtractStorageErrors(t *testing.T) {
+	denied := false
+	router, cookie := newTestServer(t, &downloadDB{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if denied {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied.</Message></Error>`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+	}))
+
+	extract := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/extract", strings.NewReader(`{"path": "project.zip"}`))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := extract(); code != http.StatusNotFound {
+		t.Errorf("extracting a missing archive returned %d, want 404", code)
+	}
+	denied = true
+	if code := extract(); code != http.StatusInternalServerError {
+		t.Errorf("extracting with storage denying access returned %d, want 500", code)
+	}
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// batchResponse is the body of a batch delete or move response.
+type batchResponse struct {
+	Results []struct {
+		Path    string
+		Success bool
+		NewPath string
+		Error   string
+	}
+	Total       int
+	TotalFailed int `json:"total_failed"`
+}
+
+// postBatch sends items to a batch endpoint and decodes the response.
+func postBatch(t *testing.T, router http.Handler, cookie *http.Cookie, target string, body map[string]any) batchResponse {
+	t.Helper()
+	rec := sendJSON(router, cookie, http.MethodPost, target, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s returned %d: %s", target, rec.Code, rec.Body)
+	}
+	var response batchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// outcomes maps each item of a batch response to its new path, or to
+// "failed" when it did not succeed.
+func outcomes(response batchResponse) map[string]string {
+	got := make(map[string]string)
+	for _, result := range response.Results {
+		if result.Success {
+			got[result.Path] = result.NewPath
+		} else {
+			got[result.Path] = "failed"
+		}
+	}
+	return got
+}
+
+func TestBatchDeletePartialFailure(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "a.txt", "docs/", "docs/b.txt", "docs/c.txt", "e.txt")
+	s3.undeletable = map[string]bool{"user-7/docs/c.txt": true}
+
+	response := postBatch(t, router, cookie, "/api/batch/delete", map[string]any{
+		"items": []map[string]string{
+			{"path": "a.txt", "type": "file"},
+			{"path": "docs", "type": "folder"},
+		},
+	})
+
+	if response.Total != 2 || response.TotalFailed != 1 {
+		t.Errorf("total %d and total_failed %d, want 2 and 1", response.Total, response.TotalFailed)
+	}
+	if got, want := outcomes(response), map[string]string{"a.txt": "", "docs": "failed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+	if response.Results[1].Error == "" {
+		t.Error("failed item carries no error")
+	}
+
+	if got, want := bucketKeys(s3), []string{"docs/c.txt", "e.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+	// Only items that were removed entirely leave the index
+	if got, want := db.fileKeys(), []string{"docs/b.txt", "docs/c.txt", "e.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v, want %v", got, want)
+	}
+}
+
+func TestBatchMovePartialFailure(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "a.txt", "b.txt", "archive/", "archive/old/")
+	s3.fail = func(r *http.Request) bool {
+		return r.Method == http.MethodPut && r.URL.Path == "/user-7/archive/b.txt"
+	}
+
+	response := postBatch(t, router, cookie, "/api/batch/move", map[string]any{
+		"destinationPath": "archive/old",
+		"items": []map[string]string{
+			{"path": "a.txt", "type": "file"},
+			{"path": "b.txt", "type": "file"},
+			{"path": "archive", "type": "folder"},
+		},
+	})
+	// b.txt goes to archive/old, so only the folder moved into itself fails
+	if response.TotalFailed != 1 {
+		t.Errorf("total_failed is %d, want 1", response.TotalFailed)
+	}
+	want := map[string]string{"a.txt": "archive/old/a.txt", "b.txt": "archive/old/b.txt", "archive": "failed"}
+	if got := outcomes(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+
+	response = postBatch(t, router, cookie, "/api/batch/move", map[string]any{
+		"destinationPath": "archive",
+		"items": []map[string]string{
+			{"path": "archive/old/a.txt", "type": "file"},
+			{"path": "archive/old/b.txt", "type": "file"},
+		},
+	})
+	if response.TotalFailed != 1 {
+		t.Errorf("total_failed is %d, want 1", response.TotalFailed)
+	}
+	want = map[string]string{"archive/old/a.txt": "archive/a.txt", "archive/old/b.txt": "failed"}
+	if got := outcomes(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+
+	if got, want := bucketKeys(s3), []string{"archive/", "archive/a.txt", "archive/old/", "archive/old/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+	if got, want := db.fileKeys(), []string{"archive/a.txt", "archive/old/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v, want %v", got, want)
+	}
+}
+
+func TestBatchRejectsInvalidItems(t *testing.T) {
+	router, cookie, _, _ := newIndexServer(t, "a.txt")
+
+	for _, items := range [][]map[string]string{
+		{},
+		{{"path": "/", "type": "file"}},
+		{{"path": "a.txt", "type": "link"}},
+	} {
+		rec := sendJSON(router, cookie, http.MethodPost, "/api/batch/delete", map[string]any{"items": items})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("batch delete of %v returned %d, want 400", items, rec.Code)
+		}
+	}
+}
+
+func TestBatchDeleteMissingItems(t *testing.T) {
+	router, cookie, s3, _ := newIndexServer(t, "a.txt", "docs/", "docs/b.txt")
+
+	response := postBatch(t, router, cookie, "/api/batch/delete", map[string]any{
+		"items": []map[string]string{
+			{"path": "a.txt", "type": "file"},
+			{"path": "missing.txt", "type": "file"},
+			{"path": "nowhere", "type": "folder"},
+		},
+	})
+
+	if got, want := outcomes(response), map[string]string{"a.txt": "", "missing.txt": "failed", "nowhere": "failed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+	if got, want := response.Results[1].Error, "file not found"; got != want {
+		t.Errorf("missing file reports %q, want %q", got, want)
+	}
+	if got, want := bucketKeys(s3), []string{"docs/", "docs/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+}
+
+func TestBatchMoveConflicts(t *testing.T) {
+	router, cookie, s3, _ := newIndexServer(t, "a.txt", "x/", "x/a.txt", "docs/", "docs/a.txt")
+	items := []map[string]string{
+		{"path": "a.txt", "type": "file"},
+		{"path": "x/a.txt", "type": "file"},
+	}
+
+	// By default a clash fails the item and nothing is overwritten
+	response := postBatch(t, router, cookie, "/api/batch/move", map[string]any{"destinationPath": "docs", "items": items})
+	if got, want := outcomes(response), map[string]string{"a.txt": "failed", "x/a.txt": "failed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+	if got, want := bucketKeys(s3), []string{"a.txt", "docs/", "docs/a.txt", "x/", "x/a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+
+	// keepBoth gives each item its own name, also when they clash with each other
+	response = postBatch(t, router, cookie, "/api/batch/move", map[string]any{"destinationPath": "docs", "items": items, "conflict": "keepBoth"})
+	want := map[string]string{"a.txt": "docs/a (1).txt", "x/a.txt": "docs/a (2).txt"}
+	if got := outcomes(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+	if got := string(s3.object("user-7/docs/a.txt").data); got != "docs/a.txt" {
+		t.Errorf("docs/a.txt holds %q after the move", got)
+	}
+	if got, want := string(s3.object("user-7/docs/a (2).txt").data), "x/a.txt"; got != want {
+		t.Errorf("docs/a (2).txt holds %q, want %q", got, want)
+	}
+}
+
+func TestBatchMoveRejectsOverlappingItems(t *testing.T) {
+	router, cookie, _, _ := newIndexServer(t, "docs/", "docs/a.txt", "archive/")
+
+	for _, body := range []map[string]any{
+		{"destinationPath": "archive", "items": []map[string]string{{"path": "docs", "type": "folder"}, {"path": "docs/a.txt", "type": "file"}}},
+		{"destinationPath": "archive", "items": []map[string]string{{"path": "docs/a.txt", "type": "file"}, {"path": "docs/a.txt", "type": "file"}}},
+		{"destinationPath": "archive", "items": []map[string]string{{"path": "docs", "type": "folder"}}, "conflict": "overwrite"},
+	} {
+		rec := sendJSON(router, cookie, http.MethodPost, "/api/batch/move", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("batch move of %v returned %d, want 400", body, rec.Code)
+		}
+	}
+}
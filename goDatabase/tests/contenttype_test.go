@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"goDatabase/internal/contenttype"
+	"testing"
+)
+
+func TestResolveContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	tests := []struct {
+		name, stored string
+		head         []byte
+		want         string
+	}{
+		{"photo.bin", "image/jpeg", png, "image/jpeg"},                        // stored type wins
+		{"photo.bin", "application/octet-stream", png, "image/png"},           // sniffed
+		{"notes.md", "", []byte("# Notes\n"), "text/markdown; charset=utf-8"}, // text refined by name
+		{"data", "", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := contenttype.Resolve(tt.name, tt.stored, tt.head); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %q, want %q", tt.name, tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestDisposition(t *testing.T) {
+	tests := []struct {
+		contentType, name string
+		inline            bool
+		want              string
+	}{
+		{"application/pdf", "report.pdf", true, `inline; filename="report.pdf"; filename*=UTF-8''report.pdf`},
+		{"application/pdf", "report.pdf", false, `attachment; filename="report.pdf"; filename*=UTF-8''report.pdf`},
+		// Types that can run script are never inline
+		{"text/html; charset=utf-8", "page.html", true, `attachment; filename="page.html"; filename*=UTF-8''page.html`},
+		{"image/svg+xml", "logo.svg", true, `attachment; filename="logo.svg"; filename*=UTF-8''logo.svg`},
+		{"image/jpeg", "docs/Café \"1\".jpg", true, `inline; filename="Caf_ _1_.jpg"; filename*=UTF-8''Caf%C3%A9%20%221%22.jpg`},
+	}
+	for _, tt := range tests {
+		if got := contenttype.Disposition(tt.contentType, tt.name, tt.inline); got != tt.want {
+			t.Errorf("Disposition(%q, %q, %v) = %s, want %s", tt.contentType, tt.name, tt.inline, got, tt.want)
+		}
+	}
+}
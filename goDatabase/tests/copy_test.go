@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/server"
+)
+
+// copyItem copies an item into destination and returns the path of the copy.
+func copyItem(t *testing.T, router http.Handler, cookie *http.Cookie, source, destination, itemType string) string {
+	t.Helper()
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/copy", map[string]string{"sourcePath": source, "destinationPath": destination, "type": itemType})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("copying %s returned %d: %s", source, rec.Code, rec.Body)
+	}
+	var response struct{ NewPath string }
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return response.NewPath
+}
+
+func TestCopySuffixesNames(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "docs/a.txt", "docs/.env", "docs/sub/b.txt")
+
+	for _, tt := range []struct{ source, itemType, want string }{
+		{"docs/a.txt", "file", "docs/a (1).txt"},
+		{"docs/a.txt", "file", "docs/a (2).txt"},
+		// A copy of a copy counts on instead of stacking suffixes
+		{"docs/a (1).txt", "file", "docs/a (3).txt"},
+		{"docs/.env", "file", "docs/.env (1)"},
+		{"docs/sub", "folder", "docs/sub (1)"},
+	} {
+		if got := copyItem(t, router, cookie, tt.source, "docs", tt.itemType); got != tt.want {
+			t.Errorf("copy of %s was named %s, want %s", tt.source, got, tt.want)
+		}
+	}
+
+	if obj := s3.object("user-7/docs/a (3).txt"); obj == nil || string(obj.data) != "docs/a.txt" {
+		t.Error("copy of a copy does not hold the original content")
+	}
+	if s3.object("user-7/docs/sub (1)/b.txt") == nil {
+		t.Error("folder copy is missing its file")
+	}
+	want := []string{"docs/.env", "docs/.env (1)", "docs/a (1).txt", "docs/a (2).txt", "docs/a (3).txt", "docs/a.txt", "docs/sub (1)/b.txt", "docs/sub/b.txt"}
+	if got := db.fileKeys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v, want %v", got, want)
+	}
+	if db.fileID("docs/a (1).txt") == db.fileID("docs/a.txt") {
+		t.Error("copy shares the index entry of its source")
+	}
+}
+
+func TestCopyIntoOtherFolderKeepsName(t *testing.T) {
+	router, cookie, _, _ := newIndexServer(t, "docs/a.txt", "archive/")
+
+	if got := copyItem(t, router, cookie, "docs/a.txt", "archive", "file"); got != "archive/a.txt" {
+		t.Errorf("copy was named %s, want archive/a.txt", got)
+	}
+	if got := copyItem(t, router, cookie, "docs", "", "folder"); got != "docs (1)" {
+		t.Errorf("folder copy was named %s, want docs (1)", got)
+	}
+}
+
+func TestCopyRefused(t *testing.T) {
+	router, cookie, s3, _ := newIndexServer(t, "docs/a.txt")
+
+	for _, tt := range []struct {
+		source, destination, itemType string
+		want                          int
+	}{
+		{"docs/missing.txt", "docs", "file", http.StatusNotFound},
+		{"missing", "", "folder", http.StatusNotFound},
+		{"docs", "docs/inner", "folder", http.StatusBadRequest},
+		{"", "docs", "file", http.StatusBadRequest},
+		{"docs/a.txt", "docs", "link", http.StatusBadRequest},
+	} {
+		rec := sendJSON(router, cookie, http.MethodPost, "/api/copy", map[string]string{"sourcePath": tt.source, "destinationPath": tt.destination, "type": tt.itemType})
+		if rec.Code != tt.want {
+			t.Errorf("copying %q into %q returned %d, want %d", tt.source, tt.destination, rec.Code, tt.want)
+		}
+	}
+	if got := bucketKeys(s3); !reflect.DeepEqual(got, []string{"docs/a.txt"}) {
+		t.Errorf("bucket holds %v, want it unchanged", got)
+	}
+}
+
+func TestCopyFolderRollback(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "docs/", "docs/a.txt", "docs/b.txt", "docs/c.txt")
+	s3.fail = func(r *http.Request) bool {
+		return r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/docs (1)/c.txt")
+	}
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/copy", map[string]string{"sourcePath": "docs", "destinationPath": "", "type": "folder"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("copy returned %d, want 500", rec.Code)
+	}
+	want := []string{"docs/", "docs/a.txt", "docs/b.txt", "docs/c.txt"}
+	if got := bucketKeys(s3); !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v after a failed copy, want %v", got, want)
+	}
+	if got, want := db.fileKeys(), []string{"docs/a.txt", "docs/b.txt", "docs/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v after a failed copy, want %v", got, want)
+	}
+}
+
+func TestCopyQuotaCountsPlaintext(t *testing.T) {
+	// An encrypted file holding half the quota. Its ciphertext is a little
+	// larger, so a copy only fits if plaintext sizes are counted.
+	plain := int64(server.STORAGE_LIMIT_BYTES / 2)
+	for _, tt := range []struct{ source, destination, itemType string }{
+		{"docs/big.bin", "docs", "file"},
+		{"docs", "", "folder"},
+	} {
+		router, cookie, s3, _ := newIndexServer(t)
+		s3.objects["user-7/docs/big.bin"] = &memObject{
+			data:   make([]byte, envelope.EncryptedSize(plain)),
+			header: http.Header{"X-Amz-Meta-Encryption": {"aes-256-gcm-chunked"}},
+			etag:   `"big"`,
+		}
+
+		copyItem(t, router, cookie, tt.source, tt.destination, tt.itemType)
+
+		rec := sendJSON(router, cookie, http.MethodGet, "/api/bucket-stats", nil)
+		var stats struct{ PercentageUsed float64 }
+		json.Unmarshal(rec.Body.Bytes(), &stats)
+		if stats.PercentageUsed != 100 {
+			t.Errorf("after copying the %s, %v%% of the quota is used, want 100%%", tt.itemType, stats.PercentageUsed)
+		}
+
+		// The bucket is now full
+		rec = sendJSON(router, cookie, http.MethodPost, "/api/copy", map[string]string{"sourcePath": tt.source, "destinationPath": tt.destination, "type": tt.itemType})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("copying the %s into a full bucket returned %d, want 400", tt.itemType, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// downloadDB resolves the test user's bucket. Only the methods used by the
+// download handlers are implemented.
+type downloadDB struct {
+	userDB
+}
+
+const downloadETag = `"0123456789abcdef"`
+
+var downloadContent = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+// newDownloadServer stands in for MinIO with a single object that honours
+// Range and conditional requests.
+func newDownloadServer(t *testing.T) (http.Handler, *http.Cookie) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return newTestServer(t, &downloadDB{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user-7/videos/clip.mp4" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", downloadETag)
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "", modified, bytes.NewReader(downloadContent))
+	}))
+}
+
+func download(router http.Handler, cookie *http.Cookie, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/downloadFile/videos/clip.mp4", nil)
+	req.AddCookie(cookie)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDownloadWholeFile(t *testing.T) {
+	router, cookie := newDownloadServer(t)
+
+	rec := download(router, cookie, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("download returned %d, want 200", rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), downloadContent) {
+		t.Errorf("download returned %q, want %q", rec.Body.Bytes(), downloadContent)
+	}
+	if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges is %q, want bytes", got)
+	}
+	if got := rec.Header().Get("ETag"); got != downloadETag {
+		t.Errorf("ETag is %q, want %q", got, downloadETag)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("Content-Type is %q, want video/mp4", got)
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), `inline; filename="clip.mp4"; filename*=UTF-8''clip.mp4`; got != want {
+		t.Errorf("Content-Disposition is %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options is %q, want nosniff", got)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	router, cookie := newDownloadServer(t)
+
+	rec := download(router, cookie, map[string]string{"Range": "bytes=10-15"})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("download returned %d, want 206", rec.Code)
+	}
+	if want := downloadContent[10:16]; !bytes.Equal(rec.Body.Bytes(), want) {
+		t.Errorf("download returned %q, want %q", rec.Body.Bytes(), want)
+	}
+	if got, want := rec.Header().Get("Content-Range"), "bytes 10-15/36"; got != want {
+		t.Errorf("Content-Range is %q, want %q", got, want)
+	}
+}
+
+func TestDownloadConditional(t *testing.T) {
+	router, cookie := newDownloadServer(t)
+
+	rec := download(router, cookie, map[string]string{"If-None-Match": downloadETag})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match returned %d, want 304", rec.Code)
+	}
+
+	rec = download(router, cookie, map[string]string{"If-Modified-Since": "Thu, 02 May 2024 00:00:00 GMT"})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since returned %d, want 304", rec.Code)
+	}
+}
+
+func TestDownloadStorageErrors(t *testing.T) {
+	router, cookie := newTestServer(t, &downloadDB{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied.</Message></Error>`)
+	}))
+
+	// Only a missing object is a 404; storage failures are not hidden
+	if rec := download(router, cookie, nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("download with storage denying access returned %d, want 500", rec.Code)
+	}
+
+	router, cookie = newDownloadServer(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/downloadFile/videos/missing.mp4", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("download of a missing file returned %d, want 404", rec.Code)
+	}
+}
+
+func TestDownloadZipOfSelection(t *testing.T) {
+	objects := map[string]string{
+		"/user-7/docs/a/x.txt": "first file",
+		"/user-7/docs/b/y.txt": "second file",
+	}
+	router, cookie := newTestServer(t, &downloadDB{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		http.ServeContent(w, r, "", time.Now(), strings.NewReader(content))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/downloadZip?path=docs/a/x.txt&path=docs/b/y.txt", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("download returned %d: %s", rec.Code, rec.Body.String())
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename="docs.zip"; filename*=UTF-8''docs.zip`; got != want {
+		t.Errorf("Content-Disposition is %q, want %q", got, want)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		got[f.Name] = string(data)
+	}
+	want := map[string]string{"a/x.txt": "first file", "b/y.txt": "second file"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+}
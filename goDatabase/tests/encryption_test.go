@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"goDatabase/internal/database"
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/kms"
+	"goDatabase/internal/server"
+)
+
+// encryptionDB adds data keys to fileRequestDB.
+type encryptionDB struct {
+	fileRequestDB
+	keyMu    sync.Mutex
+	dataKeys []database.DataKey
+}
+
+func (db *encryptionDB) DataKeys(userID int) ([]database.DataKey, error) {
+	db.keyMu.Lock()
+	defer db.keyMu.Unlock()
+	var keys []database.DataKey
+	for _, k := range db.dataKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (db *encryptionDB) AddDataKey(userID, version int, wrappedKey []byte, masterKeyID string) error {
+	db.keyMu.Lock()
+	defer db.keyMu.Unlock()
+	for _, k := range db.dataKeys {
+		if k.UserID == userID && k.Version == version {
+			return database.ErrDataKeyExists
+		}
+	}
+	db.dataKeys = append(db.dataKeys, database.DataKey{UserID: userID, Version: version, WrappedKey: wrappedKey, MasterKeyID: masterKeyID})
+	return nil
+}
+
+func (db *encryptionDB) DataKeysNotWrappedBy(masterKeyID string, limit int) ([]database.DataKey, error) {
+	return nil, nil
+}
+
+func (db *encryptionDB) VaultsNotWrappedBy(masterKeyID string, limit int) ([]database.Vault, error) {
+	return nil, nil
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	keyring, err := envelope.ParseKeyring("test:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &encryptionDB{fileRequestDB: fileRequestDB{requests: map[string]*database.FileRequest{
+		"docs": {RequestID: 1, Token: "docs", UserID: 7, FolderPath: "clients"},
+	}}}
+	s3 := &memS3{objects: make(map[string]*memObject)}
+	router, cookie := newConfiguredServer(t, db, s3, func(s *server.Server) {
+		s.EnableEncryption(kms.NewStatic(keyring))
+	})
+
+	var plain bytes.Buffer
+	for i := 0; plain.Len() < 3*envelope.ChunkSize; i++ {
+		fmt.Fprintf(&plain, "line %d of the contract\n", i)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("name", "Ann")
+	w, _ := form.CreateFormFile("files", "contract.txt")
+	w.Write(plain.Bytes())
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/r/docs", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+
+	stored := s3.object("user-7/clients/contract.txt")
+	if stored == nil {
+		t.Fatal("upload was not stored")
+	}
+	if bytes.Contains(stored.data, []byte("of the contract")) {
+		t.Error("stored object contains the plaintext")
+	}
+	if int64(len(stored.data)) != envelope.EncryptedSize(int64(plain.Len())) {
+		t.Errorf("stored %d bytes, want %d", len(stored.data), envelope.EncryptedSize(int64(plain.Len())))
+	}
+	if len(db.dataKeys) != 1 || db.dataKeys[0].Version != 1 || db.dataKeys[0].MasterKeyID != "test" {
+		t.Fatalf("data keys are %+v, want version 1 wrapped with master key test", db.dataKeys)
+	}
+
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/downloadFile/clients/contract.txt", nil)
+		req.AddCookie(cookie)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec = get("")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), plain.Bytes()) {
+		t.Fatalf("download returned %d and %d bytes, want the %d plaintext bytes", rec.Code, rec.Body.Len(), plain.Len())
+	}
+	if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(plain.Len()) {
+		t.Errorf("Content-Length is %s, want %d", got, plain.Len())
+	}
+
+	// A range across a chunk border only needs the chunks it touches
+	from := envelope.ChunkSize - 10
+	rec = get(fmt.Sprintf("bytes=%d-%d", from, from+19))
+	if rec.Code != http.StatusPartialContent || !bytes.Equal(rec.Body.Bytes(), plain.Bytes()[from:from+20]) {
+		t.Errorf("range returned %d %q, want 206 %q", rec.Code, rec.Body.Bytes(), plain.Bytes()[from:from+20])
+	}
+
+	// Damaged objects are refused rather than served
+	s3.mu.Lock()
+	stored.data[envelope.HeaderSize+5] ^= 1
+	s3.mu.Unlock()
+	for _, rangeHeader := range []string{"", "bytes=0-99"} {
+		if rec = get(rangeHeader); rec.Code < 400 || bytes.Contains(rec.Body.Bytes(), []byte("contract")) {
+			t.Errorf("damaged object with range %q returned %d", rangeHeader, rec.Code)
+		}
+	}
+}
+
+// reindexDB records what a reindex found.
+type reindexDB struct {
+	encryptionDB
+	folders []string
+	files   []database.FileRecord
+}
+
+func (db *reindexDB) ReindexUser(userID int, folders []string, files []database.FileRecord) error {
+	db.folders, db.files = folders, files
+	return nil
+}
+
+func TestReindexEncryptedBucket(t *testing.T) {
+	keyring, err := envelope.ParseKeyring("test:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &reindexDB{encryptionDB: encryptionDB{fileRequestDB: fileRequestDB{requests: map[string]*database.FileRequest{
+		"docs": {RequestID: 1, Token: "docs", UserID: 7, FolderPath: "clients"},
+	}}}}
+	s3 := &memS3{objects: make(map[string]*memObject)}
+	router, cookie := newConfiguredServer(t, db, s3, func(s *server.Server) {
+		s.EnableEncryption(kms.NewStatic(keyring))
+		s.DisableUploadPipeline()
+	})
+
+	plain := strings.Repeat("signed by both parties\n", 5000)
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("name", "Ann")
+	w, _ := form.CreateFormFile("files", "contract.txt")
+	io.WriteString(w, plain)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/r/docs", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body)
+	}
+	// A copy left behind by an interrupted re-encryption
+	s3.mu.Lock()
+	s3.objects["user-7/.encryption/0123abcd"] = &memObject{data: []byte("leftover"), header: http.Header{}, etag: `"1"`}
+	s3.mu.Unlock()
+
+	req = httptest.NewRequest(http.MethodPost, "/api/reindex", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reindex returned %d: %s", rec.Code, rec.Body)
+	}
+
+	var keys []string
+	for _, f := range db.files {
+		keys = append(keys, f.ObjectKey)
+		if f.ObjectKey == "clients/contract.txt" && f.FileSize != int64(len(plain)) {
+			t.Errorf("contract.txt indexed with %d bytes, want its %d plaintext bytes", f.FileSize, len(plain))
+		}
+	}
+	for _, folder := range db.folders {
+		keys = append(keys, folder)
+	}
+	if len(keys) == 0 || slices.ContainsFunc(keys, func(k string) bool { return strings.HasPrefix(k, ".encryption/") }) {
+		t.Errorf("reindex found %v, want the upload without re-encryption copies", keys)
+	}
+}
+
+func TestContentSearchOffWhenEncrypted(t *testing.T) {
+	keyring, err := envelope.ParseKeyring("test:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, cookie := newConfiguredServer(t, &encryptionDB{}, &memS3{objects: make(map[string]*memObject)}, func(s *server.Server) {
+		s.EnableEncryption(kms.NewStatic(keyring))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/search/content?q=contract", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("content search returned %d, want 409", rec.Code)
+	}
+}
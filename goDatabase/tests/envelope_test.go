@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"goDatabase/internal/envelope"
+)
+
+func encrypt(t *testing.T, plain, key []byte, version uint32) []byte {
+	t.Helper()
+	r, err := envelope.NewEncrypter(bytes.NewReader(plain), key, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func openEncrypted(sealed, key []byte) (*envelope.Reader, error) {
+	return envelope.NewReader(bytes.NewReader(sealed), int64(len(sealed)), func(uint32) ([]byte, error) {
+		return key, nil
+	})
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	key, _ := envelope.NewDataKey()
+	rng := rand.New(rand.NewSource(1))
+
+	for _, size := range []int{0, 1, envelope.ChunkSize - 1, envelope.ChunkSize, envelope.ChunkSize + 1, 3*envelope.ChunkSize + 5} {
+		plain := make([]byte, size)
+		rng.Read(plain)
+
+		sealed := encrypt(t, plain, key, 3)
+		if int64(len(sealed)) != envelope.EncryptedSize(int64(size)) {
+			t.Errorf("%d bytes encrypted to %d, EncryptedSize says %d", size, len(sealed), envelope.EncryptedSize(int64(size)))
+		}
+		if n, err := envelope.PlainSize(int64(len(sealed))); err != nil || n != int64(size) {
+			t.Errorf("PlainSize(%d) = %d, %v, want %d", len(sealed), n, err, size)
+		}
+		if size > 16 && bytes.Contains(sealed, plain[:16]) {
+			t.Errorf("%d bytes: ciphertext contains the plaintext", size)
+		}
+
+		r, err := openEncrypted(sealed, key)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if r.KeyVersion() != 3 {
+			t.Errorf("key version is %d, want 3", r.KeyVersion())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: decrypted %d bytes, %v", size, len(got), err)
+		}
+
+		// Random reads land on the right plaintext, across chunk borders too
+		for i := 0; i < 20 && size > 0; i++ {
+			off := rng.Intn(size)
+			n := rng.Intn(2*envelope.ChunkSize) + 1
+			want := plain[off:min(off+n, size)]
+			buf := make([]byte, n)
+			read, err := r.ReadAt(buf, int64(off))
+			if read != len(want) || !bytes.Equal(buf[:read], want) || (read < n && err != io.EOF) {
+				t.Fatalf("%d bytes: ReadAt(%d, %d) read %d, %v", size, n, off, read, err)
+			}
+			if _, err := r.Seek(int64(off), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(io.LimitReader(r, int64(n)))
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%d bytes: read after Seek(%d) differs", size, off)
+			}
+		}
+	}
+}
+
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	key, _ := envelope.NewDataKey()
+	plain := bytes.Repeat([]byte("confidential "), 3*envelope.ChunkSize/13)
+	sealed := encrypt(t, plain, key, 1)
+
+	readAll := func(data, key []byte) error {
+		r, err := openEncrypted(data, key)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	flipped := bytes.Clone(sealed)
+	flipped[envelope.HeaderSize+envelope.ChunkSize+100] ^= 1
+	if err := readAll(flipped, key); !errors.Is(err, envelope.ErrCorrupt) {
+		t.Errorf("flipped bit: got %v, want ErrCorrupt", err)
+	}
+
+	// Dropping whole chunks leaves a valid length but no last chunk
+	truncated := sealed[:envelope.HeaderSize+2*(envelope.ChunkSize+16)]
+	if err := readAll(truncated, key); !errors.Is(err, envelope.ErrCorrupt) {
+		t.Errorf("truncated file: got %v, want ErrCorrupt", err)
+	}
+
+	header := bytes.Clone(sealed)
+	header[10] ^= 1
+	if err := readAll(header, key); !errors.Is(err, envelope.ErrCorrupt) {
+		t.Errorf("changed salt: got %v, want ErrCorrupt", err)
+	}
+
+	other, _ := envelope.NewDataKey()
+	if err := readAll(sealed, other); !errors.Is(err, envelope.ErrCorrupt) {
+		t.Errorf("wrong key: got %v, want ErrCorrupt", err)
+	}
+
+	if _, err := openEncrypted(plain, key); err == nil {
+		t.Error("opened a plaintext file")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	old, err := envelope.ParseKeyring("2024:" + oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, _ := envelope.NewDataKey()
+	id, wrapped, err := old.Wrap(dataKey, []byte("user:7:version:1"))
+	if err != nil || id != "2024" {
+		t.Fatalf("Wrap = %q, %v", id, err)
+	}
+
+	// The new key comes first; the old one still unwraps existing keys
+	rotated, err := envelope.ParseKeyring("2025:" + newKey + ", 2024:" + oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.CurrentID() != "2025" {
+		t.Errorf("current key is %q, want 2025", rotated.CurrentID())
+	}
+	got, err := rotated.Unwrap(id, wrapped, []byte("user:7:version:1"))
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("Unwrap with the rotated keyring = %v", err)
+	}
+	if _, err := rotated.Unwrap(id, wrapped, []byte("user:8:version:1")); !errors.Is(err, envelope.ErrCorrupt) {
+		t.Errorf("unwrapping for another user: got %v, want ErrCorrupt", err)
+	}
+
+	newer, _ := envelope.ParseKeyring("2025:" + newKey)
+	if _, err := newer.Unwrap(id, wrapped, []byte("user:7:version:1")); !errors.Is(err, envelope.ErrUnknownKey) {
+		t.Errorf("unwrapping with a dropped master key: got %v, want ErrUnknownKey", err)
+	}
+
+	for _, spec := range []string{"", "nokey", "a:notbase64!", "a:" + base64.StdEncoding.EncodeToString([]byte("short")), "a:" + oldKey + ",a:" + newKey} {
+		if _, err := envelope.ParseKeyring(spec); err == nil {
+			t.Errorf("ParseKeyring(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goDatabase/internal/database"
+	"goDatabase/internal/server"
+)
+
+// fileRequestDB keeps file requests in memory and records what was indexed
+// and which notifications were sent.
+type fileRequestDB struct {
+	userDB
+	mu            sync.Mutex
+	requests      map[string]*database.FileRequest
+	indexed       []string
+	notifications []string
+}
+
+func (db *fileRequestDB) GetFileRequest(token string) (database.FileRequest, error) {
+	request, ok := db.requests[token]
+	if !ok {
+		return database.FileRequest{}, database.ErrFileRequestNotFound
+	}
+	return *request, nil
+}
+
+func (db *fileRequestDB) ReserveFileRequestBytes(requestID int, size int64) (bool, error) {
+	for _, request := range db.requests {
+		if request.RequestID == requestID {
+			if request.MaxTotalBytes > 0 && request.UploadedBytes+size > request.MaxTotalBytes {
+				return false, nil
+			}
+			request.UploadedBytes += size
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *fileRequestDB) ReleaseFileRequestBytes(requestID int, size int64) error {
+	return nil
+}
+
+func (db *fileRequestDB) UpsertFile(userID int, objectKey, contentType string, size int64, lastModified time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.indexed = append(db.indexed, objectKey)
+	return nil
+}
+
+// Content indexing runs in the background after uploads; it is not under test
+func (db *fileRequestDB) ClearFileContent(userID int, objectKey string) error { return nil }
+
+func (db *fileRequestDB) SetFileContent(userID int, objectKey, content string) error { return nil }
+
+func (db *fileRequestDB) AddNotification(userID int, kind, message, itemPath string) error {
+	db.notifications = append(db.notifications, message)
+	return nil
+}
+
+func TestFileRequestUpload(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	db := &fileRequestDB{requests: map[string]*database.FileRequest{
+		"docs":    {RequestID: 1, Token: "docs", UserID: 7, FolderPath: "clients/acme", MaxTotalBytes: 1000, AllowedTypes: []string{".pdf", "image/*"}},
+		"expired": {RequestID: 2, Token: "expired", UserID: 7, FolderPath: "clients/acme", ExpiresAt: &past},
+		"revoked": {RequestID: 3, Token: "revoked", UserID: 7, FolderPath: "clients/acme", Revoked: true},
+	}}
+
+	var mu sync.Mutex
+	var stored []string
+	var metadata http.Header
+	router, _ := newConfiguredServer(t, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			io.Copy(io.Discard, r.Body)
+			mu.Lock()
+			stored = append(stored, r.URL.Path)
+			metadata = r.Header.Clone()
+			mu.Unlock()
+			w.Header().Set("ETag", `"etag"`)
+			w.WriteHeader(http.StatusOK)
+		case http.MethodHead:
+			// The folder already holds a scan.pdf
+			if r.URL.Path == "/user-7/clients/acme/scan.pdf" {
+				w.Header().Set("ETag", `"etag"`)
+				w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>user-7</Name><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		}
+	}), func(s *server.Server) {
+		// Only the upload request may write to the bucket
+		s.DisableUploadPipeline()
+	})
+
+	upload := func(token, name string, files map[string]string) int {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		if name != "" {
+			form.WriteField("name", name)
+		}
+		for fileName, content := range files {
+			w, _ := form.CreateFormFile("files", fileName)
+			w.Write([]byte(content))
+		}
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/r/"+token, &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, tt := range []struct {
+		token, name string
+		files       map[string]string
+		want        int
+	}{
+		{"unknown", "Ann", map[string]string{"scan.pdf": "pdf"}, http.StatusNotFound},
+		{"revoked", "Ann", map[string]string{"scan.pdf": "pdf"}, http.StatusNotFound},
+		{"expired", "Ann", map[string]string{"scan.pdf": "pdf"}, http.StatusGone},
+		{"docs", "", map[string]string{"scan.pdf": "pdf"}, http.StatusBadRequest},
+		{"docs", "Ann", map[string]string{"run.exe": "exe"}, http.StatusUnsupportedMediaType},
+		{"docs", "Ann", map[string]string{"big.pdf": strings.Repeat("x", 2000)}, http.StatusRequestEntityTooLarge},
+	} {
+		if code := upload(tt.token, tt.name, tt.files); code != tt.want {
+			t.Errorf("upload to /r/%s returned %d, want %d", tt.token, code, tt.want)
+		}
+	}
+	if len(stored) != 0 || len(db.notifications) != 0 {
+		t.Fatalf("rejected uploads stored %v and sent %v", stored, db.notifications)
+	}
+
+	if code := upload("docs", "Ann Example", map[string]string{"scan.pdf": "pdf", "photo.jpg": "jpg"}); code != http.StatusOK {
+		t.Fatalf("upload returned %d, want 200", code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]bool{"/user-7/clients/acme/scan (1).pdf": true, "/user-7/clients/acme/photo.jpg": true}
+	for _, path := range stored {
+		if !want[path] {
+			t.Errorf("stored %s, want one of %v", path, want)
+		}
+	}
+	if len(stored) != 2 {
+		t.Errorf("stored %v, want 2 files", stored)
+	}
+	if len(db.indexed) != 2 {
+		t.Errorf("indexed %v, want the 2 stored files", db.indexed)
+	}
+	if got := metadata.Get("X-Amz-Meta-Uploader-Name"); got != "Ann Example" {
+		t.Errorf("uploader name metadata is %q, want %q", got, "Ann Example")
+	}
+	if len(db.notifications) != 1 || !strings.Contains(db.notifications[0], "Ann Example uploaded 2 files") {
+		t.Errorf("notifications are %v, want one about 2 files from Ann Example", db.notifications)
+	}
+	if got := db.requests["docs"].UploadedBytes; got != 6 {
+		t.Errorf("request counted %d bytes, want 6", got)
+	}
+}
+
+// movedRequestDB keeps the folders of file requests, keyed by token, and
+// moves them the way the database does.
+type movedRequestDB struct {
+	*indexDB
+	folders map[string]string
+}
+
+func (db *movedRequestDB) MoveFileRequests(userID int, oldPath, newPath string) error {
+	for token, folder := range db.folders {
+		if folder == oldPath || strings.HasPrefix(folder, oldPath+"/") {
+			db.folders[token] = newPath + strings.TrimPrefix(folder, oldPath)
+		}
+	}
+	return nil
+}
+
+func TestFileRequestFollowsMovedFolder(t *testing.T) {
+	keys := []string{"docs/", "docs/a.txt", "docs/inbox/", "docsother/", "archive/"}
+	db := &movedRequestDB{indexDB: newIndexDB("docs/a.txt"), folders: map[string]string{
+		"top": "docs", "nested": "docs/inbox", "sibling": "docsother",
+	}}
+	router, cookie, _ := newBucketServer(t, db, keys...)
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", map[string]string{"path": "docs", "newName": "papers", "type": "folder"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("renaming a folder returned %d: %s", rec.Code, rec.Body)
+	}
+	rec = sendJSON(router, cookie, http.MethodPost, "/api/moveFile", map[string]string{"sourcePath": "papers", "destinationPath": "archive", "type": "folder"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("moving a folder returned %d: %s", rec.Code, rec.Body)
+	}
+
+	want := map[string]string{"top": "archive/papers", "nested": "archive/papers/inbox", "sibling": "docsother"}
+	for token, folder := range want {
+		if db.folders[token] != folder {
+			t.Errorf("request %s uploads into %q, want %q", token, db.folders[token], folder)
+		}
+	}
+
+	// Moving a file leaves requests alone
+	rec = sendJSON(router, cookie, http.MethodPost, "/api/moveFile", map[string]string{"sourcePath": "archive/papers/a.txt", "destinationPath": "docsother", "type": "file"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("moving a file returned %d: %s", rec.Code, rec.Body)
+	}
+	if db.folders["sibling"] != "docsother" {
+		t.Errorf("moving a file changed a request folder to %q", db.folders["sibling"])
+	}
+}
@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goDatabase/internal/auth"
+	"goDatabase/internal/database"
+	"goDatabase/internal/server"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// userDB resolves the logged-in test user to ID 7 and bucket user-7 and
+// ignores the activity log. Test databases embed it for the lookups every
+// handler makes.
+type userDB struct {
+	database.Service
+}
+
+func (db *userDB) GetUserIDByEmail(email string) (int, error) { return 7, nil }
+
+func (db *userDB) GetBucketNameByEmail(email string) (string, error) { return "user-7", nil }
+
+func (db *userDB) RecordActivity(userID int, objectKey, action string) error { return nil }
+
+// newTestServer returns a router backed by db and a stand-in MinIO served by
+// s3, plus a logged-in session cookie.
+func newTestServer(t testing.TB, db database.Service, s3 http.Handler) (http.Handler, *http.Cookie) {
+	return newConfiguredServer(t, db, s3, func(*server.Server) {})
+}
+
+// newConfiguredServer is newTestServer with a chance to configure the server,
+// such as enabling encryption, before routes are registered.
+func newConfiguredServer(t testing.TB, db database.Service, s3 http.Handler, configure func(*server.Server)) (http.Handler, *http.Cookie) {
+	gin.SetMode(gin.TestMode)
+
+	s3Server := httptest.NewServer(s3)
+	t.Cleanup(s3Server.Close)
+
+	endpoint, _ := url.Parse(s3Server.URL)
+	minioClient, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4("test", "testsecret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth.Store = sessions.NewCookieStore([]byte("test-session-secret"))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := auth.Store.New(req, auth.SessionName)
+	session.Values["user_email"] = "user@example.com"
+	if err := session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	s := server.New(db, minioClient)
+	configure(s)
+	return s.RegisterRoutes(), rec.Result().Cookies()[0]
+}
+
+// newBucketServer returns a test server whose bucket holds the given
+// objects, each containing its own key.
+func newBucketServer(t *testing.T, db database.Service, keys ...string) (http.Handler, *http.Cookie, *memS3) {
+	s3 := &memS3{objects: make(map[string]*memObject)}
+	for _, key := range keys {
+		s3.objects["user-7/"+key] = &memObject{data: []byte(key), header: http.Header{}, etag: `"` + key + `"`}
+	}
+	router, cookie := newTestServer(t, db, s3)
+	return router, cookie, s3
+}
+
+// sendJSON sends body as JSON to target and returns the response.
+func sendJSON(router http.Handler, cookie *http.Cookie, method, target string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// memObject is an object held by memS3.
+type memObject struct {
+	data   []byte
+	header http.Header // Content-Type and X-Amz-Meta-* headers
+	etag   string
+}
+
+// memS3 stands in for MinIO with objects kept in memory. It handles the
+// requests the server makes: PUT (including minio-go's chunk-signed bodies),
+// GET and HEAD with ranges, listings, server-side copies and deletes.
+type memS3 struct {
+	mu      sync.Mutex
+	objects map[string]*memObject // "bucket/key"
+
+	// fail, if set, answers the requests it returns true for with a 500
+	fail func(r *http.Request) bool
+	// undeletable holds "bucket/key" paths that deletes refuse
+	undeletable map[string]bool
+}
+
+func (m *memS3) object(path string) *memObject {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.objects[strings.TrimPrefix(path, "/")]
+}
+
+// bucketKeys returns the keys stored in the test user's bucket, in order.
+func bucketKeys(s3 *memS3) []string {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	var keys []string
+	for p := range s3.objects {
+		if key, ok := strings.CutPrefix(p, "user-7/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeChunked reads an aws-chunked body: "size;chunk-signature=...\r\n"
+// followed by that many bytes and "\r\n", until a chunk of size 0.
+func decodeChunked(body io.Reader) ([]byte, error) {
+	r := bufio.NewReader(body)
+	var data []byte
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func (m *memS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+
+	if m.fail != nil && m.fail(r) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `<Error><Code>InternalError</Code><Message>injected failure</Message></Error>`)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		src := m.object(source)
+		if src == nil {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		m.mu.Lock()
+		m.objects[path] = &memObject{data: bytes.Clone(src.data), header: src.header.Clone(), etag: src.etag}
+		m.mu.Unlock()
+		io.WriteString(w, `<CopyObjectResult><ETag>`+src.etag+`</ETag><LastModified>2024-05-01T12:00:00.000Z</LastModified></CopyObjectResult>`)
+
+	case r.Method == http.MethodDelete && key != "":
+		if m.undeletable[path] {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<Error><Code>AccessDenied</Code></Error>`)
+			return
+		}
+		m.mu.Lock()
+		delete(m.objects, path)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		var request struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		type deleted struct{ Key string }
+		type deleteError struct{ Key, Code, Message string }
+		var result struct {
+			XMLName xml.Name      `xml:"DeleteResult"`
+			Deleted []deleted     `xml:"Deleted"`
+			Errors  []deleteError `xml:"Error"`
+		}
+		m.mu.Lock()
+		for _, o := range request.Objects {
+			if m.undeletable[bucket+"/"+o.Key] {
+				result.Errors = append(result.Errors, deleteError{Key: o.Key, Code: "AccessDenied", Message: "Access Denied"})
+				continue
+			}
+			delete(m.objects, bucket+"/"+o.Key)
+			result.Deleted = append(result.Deleted, deleted{Key: o.Key})
+		}
+		m.mu.Unlock()
+		xml.NewEncoder(w).Encode(result)
+
+	case r.Method == http.MethodPut && key != "":
+		var data []byte
+		var err error
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			data, err = decodeChunked(r.Body)
+		} else {
+			data, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		header := http.Header{"Content-Type": {r.Header.Get("Content-Type")}}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				header[k] = v
+			}
+		}
+		sum := md5.Sum(data)
+		obj := &memObject{data: data, header: header, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+		m.mu.Lock()
+		m.objects[path] = obj
+		m.mu.Unlock()
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case key == "" && r.Method == http.MethodGet:
+		// ListObjectsV2; one page is enough for tests
+		type metaEntry struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		}
+		type content struct {
+			Key          string
+			Size         int64
+			ETag         string
+			UserMetadata []metaEntry `xml:"UserMetadata>entry,omitempty"`
+		}
+		var result struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			IsTruncated bool
+			Contents    []content
+		}
+		result.Name = bucket
+		prefix := r.URL.Query().Get("prefix")
+		m.mu.Lock()
+		for p, obj := range m.objects {
+			if k, ok := strings.CutPrefix(p, bucket+"/"); ok && strings.HasPrefix(k, prefix) {
+				c := content{Key: k, Size: int64(len(obj.data)), ETag: obj.etag}
+				if r.URL.Query().Get("metadata") == "true" {
+					for name := range obj.header {
+						c.UserMetadata = append(c.UserMetadata, metaEntry{XMLName: xml.Name{Local: name}, Value: obj.header.Get(name)})
+					}
+				}
+				result.Contents = append(result.Contents, c)
+			}
+		}
+		m.mu.Unlock()
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+
+	case key == "":
+		// Bucket checks
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		obj := m.object(path)
+		if obj == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range obj.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", obj.etag)
+		http.ServeContent(w, r, "", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), bytes.NewReader(obj.data))
+	}
+}
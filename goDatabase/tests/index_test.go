@@ -0,0 +1,388 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goDatabase/internal/database"
+)
+
+// indexDB keeps the Folder and Files index in memory. Like the real tables
+// it keeps the IDs of moved items, which stars refer to.
+type indexDB struct {
+	userDB
+	mu      sync.Mutex
+	nextID  int
+	files   map[string]int // object key -> file ID
+	folders map[string]int // folder path -> folder ID
+	stars   map[int]bool   // starred file and folder IDs
+
+	// content holds the extracted text of files, set by the upload pipeline
+	content map[string]string
+}
+
+// newIndexDB returns an index holding the given files and the folders above
+// them.
+func newIndexDB(keys ...string) *indexDB {
+	db := &indexDB{files: make(map[string]int), folders: make(map[string]int), stars: make(map[int]bool), content: make(map[string]string)}
+	for _, key := range keys {
+		db.UpsertFile(7, key, "text/plain", 1, time.Now())
+	}
+	return db
+}
+
+// newIndexServer is newBucketServer with an index of the files among keys.
+func newIndexServer(t *testing.T, keys ...string) (http.Handler, *http.Cookie, *memS3, *indexDB) {
+	var files []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			files = append(files, key)
+		}
+	}
+	db := newIndexDB(files...)
+	router, cookie, s3 := newBucketServer(t, db, keys...)
+	return router, cookie, s3, db
+}
+
+func (db *indexDB) id() int {
+	db.nextID++
+	return db.nextID
+}
+
+// ensureParents indexes the folders above key. The caller holds the lock.
+func (db *indexDB) ensureParents(key string) {
+	for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := db.folders[dir]; !ok {
+			db.folders[dir] = db.id()
+		}
+	}
+}
+
+// fileKeys returns the indexed object keys in order.
+func (db *indexDB) fileKeys() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := make([]string, 0, len(db.files))
+	for key := range db.files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// folderKeys returns the indexed folder paths in order.
+func (db *indexDB) folderKeys() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := make([]string, 0, len(db.folders))
+	for key := range db.folders {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (db *indexDB) fileID(key string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.files[key]
+}
+
+func (db *indexDB) HasIndexedItems(userID int) (bool, error) { return true, nil }
+
+func (db *indexDB) UpsertFile(userID int, objectKey, contentType string, size int64, lastModified time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.files[objectKey]; !ok {
+		db.files[objectKey] = db.id()
+	}
+	db.ensureParents(objectKey)
+	return nil
+}
+
+func (db *indexDB) DeleteFile(userID int, objectKey string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.files, objectKey)
+	return nil
+}
+
+func (db *indexDB) MoveFile(userID int, oldKey, newKey string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if id, ok := db.files[oldKey]; ok {
+		delete(db.files, oldKey)
+		db.files[newKey] = id
+		db.ensureParents(newKey)
+	}
+	return nil
+}
+
+func (db *indexDB) CopyFile(userID int, oldKey, newKey string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.files[oldKey]; ok {
+		db.files[newKey] = db.id()
+		db.ensureParents(newKey)
+	}
+	return nil
+}
+
+func (db *indexDB) EnsureFolder(userID int, folderPath string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.ensureParents(folderPath + "/")
+	return db.folders[folderPath], nil
+}
+
+// rekey moves (or copies, with new IDs) the entries of m at or below oldPath
+// to newPath. The caller holds the lock.
+func (db *indexDB) rekey(m map[string]int, oldPath, newPath string, copy bool) {
+	for key, id := range m {
+		rest, ok := strings.CutPrefix(key, oldPath)
+		if !ok || (rest != "" && rest[0] != '/') {
+			continue
+		}
+		if copy {
+			id = db.id()
+		} else {
+			delete(m, key)
+		}
+		m[newPath+rest] = id
+	}
+}
+
+func (db *indexDB) DeleteFolder(userID int, folderPath string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, m := range []map[string]int{db.files, db.folders} {
+		for key := range m {
+			if key == folderPath || strings.HasPrefix(key, folderPath+"/") {
+				delete(m, key)
+			}
+		}
+	}
+	return nil
+}
+
+func (db *indexDB) MoveFolder(userID int, oldPath, newPath string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rekey(db.files, oldPath, newPath, false)
+	db.rekey(db.folders, oldPath, newPath, false)
+	db.ensureParents(newPath)
+	return nil
+}
+
+func (db *indexDB) CopyFolder(userID int, oldPath, newPath string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rekey(db.files, oldPath, newPath, true)
+	db.rekey(db.folders, oldPath, newPath, true)
+	db.ensureParents(newPath)
+	return nil
+}
+
+// starTarget returns the ID of the indexed file or folder at itemPath. The
+// caller holds the lock.
+func (db *indexDB) starTarget(itemPath string, isFolder bool) (int, error) {
+	m := db.files
+	if isFolder {
+		m = db.folders
+	}
+	id, ok := m[itemPath]
+	if !ok {
+		return 0, database.ErrNotIndexed
+	}
+	return id, nil
+}
+
+func (db *indexDB) StarItem(userID int, itemPath string, isFolder bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id, err := db.starTarget(itemPath, isFolder)
+	if err == nil {
+		db.stars[id] = true
+	}
+	return err
+}
+
+func (db *indexDB) UnstarItem(userID int, itemPath string, isFolder bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id, err := db.starTarget(itemPath, isFolder)
+	if err == nil {
+		delete(db.stars, id)
+	}
+	return err
+}
+
+func (db *indexDB) ListStarred(userID int) ([]database.StarredItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	items := make([]database.StarredItem, 0)
+	for itemType, m := range map[string]map[string]int{"file": db.files, "folder": db.folders} {
+		for p, id := range m {
+			if db.stars[id] {
+				items = append(items, database.StarredItem{Type: itemType, Name: path.Base(p), Path: p})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return items, nil
+}
+
+func (db *indexDB) GetVault(userID int) (database.Vault, error) {
+	return database.Vault{}, database.ErrVaultNotFound
+}
+
+func (db *indexDB) MoveShareLinks(userID int, oldPath, newPath string) error { return nil }
+
+func (db *indexDB) MoveAccessGrants(ownerID int, oldPath, newPath string) error { return nil }
+
+func (db *indexDB) MoveFileRequests(userID int, oldPath, newPath string) error { return nil }
+
+func (db *indexDB) SetFileContent(userID int, objectKey, content string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.content[objectKey] = content
+	return nil
+}
+
+func (db *indexDB) ClearFileContent(userID int, objectKey string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.content, objectKey)
+	return nil
+}
+
+func TestDeleteUpdatesIndex(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "a.txt", "docs/", "docs/b.txt", "docs/x/c.txt", "docsother/d.txt")
+
+	if rec := sendJSON(router, cookie, http.MethodPost, "/api/deleteFile", map[string]string{"path": "a.txt", "type": "file"}); rec.Code != http.StatusOK {
+		t.Fatalf("deleting a file returned %d: %s", rec.Code, rec.Body)
+	}
+	if got, want := db.fileKeys(), []string{"docs/b.txt", "docs/x/c.txt", "docsother/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v after deleting a file, want %v", got, want)
+	}
+
+	// Until the deletion is confirmed nothing is removed
+	sendJSON(router, cookie, http.MethodPost, "/api/deleteFile", map[string]string{"path": "docs", "type": "folder"})
+	if got := db.folderKeys(); !reflect.DeepEqual(got, []string{"docs", "docs/x", "docsother"}) {
+		t.Errorf("index holds folders %v before the deletion was confirmed", got)
+	}
+
+	if rec := sendJSON(router, cookie, http.MethodPost, "/api/deleteFile?confirmed=true", map[string]string{"path": "docs", "type": "folder"}); rec.Code != http.StatusOK {
+		t.Fatalf("deleting a folder returned %d: %s", rec.Code, rec.Body)
+	}
+	if got, want := db.fileKeys(), []string{"docsother/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v after deleting a folder, want %v", got, want)
+	}
+	if got, want := db.folderKeys(), []string{"docsother"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds folders %v after deleting a folder, want %v", got, want)
+	}
+	if got, want := bucketKeys(s3), []string{"docsother/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+}
+
+func TestMoveUpdatesIndex(t *testing.T) {
+	router, cookie, _, db := newIndexServer(t, "a.txt", "docs/", "docs/b.txt", "docs/x/c.txt", "docsother/d.txt", "archive/")
+	fileID, folderID := db.fileID("docs/x/c.txt"), db.folders["docs/x"]
+
+	if rec := sendJSON(router, cookie, http.MethodPost, "/api/moveFile", map[string]string{"sourcePath": "a.txt", "destinationPath": "docs", "type": "file"}); rec.Code != http.StatusOK {
+		t.Fatalf("moving a file returned %d: %s", rec.Code, rec.Body)
+	}
+	if rec := sendJSON(router, cookie, http.MethodPost, "/api/moveFile", map[string]string{"sourcePath": "docs", "destinationPath": "archive", "type": "folder"}); rec.Code != http.StatusOK {
+		t.Fatalf("moving a folder returned %d: %s", rec.Code, rec.Body)
+	}
+
+	if got, want := db.fileKeys(), []string{"archive/docs/a.txt", "archive/docs/b.txt", "archive/docs/x/c.txt", "docsother/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v, want %v", got, want)
+	}
+	if got, want := db.folderKeys(), []string{"archive", "archive/docs", "archive/docs/x", "docsother"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds folders %v, want %v", got, want)
+	}
+	// Moved rows keep their IDs, so stars and tags follow them
+	if db.fileID("archive/docs/x/c.txt") != fileID || db.folders["archive/docs/x"] != folderID {
+		t.Error("moved items did not keep their index entries")
+	}
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/moveFile", map[string]string{"sourcePath": "archive", "destinationPath": "archive/docs", "type": "folder"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("moving a folder into itself returned %d, want 400", rec.Code)
+	}
+}
+
+func TestCreateFolderUpdatesIndex(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t)
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/createFolder", map[string]string{"path": "docs", "folderName": "2024"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("creating a folder returned %d: %s", rec.Code, rec.Body)
+	}
+	if s3.object("user-7/docs/2024/") == nil {
+		t.Error("folder marker was not stored")
+	}
+	if got, want := db.folderKeys(), []string{"docs", "docs/2024"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds folders %v, want %v", got, want)
+	}
+}
+
+// brokenIndexDB fails to move index entries and records rebuilds.
+type brokenIndexDB struct {
+	*indexDB
+	rebuilt []string // object keys of the last rebuild
+	builds  int
+}
+
+func (db *brokenIndexDB) MoveFile(userID int, oldKey, newKey string) error {
+	return errors.New("index unavailable")
+}
+
+func (db *brokenIndexDB) ReindexUser(userID int, folders []string, files []database.FileRecord) error {
+	db.builds++
+	db.rebuilt = nil
+	for _, f := range files {
+		db.rebuilt = append(db.rebuilt, f.ObjectKey)
+	}
+	return nil
+}
+
+func (db *brokenIndexDB) ListFolder(userID int, folderPath string, opts database.ListOptions) ([]database.FolderRecord, []database.FileRecord, *database.ListCursor, error) {
+	return []database.FolderRecord{}, []database.FileRecord{}, nil, nil
+}
+
+func TestFailedIndexUpdateRebuildsOnListing(t *testing.T) {
+	db := &brokenIndexDB{indexDB: newIndexDB("docs/a.txt")}
+	router, cookie, _ := newBucketServer(t, db, "docs/a.txt")
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", map[string]string{"path": "docs/a.txt", "newName": "b.txt", "type": "file"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename returned %d: %s", rec.Code, rec.Body)
+	}
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "/api/listBucket?path=docs", nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("listing returned %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if db.builds != 1 {
+		t.Errorf("index was rebuilt %d times, want once", db.builds)
+	}
+	if want := []string{"docs/b.txt"}; !reflect.DeepEqual(db.rebuilt, want) {
+		t.Errorf("rebuilt index holds %v, want %v", db.rebuilt, want)
+	}
+}
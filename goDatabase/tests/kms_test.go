@@ -0,0 +1,336 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/kms"
+)
+
+// fakeTransit stands in for the transit engine of a Vault server, with one
+// AES-GCM key per version of a single transit key.
+type fakeTransit struct {
+	token string
+	key   string
+
+	mu       sync.Mutex
+	versions []cipher.AEAD
+}
+
+func newFakeTransit(t *testing.T, token, key string) (*fakeTransit, *httptest.Server) {
+	f := &fakeTransit{token: token, key: key}
+	f.addVersion()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// addVersion rotates the key. f.mu must be held once the server runs.
+func (f *fakeTransit) addVersion() {
+	key := make([]byte, 32)
+	rand.Read(key)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	f.versions = append(f.versions, gcm)
+}
+
+func vaultError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": {message}})
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != f.token {
+		vaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	var req map[string]string
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			vaultError(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+	}
+	aad, _ := base64.StdEncoding.DecodeString(req["associated_data"])
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/"+f.key:
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"latest_version": len(f.versions)}})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/keys/"+f.key+"/rotate":
+		f.addVersion()
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/encrypt/"+f.key:
+		plaintext, err := base64.StdEncoding.DecodeString(req["plaintext"])
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, "invalid plaintext")
+			return
+		}
+		version := len(f.versions)
+		nonce := make([]byte, 12)
+		rand.Read(nonce)
+		sealed := f.versions[version-1].Seal(nonce, nonce, plaintext, aad)
+		ciphertext := fmt.Sprintf("vault:v%d:%s", version, base64.StdEncoding.EncodeToString(sealed))
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": ciphertext}})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/decrypt/"+f.key:
+		var version int
+		parts := strings.SplitN(req["ciphertext"], ":", 3)
+		if len(parts) != 3 || parts[0] != "vault" {
+			vaultError(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		fmt.Sscanf(parts[1], "v%d", &version)
+		sealed, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil || version < 1 || version > len(f.versions) || len(sealed) < 12 {
+			vaultError(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		plaintext, err := f.versions[version-1].Open(nil, sealed[:12], sealed[12:], aad)
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, "cipher: message authentication failed")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}})
+
+	default:
+		vaultError(w, http.StatusNotFound, "no handler for route")
+	}
+}
+
+// checkRotation wraps with k, rotates it and checks that the old material
+// still unwraps while new material uses the new version.
+func checkRotation(t *testing.T, k kms.KMS) {
+	t.Helper()
+	ctx := context.Background()
+	aad := []byte("user:7:version:1")
+	secret, _ := envelope.NewDataKey()
+
+	current, err := k.CurrentID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID, wrapped, err := k.Wrap(ctx, secret, aad)
+	if err != nil || oldID != current {
+		t.Fatalf("Wrap = %q, %v, want key %q", oldID, err, current)
+	}
+	if bytes.Contains(wrapped, secret) {
+		t.Error("wrapped material contains the secret")
+	}
+
+	rotated, err := k.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == oldID {
+		t.Fatalf("Rotate kept key %q", rotated)
+	}
+	if current, err := k.CurrentID(ctx); err != nil || current != rotated {
+		t.Errorf("CurrentID after Rotate = %q, %v, want %q", current, err, rotated)
+	}
+
+	got, err := k.Unwrap(ctx, oldID, wrapped, aad)
+	if err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("Unwrap after rotation = %v", err)
+	}
+	if _, err := k.Unwrap(ctx, oldID, wrapped, []byte("user:8:version:1")); err == nil {
+		t.Error("unwrapped with the wrong associated data")
+	}
+	if _, err := k.Unwrap(ctx, "elsewhere:v1", wrapped, aad); !errors.Is(err, kms.ErrUnknownKey) {
+		t.Errorf("unwrapping with a foreign key: got %v, want ErrUnknownKey", err)
+	}
+
+	newID, _, err := k.Wrap(ctx, secret, aad)
+	if err != nil || newID != rotated {
+		t.Errorf("Wrap after rotation = %q, %v, want key %q", newID, err, rotated)
+	}
+}
+
+func TestFileKeyring(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+
+	if _, err := kms.OpenFile(path); err == nil {
+		t.Error("opened a keyring file that does not exist")
+	}
+	f, err := kms.CreateFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kms.CreateFile(path, nil); err == nil {
+		t.Error("CreateFile overwrote an existing keyring file")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("keyring file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// Another process, such as the kms command, opened the same file
+	other, err := kms.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, wrapped, err := other.Wrap(ctx, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkRotation(t, f)
+	if current, _ := other.CurrentID(ctx); current != "v2" {
+		t.Errorf("the other process sees current key %q after rotation, want v2", current)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("rotated keyring file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	reopened, err := kms.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Unwrap(ctx, "v1", wrapped, nil); err != nil || string(got) != "secret" {
+		t.Errorf("Unwrap of a v1 key after reopening = %q, %v", got, err)
+	}
+
+	// Imported keys keep their IDs
+	imported := filepath.Join(t.TempDir(), "keyring.json")
+	keys, err := envelope.ParseMasterKeys("2025:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)) +
+		",2024:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := kms.CreateFile(imported, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := g.CurrentID(ctx); current != "2025" {
+		t.Errorf("imported keyring current key = %q, want 2025", current)
+	}
+	static, _ := envelope.ParseKeyring("2024:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	_, wrapped, _ = static.Wrap([]byte("secret"), nil)
+	if got, err := g.Unwrap(ctx, "2024", wrapped, nil); err != nil || string(got) != "secret" {
+		t.Errorf("Unwrap of an imported key = %q, %v", got, err)
+	}
+}
+
+func TestTransit(t *testing.T) {
+	_, srv := newFakeTransit(t, "s.token", "godatabase")
+
+	if _, err := kms.NewTransit(kms.TransitConfig{Addr: srv.URL}); err == nil {
+		t.Error("NewTransit without a token succeeded")
+	}
+
+	transit, err := kms.NewTransit(kms.TransitConfig{Addr: srv.URL, Token: "s.token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, err := transit.CurrentID(context.Background()); err != nil || current != "transit:godatabase:v1" {
+		t.Fatalf("CurrentID = %q, %v", current, err)
+	}
+	checkRotation(t, transit)
+
+	denied, _ := kms.NewTransit(kms.TransitConfig{Addr: srv.URL, Token: "wrong"})
+	if _, _, err := denied.Wrap(context.Background(), []byte("secret"), nil); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Wrap with a wrong token: got %v, want Vault's error", err)
+	}
+}
+
+func TestKMSChain(t *testing.T) {
+	ctx := context.Background()
+	static, err := envelope.ParseKeyring("2024:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := kms.NewStatic(static)
+	if _, err := legacy.Rotate(ctx); !errors.Is(err, kms.ErrRotateUnsupported) {
+		t.Errorf("rotating static keys: got %v, want ErrRotateUnsupported", err)
+	}
+	aad := []byte("user:7:version:1")
+	oldID, oldWrapped, _ := legacy.Wrap(ctx, []byte("old secret"), aad)
+
+	_, srv := newFakeTransit(t, "s.token", "godatabase")
+	transit, _ := kms.NewTransit(kms.TransitConfig{Addr: srv.URL, Token: "s.token"})
+	chain := kms.Chain(transit, legacy)
+
+	// Material from before the move still unwraps, new material uses Vault
+	if got, err := chain.Unwrap(ctx, oldID, oldWrapped, aad); err != nil || string(got) != "old secret" {
+		t.Errorf("Unwrap of legacy material = %q, %v", got, err)
+	}
+	newID, newWrapped, err := chain.Wrap(ctx, []byte("new secret"), aad)
+	if err != nil || newID != "transit:godatabase:v1" {
+		t.Fatalf("Wrap = %q, %v", newID, err)
+	}
+	if got, err := chain.Unwrap(ctx, newID, newWrapped, aad); err != nil || string(got) != "new secret" {
+		t.Errorf("Unwrap of new material = %q, %v", got, err)
+	}
+	if _, err := chain.Unwrap(ctx, "2023", oldWrapped, aad); !errors.Is(err, kms.ErrUnknownKey) {
+		t.Errorf("unwrapping with a key neither has: got %v, want ErrUnknownKey", err)
+	}
+	if rotated, err := chain.Rotate(ctx); err != nil || rotated != "transit:godatabase:v2" {
+		t.Errorf("Rotate = %q, %v", rotated, err)
+	}
+}
+
+func TestKMSChainSharedKeyID(t *testing.T) {
+	ctx := context.Background()
+	spec := "v1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	static, err := envelope.ParseKeyring(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := []byte("user:7:version:1")
+	keyID, wrapped, _ := static.Wrap([]byte("old secret"), aad)
+
+	// "kms init" names its first key v1 as well, but it is another key
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	file, err := kms.CreateFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := kms.Chain(file, kms.NewStatic(static))
+	if got, err := chain.Unwrap(ctx, keyID, wrapped, aad); err != nil || string(got) != "old secret" {
+		t.Errorf("Unwrap of legacy material under a shared key ID = %q, %v", got, err)
+	}
+	if _, err := chain.Unwrap(ctx, keyID, wrapped, []byte("user:8:version:1")); !errors.Is(err, envelope.ErrCorrupt) {
+		t.Errorf("Unwrap with the wrong associated data: got %v, want ErrCorrupt", err)
+	}
+
+	// Such a setup would never rewrap the legacy keys, so it is refused
+	t.Setenv("KMS_BACKEND", "file")
+	t.Setenv("KMS_KEYRING_FILE", path)
+	t.Setenv("ENCRYPTION_MASTER_KEYS", spec)
+	if _, err := kms.FromEnv(ctx); err == nil || !strings.Contains(err.Error(), `"v1"`) {
+		t.Errorf("FromEnv with a shared key ID: got %v, want an error naming it", err)
+	}
+
+	// An imported keyring holds the same keys under the same IDs
+	imported := filepath.Join(t.TempDir(), "keyring.json")
+	keys, _ := envelope.ParseMasterKeys(spec)
+	if _, err := kms.CreateFile(imported, keys); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KMS_KEYRING_FILE", imported)
+	k, err := kms.FromEnv(ctx)
+	if err != nil {
+		t.Fatalf("FromEnv with an imported keyring = %v", err)
+	}
+	if got, err := k.Unwrap(ctx, keyID, wrapped, aad); err != nil || string(got) != "old secret" {
+		t.Errorf("Unwrap with an imported keyring = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"goDatabase/internal/database"
+)
+
+// listingDB serves a folder of generated files from the metadata index, in
+// name order and paged by object key. Only the methods used by listBucket are
+// implemented.
+type listingDB struct {
+	userDB
+	files int
+
+	opts database.ListOptions // of the last ListFolder call
+}
+
+func (db *listingDB) HasIndexedItems(userID int) (bool, error) { return true, nil }
+
+func (db *listingDB) ListFolder(userID int, folderPath string, opts database.ListOptions) ([]database.FolderRecord, []database.FileRecord, *database.ListCursor, error) {
+	db.opts = opts
+	files := make([]database.FileRecord, 0, db.files)
+	for i := 0; i < db.files; i++ {
+		key := fmt.Sprintf("docs/file-%04d.txt", i)
+		if opts.After != nil && key <= opts.After.Path {
+			continue
+		}
+		if opts.Limit > 0 && len(files) == opts.Limit {
+			last := files[len(files)-1]
+			return []database.FolderRecord{}, files, &database.ListCursor{Kind: 1, Value: last.FileName, Path: last.ObjectKey}, nil
+		}
+		files = append(files, database.FileRecord{
+			FileID:       i + 1,
+			FileName:     fmt.Sprintf("file-%04d.txt", i),
+			ObjectKey:    key,
+			FileType:     "text/plain",
+			FileSize:     int64(i),
+			LastModified: time.Now(),
+		})
+	}
+	return []database.FolderRecord{}, files, nil, nil
+}
+
+// newListingServer returns a test server listing a folder of generated files
+// and a counter of the requests MinIO receives.
+func newListingServer(t testing.TB, files int) (http.Handler, *http.Cookie, *int64) {
+	var calls int64
+	router, cookie := newTestServer(t, &listingDB{files: files}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	return router, cookie, &calls
+}
+
+func listFolder(t testing.TB, router http.Handler, cookie *http.Cookie) int {
+	req := httptest.NewRequest(http.MethodGet, "/api/listBucket?path=docs", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listBucket returned %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Files []map[string]interface{} `json:"files"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return len(body.Files)
+}
+
+// A listing costs one MinIO call (the bucket check) no matter how many
+// objects the folder holds.
+func TestListBucketMinioCalls(t *testing.T) {
+	for _, files := range []int{0, 1, 500} {
+		router, cookie, calls := newListingServer(t, files)
+
+		if n := listFolder(t, router, cookie); n != files {
+			t.Fatalf("listBucket returned %d entries, want %d", n, files)
+		}
+		if got := atomic.LoadInt64(calls); got != 1 {
+			t.Errorf("listing %d files made %d MinIO calls, want 1", files, got)
+		}
+	}
+}
+
+// Clients that do not page get the whole folder, however large.
+func TestListBucketUnpagedByDefault(t *testing.T) {
+	db := &listingDB{files: 1500}
+	router, cookie := newTestServer(t, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if n := listFolder(t, router, cookie); n != 1500 {
+		t.Errorf("listBucket without a limit returned %d entries, want 1500", n)
+	}
+	if db.opts.Limit != 0 {
+		t.Errorf("listBucket without a limit asked for pages of %d", db.opts.Limit)
+	}
+}
+
+// listPage fetches one page of a listing and returns its status, file names
+// and next token.
+func listPage(router http.Handler, cookie *http.Cookie, query string) (int, []string, string) {
+	req := httptest.NewRequest(http.MethodGet, "/api/listBucket?"+query, nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var body struct {
+		Files []struct {
+			Name string `json:"name"`
+		} `json:"files"`
+		NextToken string `json:"nextToken"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	names := make([]string, 0, len(body.Files))
+	for _, f := range body.Files {
+		names = append(names, f.Name)
+	}
+	return rec.Code, names, body.NextToken
+}
+
+func TestListBucketPagesWithToken(t *testing.T) {
+	router, cookie := newTestServer(t, &listingDB{files: 5}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var names []string
+	query := "path=docs&limit=2"
+	for pages := 1; ; pages++ {
+		code, page, token := listPage(router, cookie, query)
+		if code != http.StatusOK {
+			t.Fatalf("page %d returned %d", pages, code)
+		}
+		names = append(names, page...)
+		if token == "" {
+			if pages != 3 {
+				t.Errorf("listing took %d pages, want 3", pages)
+			}
+			break
+		}
+		if pages == 3 {
+			t.Fatal("last page returned a token")
+		}
+		query = "path=docs&limit=2&token=" + url.QueryEscape(token)
+	}
+
+	var want []string
+	for i := 0; i < 5; i++ {
+		want = append(want, fmt.Sprintf("file-%04d.txt", i))
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("pages returned %v, want %v", names, want)
+	}
+}
+
+// A token only continues the listing it came from.
+func TestListBucketTokenBoundToListing(t *testing.T) {
+	router, cookie := newTestServer(t, &listingDB{files: 5}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	_, _, token := listPage(router, cookie, "path=docs&limit=2&tag=work")
+	if token == "" {
+		t.Fatal("first page returned no token")
+	}
+	token = url.QueryEscape(token)
+
+	if code, _, _ := listPage(router, cookie, "path=docs&tag=work&token="+token); code != http.StatusOK {
+		t.Errorf("token of the same listing returned %d, want 200", code)
+	}
+	for _, query := range []string{
+		"path=other&tag=work",
+		"path=docs&tag=work&sort=size",
+		"path=docs&tag=work&order=desc",
+		"path=docs",
+		"path=docs&tag=work&tag=urgent",
+	} {
+		if code, _, _ := listPage(router, cookie, query+"&token="+token); code != http.StatusBadRequest {
+			t.Errorf("token reused with %q returned %d, want 400", query, code)
+		}
+	}
+	for _, bad := range []string{"not-base64!", "e30"} { // "e30" is "{}"
+		if code, _, _ := listPage(router, cookie, "path=docs&token="+bad); code != http.StatusBadRequest {
+			t.Errorf("token %q returned %d, want 400", bad, code)
+		}
+	}
+}
+
+func BenchmarkListBucket500(b *testing.B) {
+	router, cookie, calls := newListingServer(b, 500)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		listFolder(b, router, cookie)
+	}
+	b.StopTimer()
+
+	perListing := float64(atomic.LoadInt64(calls)) / float64(b.N)
+	b.ReportMetric(perListing, "minio-calls/op")
+	if perListing != 1 {
+		b.Errorf("made %.2f MinIO calls per listing, want 1", perListing)
+	}
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenameFile(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "docs/a.txt", "docs/c.txt")
+	id := db.fileID("docs/a.txt")
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", map[string]string{"path": "docs/a.txt", "newName": "b.txt", "type": "file"})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"newPath":"docs/b.txt"`) {
+		t.Fatalf("rename returned %d: %s", rec.Code, rec.Body)
+	}
+	if got, want := bucketKeys(s3), []string{"docs/b.txt", "docs/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+	if obj := s3.object("user-7/docs/b.txt"); obj == nil || string(obj.data) != "docs/a.txt" {
+		t.Error("renamed file lost its content")
+	}
+	if db.fileID("docs/b.txt") != id {
+		t.Error("renamed file did not keep its index entry")
+	}
+}
+
+func TestRenameFolder(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "docs/", "docs/x/", "docs/x/a.txt", "docs/b.txt", "docsother/c.txt")
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", map[string]string{"path": "docs", "newName": "papers", "type": "folder"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename returned %d: %s", rec.Code, rec.Body)
+	}
+	want := []string{"docsother/c.txt", "papers/", "papers/b.txt", "papers/x/", "papers/x/a.txt"}
+	if got := bucketKeys(s3); !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want %v", got, want)
+	}
+	if got, want := db.fileKeys(), []string{"docsother/c.txt", "papers/b.txt", "papers/x/a.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v, want %v", got, want)
+	}
+}
+
+func TestRenameRefused(t *testing.T) {
+	router, cookie, s3, _ := newIndexServer(t, "docs/a.txt", "docs/c.txt", "docs/sub/d.txt")
+
+	for _, tt := range []struct {
+		path, newName, itemType string
+		want                    int
+	}{
+		{"docs/a.txt", "c.txt", "file", http.StatusConflict},
+		{"docs/a.txt", "sub", "file", http.StatusConflict}, // a folder has that name
+		{"docs/a.txt", "x/y.txt", "file", http.StatusBadRequest},
+		{"docs/a.txt", "..", "file", http.StatusBadRequest},
+		{"docs/a.txt", "", "file", http.StatusBadRequest},
+		{"docs/a.txt", "a.txt", "file", http.StatusBadRequest},
+		{"docs/missing.txt", "b.txt", "file", http.StatusNotFound},
+		{"docs/missing", "other", "folder", http.StatusNotFound},
+	} {
+		rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", map[string]string{"path": tt.path, "newName": tt.newName, "type": tt.itemType})
+		if rec.Code != tt.want {
+			t.Errorf("renaming %s to %q returned %d, want %d", tt.path, tt.newName, rec.Code, tt.want)
+		}
+	}
+	if got, want := bucketKeys(s3), []string{"docs/a.txt", "docs/c.txt", "docs/sub/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v, want it unchanged", got)
+	}
+}
+
+func TestRenameFolderRollback(t *testing.T) {
+	router, cookie, s3, db := newIndexServer(t, "docs/", "docs/a.txt", "docs/b.txt", "docs/c.txt")
+	s3.fail = func(r *http.Request) bool {
+		return r.Method == http.MethodPut && r.URL.Path == "/user-7/papers/c.txt"
+	}
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", map[string]string{"path": "docs", "newName": "papers", "type": "folder"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("rename returned %d, want 500", rec.Code)
+	}
+	want := []string{"docs/", "docs/a.txt", "docs/b.txt", "docs/c.txt"}
+	if got := bucketKeys(s3); !reflect.DeepEqual(got, want) {
+		t.Errorf("bucket holds %v after a failed rename, want %v", got, want)
+	}
+	if got, want := db.fileKeys(), []string{"docs/a.txt", "docs/b.txt", "docs/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index holds %v after a failed rename, want %v", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"goDatabase/internal/database"
+)
+
+// searchDB records the filters the search handler sends to the index.
+type searchDB struct {
+	userDB
+	search   *database.FileSearch
+	hasVault bool
+}
+
+func (db *searchDB) GetVault(userID int) (database.Vault, error) {
+	if !db.hasVault {
+		return database.Vault{}, database.ErrVaultNotFound
+	}
+	return database.Vault{UserID: userID}, nil
+}
+
+func (db *searchDB) SearchFiles(userID int, search database.FileSearch) ([]database.FileRecord, int, error) {
+	db.search = &search
+	return []database.FileRecord{}, 0, nil
+}
+
+// searchFor runs a search and returns the status and the filters it used.
+func searchFor(router http.Handler, cookie *http.Cookie, db *searchDB, query string) (int, *database.FileSearch) {
+	db.search = nil
+	req := httptest.NewRequest(http.MethodGet, "/api/search?"+query, nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code, db.search
+}
+
+func TestSearchFilters(t *testing.T) {
+	db := &searchDB{}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	for _, tt := range []struct {
+		query string
+		want  database.FileSearch
+	}{
+		{"q=report", database.FileSearch{Name: "report", Limit: 50}},
+		// Globs are passed on as they are and matched against whole names
+		{"q=report-*.pdf", database.FileSearch{Name: "report-*.pdf", Limit: 50}},
+		{"q=%20a%3Fc%20", database.FileSearch{Name: "a?c", Limit: 50}},
+		{"type=Image", database.FileSearch{ContentType: "image/", Limit: 50}},
+		{"type=application/pdf", database.FileSearch{ContentType: "application/pdf", Limit: 50}},
+		{"minSize=10&maxSize=2000", database.FileSearch{MinSize: 10, MaxSize: 2000, Limit: 50}},
+		{
+			"modifiedAfter=2024-05-01&modifiedBefore=2024-05-31",
+			database.FileSearch{
+				ModifiedAfter:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+				ModifiedBefore: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), // the whole last day
+				Limit:          50,
+			},
+		},
+		{
+			"modifiedBefore=2024-05-31T10:00:00Z",
+			database.FileSearch{ModifiedBefore: time.Date(2024, 5, 31, 10, 0, 0, 0, time.UTC), Limit: 50},
+		},
+		{"folder=/docs/2024/", database.FileSearch{Folder: "docs/2024/", Limit: 50}},
+		{"page=3&pageSize=20", database.FileSearch{Limit: 20, Offset: 40}},
+		{"pageSize=5000", database.FileSearch{Limit: 200}},
+	} {
+		code, got := searchFor(router, cookie, db, tt.query)
+		if code != http.StatusOK || got == nil {
+			t.Errorf("search %q returned %d", tt.query, code)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("search %q used %+v, want %+v", tt.query, *got, tt.want)
+		}
+	}
+}
+
+func TestSearchSkipsLockedVault(t *testing.T) {
+	db := &searchDB{hasVault: true}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	code, got := searchFor(router, cookie, db, "q=secret")
+	if code != http.StatusOK || got == nil {
+		t.Fatalf("search returned %d", code)
+	}
+	if got.ExcludeFolder != "Vault/" {
+		t.Errorf("search of a locked vault excluded %q, want Vault/", got.ExcludeFolder)
+	}
+}
+
+func TestSearchRejectsInvalidFilters(t *testing.T) {
+	db := &searchDB{}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	for _, query := range []string{
+		"minSize=big",
+		"maxSize=-1",
+		"modifiedAfter=yesterday",
+		"modifiedBefore=2024-13-01",
+		"page=0",
+		"pageSize=x",
+	} {
+		code, got := searchFor(router, cookie, db, query)
+		if code != http.StatusBadRequest {
+			t.Errorf("search %q returned %d, want 400", query, code)
+		}
+		if got != nil {
+			t.Errorf("search %q reached the index", query)
+		}
+	}
+}
+
+// contentSearchDB answers full-text searches with one match and records the
+// arguments it was called with.
+type contentSearchDB struct {
+	userDB
+	text, folder  string
+	limit, offset int
+}
+
+func (db *contentSearchDB) SearchFileContent(userID int, text, folder string, limit, offset int) ([]database.ContentMatch, int, error) {
+	db.text, db.folder, db.limit, db.offset = text, folder, limit, offset
+	match := database.ContentMatch{
+		FileRecord: database.FileRecord{FileName: "invoice.pdf", ObjectKey: "docs/invoice.pdf", FileType: "application/pdf"},
+		Snippet:    "Quarterly <mark>invoice</mark> for client-x &lt;draft&gt;",
+		Rank:       0.5,
+	}
+	return []database.ContentMatch{match}, 1, nil
+}
+
+func TestContentSearchSnippets(t *testing.T) {
+	db := &contentSearchDB{}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/search/content?q=%20invoice%20&folder=/docs/&page=2&pageSize=10", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("content search returned %d: %s", rec.Code, rec.Body)
+	}
+	if db.text != "invoice" || db.folder != "docs/" || db.limit != 10 || db.offset != 10 {
+		t.Errorf("index searched %q in %q with limit %d offset %d", db.text, db.folder, db.limit, db.offset)
+	}
+
+	var response struct {
+		Results []struct {
+			Path    string
+			Snippet string
+			Rank    float64
+		}
+		Total int
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Total != 1 || len(response.Results) != 1 {
+		t.Fatalf("content search returned %+v", response)
+	}
+	// The snippet is passed on as the escaped HTML the index built
+	result := response.Results[0]
+	if want := "Quarterly <mark>invoice</mark> for client-x &lt;draft&gt;"; result.Path != "docs/invoice.pdf" || result.Snippet != want {
+		t.Errorf("result is %+v, want docs/invoice.pdf with snippet %q", result, want)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/search/content?q=%20", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty content search returned %d, want 400", rec.Code)
+	}
+}
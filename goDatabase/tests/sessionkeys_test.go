@@ -0,0 +1,281 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"goDatabase/internal/auth"
+	"goDatabase/internal/kms"
+)
+
+// sealSession encodes a session cookie holding email with store.
+func sealSession(t *testing.T, store *sessions.CookieStore, email string) string {
+	t.Helper()
+	values := map[interface{}]interface{}{"user_email": email}
+	cookie, err := securecookie.EncodeMulti(auth.SessionName, values, store.Codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie
+}
+
+// openSession decodes a session cookie with store and returns its email.
+func openSession(store *sessions.CookieStore, cookie string) (string, error) {
+	values := map[interface{}]interface{}{}
+	if err := securecookie.DecodeMulti(auth.SessionName, cookie, &values, store.Codecs...); err != nil {
+		return "", err
+	}
+	email, _ := values["user_email"].(string)
+	return email, nil
+}
+
+// readable reports whether email can be read from cookie without any key.
+func readable(cookie, email string) bool {
+	raw, _ := base64.URLEncoding.DecodeString(cookie)
+	_, value, _ := bytes.Cut(raw, []byte("|"))
+	value, _, _ = bytes.Cut(value, []byte("|"))
+	decoded, _ := base64.URLEncoding.DecodeString(string(value))
+	return bytes.Contains(decoded, []byte(email))
+}
+
+func TestSessionKeysFromEnv(t *testing.T) {
+	legacyStore := sessions.NewCookieStore([]byte("legacy-secret"))
+	oldStore, _ := auth.NewSessionStore([]auth.SessionKey{{Secret: []byte("old-secret")}})
+
+	legacyCookie := sealSession(t, legacyStore, "legacy@example.com")
+	oldCookie := sealSession(t, oldStore, "old@example.com")
+
+	t.Setenv("SESSION_SECRETS", "new-secret, old-secret")
+	t.Setenv("SESSION_SECRET", "legacy-secret")
+	keys, err := auth.SessionKeysFromEnv()
+	if err != nil || len(keys) != 3 {
+		t.Fatalf("SessionKeysFromEnv = %d keys, %v, want 3", len(keys), err)
+	}
+	store, _ := auth.NewSessionStore(keys)
+
+	// Rotating SESSION_SECRETS keeps everyone logged in
+	for cookie, want := range map[string]string{legacyCookie: "legacy@example.com", oldCookie: "old@example.com"} {
+		if got, err := openSession(store, cookie); err != nil || got != want {
+			t.Errorf("opening a cookie of %s = %q, %v", want, got, err)
+		}
+	}
+
+	// New cookies are sealed with the current key and encrypted
+	cookie := sealSession(t, store, "new@example.com")
+	if readable(cookie, "new@example.com") {
+		t.Error("the session cookie is not encrypted")
+	}
+	if !readable(legacyCookie, "legacy@example.com") {
+		t.Error("readable does not see into a signed-only cookie")
+	}
+	if _, err := openSession(oldStore, cookie); err == nil {
+		t.Error("a new cookie opened with only the previous key")
+	}
+	current, _ := auth.NewSessionStore(keys[:1])
+	if got, err := openSession(current, cookie); err != nil || got != "new@example.com" {
+		t.Errorf("opening a new cookie with the current key = %q, %v", got, err)
+	}
+
+	// SESSION_SECRET on its own still works, now encrypting
+	t.Setenv("SESSION_SECRETS", "")
+	keys, err = auth.SessionKeysFromEnv()
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("SessionKeysFromEnv with only SESSION_SECRET = %d keys, %v, want 2", len(keys), err)
+	}
+	store, _ = auth.NewSessionStore(keys)
+	if got, err := openSession(store, legacyCookie); err != nil || got != "legacy@example.com" {
+		t.Errorf("opening a legacy cookie = %q, %v", got, err)
+	}
+	if readable(sealSession(t, store, "new@example.com"), "new@example.com") {
+		t.Error("cookies sealed with SESSION_SECRET alone are not encrypted")
+	}
+
+	t.Setenv("SESSION_SECRET", "")
+	if _, err := auth.SessionKeysFromEnv(); err == nil {
+		t.Error("SessionKeysFromEnv without any secret succeeded")
+	}
+}
+
+func TestSessionKeys(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyring, err := kms.CreateFile(filepath.Join(dir, "keyring.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "session_keys.json")
+
+	// Moving to the session keys file keeps cookies signed with SESSION_SECRET
+	t.Setenv("SESSION_SECRET", "legacy-secret")
+	legacyCookie := sealSession(t, sessions.NewCookieStore([]byte("legacy-secret")), "legacy@example.com")
+
+	keys, err := auth.LoadSessionKeys(ctx, keyring, path)
+	if err != nil || len(keys) != 2 || len(keys[0].Secret) != 32 || !keys[1].Legacy {
+		t.Fatalf("LoadSessionKeys = %+v, %v, want a new key and the legacy one", keys, err)
+	}
+	stored, _ := os.ReadFile(path)
+	if bytes.Contains(stored, []byte(base64.StdEncoding.EncodeToString(keys[0].Secret))) || bytes.Contains(stored, []byte("legacy-secret")) {
+		t.Error("session keys file holds a secret unwrapped")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("session keys file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	store, ring := auth.NewSessionStore(keys)
+	if got, err := openSession(store, legacyCookie); err != nil || got != "legacy@example.com" {
+		t.Errorf("opening a legacy cookie = %q, %v", got, err)
+	}
+	cookie := sealSession(t, store, "user@example.com")
+
+	again, err := auth.LoadSessionKeys(ctx, keyring, path)
+	if err != nil || len(again) != 2 || !bytes.Equal(again[0].Secret, keys[0].Secret) {
+		t.Fatalf("second LoadSessionKeys = %v, different keys or error", err)
+	}
+
+	// Rotating the session keys keeps earlier cookies valid
+	rotated, err := auth.RotateSessionKeys(ctx, keyring, path)
+	if err != nil || len(rotated) != 3 || bytes.Equal(rotated[0].Secret, keys[0].Secret) {
+		t.Fatalf("RotateSessionKeys = %d keys, %v, want a new current key and two old ones", len(rotated), err)
+	}
+	if rotated[1].Retired.IsZero() {
+		t.Error("the replaced key is not marked retired")
+	}
+	ring.Set(rotated)
+	for c, want := range map[string]string{cookie: "user@example.com", legacyCookie: "legacy@example.com"} {
+		if got, err := openSession(store, c); err != nil || got != want {
+			t.Errorf("opening a cookie of %s after rotation = %q, %v", want, got, err)
+		}
+	}
+	newCookie := sealSession(t, store, "user@example.com")
+	before, _ := auth.NewSessionStore(keys)
+	if _, err := openSession(before, newCookie); err == nil {
+		t.Error("a cookie sealed after rotation opened with the keys from before")
+	}
+
+	// Keys retired longer ago than a cookie lives are dropped on the next
+	// rotation
+	var file map[string][]map[string]interface{}
+	stored, _ = os.ReadFile(path)
+	if err := json.Unmarshal(stored, &file); err != nil {
+		t.Fatal(err)
+	}
+	file["keys"][2]["retired"] = time.Now().Add(-auth.SessionKeyRetention - time.Hour).UTC().Format(time.RFC3339)
+	stored, _ = json.Marshal(file)
+	os.WriteFile(path, stored, 0o600)
+	rotated, err = auth.RotateSessionKeys(ctx, keyring, path)
+	if err != nil || len(rotated) != 3 {
+		t.Fatalf("RotateSessionKeys = %d keys, %v, want 3", len(rotated), err)
+	}
+	for _, k := range rotated {
+		if k.Legacy {
+			t.Error("the expired legacy key was kept")
+		}
+	}
+
+	// After a master key rotation the session keys stay the same but are
+	// rewrapped
+	if _, err := keyring.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	again, err = auth.LoadSessionKeys(ctx, keyring, path)
+	if err != nil || !bytes.Equal(again[0].Secret, rotated[0].Secret) {
+		t.Fatalf("LoadSessionKeys after master key rotation = %v, different keys or error", err)
+	}
+	stored, _ = os.ReadFile(path)
+	if strings.Count(string(stored), `"masterKeyId": "v2"`) != len(again) {
+		t.Errorf("session keys are not all rewrapped with v2:\n%s", stored)
+	}
+
+	// A different KMS cannot read them
+	foreign, _ := kms.CreateFile(filepath.Join(t.TempDir(), "keyring.json"), nil)
+	if _, err := auth.LoadSessionKeys(ctx, foreign, path); err == nil {
+		t.Error("another keyring unwrapped the session keys")
+	}
+}
+
+func TestSessionKeysOlderFormat(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyring, err := kms.CreateFile(filepath.Join(dir, "keyring.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single wrapped secret that only signed cookies
+	secret := bytes.Repeat([]byte{9}, 32)
+	keyID, wrapped, err := keyring.Wrap(ctx, secret, []byte("session secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "session_keys.json")
+	data, _ := json.Marshal(map[string]interface{}{"masterKeyId": keyID, "wrapped": wrapped})
+	os.WriteFile(path, data, 0o600)
+	cookie := sealSession(t, sessions.NewCookieStore(secret), "user@example.com")
+
+	keys, err := auth.LoadSessionKeys(ctx, keyring, path)
+	if err != nil || len(keys) != 2 || !keys[1].Legacy || !bytes.Equal(keys[1].Secret, secret) {
+		t.Fatalf("LoadSessionKeys of the older format = %+v, %v", keys, err)
+	}
+	store, _ := auth.NewSessionStore(keys)
+	if got, err := openSession(store, cookie); err != nil || got != "user@example.com" {
+		t.Errorf("opening a cookie signed with the older secret = %q, %v", got, err)
+	}
+	if readable(sealSession(t, store, "user@example.com"), "user@example.com") {
+		t.Error("new cookies are not encrypted")
+	}
+}
+
+func TestSessionKeysConcurrentRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyring, err := kms.CreateFile(filepath.Join(dir, "keyring.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "session_keys.json")
+	if _, err := auth.LoadSessionKeys(ctx, keyring, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The kms command from cron and the server rotating at the same time
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := auth.RotateSessionKeys(ctx, keyring, path)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("RotateSessionKeys = %v", err)
+		}
+	}
+
+	if _, err := auth.LoadSessionKeys(ctx, keyring, path); err != nil {
+		t.Errorf("LoadSessionKeys after concurrent rotations = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files left behind: %v", names)
+	}
+}
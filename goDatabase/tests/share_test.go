@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"goDatabase/internal/database"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// shareDB keeps share links in memory and records the access log.
+type shareDB struct {
+	database.Service
+	links    map[string]*database.ShareLink
+	outcomes []string
+}
+
+func (db *shareDB) GetShareLink(token string) (database.ShareLink, error) {
+	link, ok := db.links[token]
+	if !ok {
+		return database.ShareLink{}, database.ErrShareNotFound
+	}
+	return *link, nil
+}
+
+func (db *shareDB) ClaimShareDownload(linkID int) (bool, error) {
+	for _, link := range db.links {
+		if link.LinkID == linkID {
+			if link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
+				return false, nil
+			}
+			link.DownloadCount++
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *shareDB) LogShareAccess(linkID int, ipAddress, userAgent, outcome string) error {
+	db.outcomes = append(db.outcomes, outcome)
+	return nil
+}
+
+func TestPublicShareLink(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	db := &shareDB{links: map[string]*database.ShareLink{
+		"secret":  {LinkID: 1, Token: "secret", UserID: 7, ItemPath: "videos/clip.mp4", ItemType: "file", PasswordHash: string(hash), HasPassword: true, MaxDownloads: 1},
+		"expired": {LinkID: 2, Token: "expired", UserID: 7, ItemPath: "videos/clip.mp4", ItemType: "file", ExpiresAt: &past},
+		"revoked": {LinkID: 3, Token: "revoked", UserID: 7, ItemPath: "videos/clip.mp4", ItemType: "file", Revoked: true},
+	}}
+	router, _ := newTestServer(t, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user-7/videos/clip.mp4" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", downloadETag)
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "", time.Now(), bytes.NewReader(downloadContent))
+	}))
+
+	access := func(token, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/s/"+token, nil)
+		if password != "" {
+			form := url.Values{"password": {password}}
+			req = httptest.NewRequest(http.MethodPost, "/s/"+token, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tt := range []struct {
+		token, password string
+		want            int
+	}{
+		{"unknown", "", http.StatusNotFound},
+		{"revoked", "", http.StatusNotFound},
+		{"expired", "", http.StatusGone},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "wrong", http.StatusUnauthorized},
+		{"secret", "hunter2", http.StatusOK},
+		{"secret", "hunter2", http.StatusGone}, // the one download is used up
+	} {
+		rec := access(tt.token, tt.password)
+		if rec.Code != tt.want {
+			t.Errorf("/s/%s with password %q returned %d, want %d", tt.token, tt.password, rec.Code, tt.want)
+		}
+		if rec.Code == http.StatusOK && !bytes.Equal(rec.Body.Bytes(), downloadContent) {
+			t.Errorf("/s/%s returned %q, want the file", tt.token, rec.Body.Bytes())
+		}
+	}
+
+	want := []string{"revoked", "expired", "password_required", "wrong_password", "download", "limit_reached"}
+	if !reflect.DeepEqual(db.outcomes, want) {
+		t.Errorf("access log is %v, want %v", db.outcomes, want)
+	}
+}
+
+func TestShareDownloadClaimedOnlyWhenSent(t *testing.T) {
+	db := &shareDB{links: map[string]*database.ShareLink{
+		"once": {LinkID: 1, Token: "once", UserID: 7, ItemPath: "videos/clip.mp4", ItemType: "file", MaxDownloads: 1},
+	}}
+	router, _ := newTestServer(t, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", downloadETag)
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "", time.Now(), bytes.NewReader(downloadContent))
+	}))
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/s/once", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(downloadETag); rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional request returned %d, want 304", rec.Code)
+	}
+	if db.links["once"].DownloadCount != 0 {
+		t.Fatal("a 304 used up the download")
+	}
+	if rec := get(""); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), downloadContent) {
+		t.Fatalf("download returned %d, want the file", rec.Code)
+	}
+	rec := get("")
+	if rec.Code != http.StatusGone || bytes.Contains(rec.Body.Bytes(), downloadContent) || rec.Header().Get("Content-Disposition") != "" {
+		t.Errorf("second download returned %d with %q, want 410 without the file", rec.Code, rec.Body.Bytes())
+	}
+}
+
+func TestSharePasswordThrottled(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &shareDB{links: map[string]*database.ShareLink{
+		"secret": {LinkID: 1, Token: "secret", UserID: 7, ItemPath: "videos/clip.mp4", ItemType: "file", PasswordHash: string(hash), HasPassword: true},
+		"other":  {LinkID: 2, Token: "other", UserID: 7, ItemPath: "videos/clip.mp4", ItemType: "file", PasswordHash: string(hash), HasPassword: true},
+	}}
+	router, _ := newTestServer(t, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", downloadETag)
+		http.ServeContent(w, r, "", time.Now(), bytes.NewReader(downloadContent))
+	}))
+
+	accessFrom := func(clientIP, token, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/s/"+token, nil)
+		req.RemoteAddr = clientIP + ":1234"
+		req.Header.Set("X-Share-Password", password)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	access := func(token, password string) *httptest.ResponseRecorder {
+		return accessFrom("192.0.2.1", token, password)
+	}
+
+	for i := 0; i < 5; i++ {
+		if rec := access("secret", "guess"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d returned %d, want 401", i+1, rec.Code)
+		}
+	}
+	rec := access("secret", "hunter2")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("password after 5 wrong ones returned %d, want 429 with Retry-After", rec.Code)
+	}
+	if rec := access("other", "hunter2"); rec.Code != http.StatusOK {
+		t.Errorf("another link returned %d, want 200", rec.Code)
+	}
+	if got := db.outcomes[len(db.outcomes)-2]; got != "throttled" {
+		t.Errorf("throttled attempt was logged as %q", got)
+	}
+
+	// Other clients still get in with the right password
+	if rec := accessFrom("198.51.100.2", "secret", "hunter2"); rec.Code != http.StatusOK {
+		t.Errorf("another client returned %d, want 200", rec.Code)
+	}
+
+	// Guesses spread over many clients add up for the link
+	for i := 0; i < 45; i++ {
+		accessFrom(fmt.Sprintf("203.0.113.%d", i/5), "secret", "guess")
+	}
+	if rec := accessFrom("198.51.100.3", "secret", "hunter2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("password after 50 wrong ones from many clients returned %d, want 429", rec.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// starredPaths returns the type and path of every starred item.
+func starredPaths(t *testing.T, router http.Handler, cookie *http.Cookie) []string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/starred", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("starred returned %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Items []struct{ Type, Path string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, 0, len(body.Items))
+	for _, item := range body.Items {
+		paths = append(paths, item.Type+" "+item.Path)
+	}
+	return paths
+}
+
+func TestStarsSurviveRenames(t *testing.T) {
+	router, cookie, _, _ := newIndexServer(t, "docs/", "docs/a.txt", "docs/x/b.txt", "notes.txt")
+
+	for _, item := range []map[string]string{
+		{"path": "notes.txt", "type": "file"},
+		{"path": "docs/x/b.txt", "type": "file"},
+		{"path": "docs/x", "type": "folder"},
+	} {
+		if rec := sendJSON(router, cookie, http.MethodPost, "/api/star", item); rec.Code != http.StatusOK {
+			t.Fatalf("starring %s returned %d: %s", item["path"], rec.Code, rec.Body)
+		}
+	}
+
+	for _, rename := range []map[string]string{
+		{"path": "notes.txt", "newName": "todo.txt", "type": "file"},
+		{"path": "docs", "newName": "papers", "type": "folder"},
+	} {
+		if rec := sendJSON(router, cookie, http.MethodPost, "/api/rename", rename); rec.Code != http.StatusOK {
+			t.Fatalf("renaming %s returned %d: %s", rename["path"], rec.Code, rec.Body)
+		}
+	}
+
+	want := []string{"folder papers/x", "file papers/x/b.txt", "file todo.txt"}
+	if got := starredPaths(t, router, cookie); !reflect.DeepEqual(got, want) {
+		t.Errorf("starred items are %v, want %v", got, want)
+	}
+
+	if rec := sendJSON(router, cookie, http.MethodDelete, "/api/star", map[string]string{"path": "papers/x", "type": "folder"}); rec.Code != http.StatusOK {
+		t.Fatalf("unstarring returned %d: %s", rec.Code, rec.Body)
+	}
+	want = []string{"file papers/x/b.txt", "file todo.txt"}
+	if got := starredPaths(t, router, cookie); !reflect.DeepEqual(got, want) {
+		t.Errorf("starred items after unstarring are %v, want %v", got, want)
+	}
+}
+
+func TestStarRefused(t *testing.T) {
+	router, cookie, _, _ := newIndexServer(t, "docs/a.txt")
+
+	for _, tt := range []struct {
+		path, itemType string
+		want           int
+	}{
+		{"docs/missing.txt", "file", http.StatusNotFound},
+		{"docs/a.txt", "folder", http.StatusNotFound},
+		{"/", "file", http.StatusBadRequest},
+		{"docs/a.txt", "link", http.StatusBadRequest},
+	} {
+		rec := sendJSON(router, cookie, http.MethodPost, "/api/star", map[string]string{"path": tt.path, "type": tt.itemType})
+		if rec.Code != tt.want {
+			t.Errorf("starring %s %q returned %d, want %d", tt.itemType, tt.path, rec.Code, tt.want)
+		}
+	}
+}
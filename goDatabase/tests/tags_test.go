@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tagDB records the tags the tag handlers send to the index.
+type tagDB struct {
+	searchDB
+	added []string
+}
+
+func (db *tagDB) AddTags(userID int, objectKeys, tags []string) error {
+	db.added = tags
+	return nil
+}
+
+func (db *tagDB) GetFileTags(userID int, objectKeys []string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+// The index returns only files carrying every tag of the filter; these tests
+// check that all of the tags reach it.
+
+func TestListingTagFilter(t *testing.T) {
+	db := &listingDB{files: 3}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"tag=work&tag=urgent", []string{"work", "urgent"}},
+		{"tag=work,urgent", []string{"work", "urgent"}},
+		{"tag=%20Work%20,URGENT&tag=work", []string{"work", "urgent"}},
+		{"tag=", nil},
+	} {
+		db.opts.Tags = []string{"stale"}
+		req := httptest.NewRequest(http.MethodGet, "/api/listBucket?path=docs&"+tt.query, nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("listing with %q returned %d", tt.query, rec.Code)
+			continue
+		}
+		if !reflect.DeepEqual(db.opts.Tags, tt.want) {
+			t.Errorf("listing with %q filtered by %q, want %q", tt.query, db.opts.Tags, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/listBucket?path=docs&tag="+strings.Repeat("x", 65), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("listing with an over-long tag returned %d, want 400", rec.Code)
+	}
+}
+
+func TestSearchTagFilter(t *testing.T) {
+	db := &searchDB{}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	code, got := searchFor(router, cookie, db, "tag=Work&tag=urgent,work")
+	if code != http.StatusOK || got == nil {
+		t.Fatalf("search returned %d", code)
+	}
+	if want := []string{"work", "urgent"}; !reflect.DeepEqual(got.Tags, want) {
+		t.Errorf("search filtered by %q, want %q", got.Tags, want)
+	}
+}
+
+func TestAddTagsNormalizesNames(t *testing.T) {
+	db := &tagDB{}
+	router, cookie := newTestServer(t, db, &memS3{objects: make(map[string]*memObject)})
+
+	rec := sendJSON(router, cookie, http.MethodPost, "/api/tags/add", map[string][]string{
+		"paths": {"docs/a.txt"},
+		"tags":  {" Work", "work", "URGENT "},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("adding tags returned %d: %s", rec.Code, rec.Body)
+	}
+	if want := []string{"work", "urgent"}; !reflect.DeepEqual(db.added, want) {
+		t.Errorf("added tags %q, want %q", db.added, want)
+	}
+
+	for _, tags := range [][]string{{""}, {"a,b"}, {"tab\there"}, {strings.Repeat("x", 65)}} {
+		db.added = nil
+		rec := sendJSON(router, cookie, http.MethodPost, "/api/tags/add", map[string][]string{"paths": {"docs/a.txt"}, "tags": tags})
+		if rec.Code != http.StatusBadRequest || db.added != nil {
+			t.Errorf("adding tags %q returned %d, want 400", tags, rec.Code)
+		}
+	}
+}
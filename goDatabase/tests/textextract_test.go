@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"bytes"
+	"goDatabase/internal/textextract"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExtractPlainText(t *testing.T) {
+	data := []byte("# Notes\nquarterly invoice for client-x\n")
+	text, err := textextract.Extract("notes.md", "", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != string(data) {
+		t.Errorf("Extract returned %q, want %q", text, data)
+	}
+}
+
+func TestExtractRejectsBinary(t *testing.T) {
+	data := []byte("looks like text\x00\x01\x02")
+	if _, err := textextract.Extract("data.txt", "", bytes.NewReader(data), int64(len(data))); err != textextract.ErrUnsupported {
+		t.Errorf("Extract returned %v, want ErrUnsupported", err)
+	}
+	if _, err := textextract.Extract("photo.jpg", "image/jpeg", bytes.NewReader(data), int64(len(data))); err != textextract.ErrUnsupported {
+		t.Errorf("Extract returned %v, want ErrUnsupported", err)
+	}
+}
+
+func TestExtractPDF(t *testing.T) {
+	data, err := os.ReadFile("testdata/invoice.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PDFs are recognised by extension or by content type
+	for _, tt := range []struct{ name, contentType string }{
+		{"invoice.pdf", ""},
+		{"scan", "application/pdf"},
+	} {
+		text, err := textextract.Extract(tt.name, tt.contentType, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("Extract(%q, %q) failed: %v", tt.name, tt.contentType, err)
+		}
+		if want := "Quarterly invoice for client-x"; strings.TrimSpace(text) != want {
+			t.Errorf("Extract(%q, %q) returned %q, want %q", tt.name, tt.contentType, text, want)
+		}
+	}
+
+	// A damaged file is an error, not a panic
+	broken := data[:len(data)/2]
+	if _, err := textextract.Extract("invoice.pdf", "", bytes.NewReader(broken), int64(len(broken))); err == nil {
+		t.Error("Extract of a truncated PDF succeeded")
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	// Files over the limit are refused before anything is read
+	data := []byte("small")
+	if _, err := textextract.Extract("big.txt", "", bytes.NewReader(data), textextract.MaxFileSize+1); err == nil {
+		t.Error("Extract of a file over MaxFileSize succeeded")
+	}
+
+	long := bytes.Repeat([]byte("a"), textextract.MaxTextSize+100)
+	text, err := textextract.Extract("long.txt", "", bytes.NewReader(long), int64(len(long)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(text) != textextract.MaxTextSize {
+		t.Errorf("Extract kept %d bytes, want %d", len(text), textextract.MaxTextSize)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"goDatabase/internal/thumbnail"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerateThumbnails(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1000, 400))
+	for x := 0; x < 1000; x++ {
+		src.Set(x, 0, color.NRGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	thumbs, err := thumbnail.Generate(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range thumbnail.Sizes {
+		img, err := jpeg.Decode(bytes.NewReader(thumbs[size]))
+		if err != nil {
+			t.Fatalf("thumbnail %d is not a JPEG: %v", size, err)
+		}
+		// Wide images fit the box horizontally and keep their aspect ratio
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size*2/5 {
+			t.Errorf("thumbnail %d is %dx%d, want %dx%d", size, b.Dx(), b.Dy(), size, size*2/5)
+		}
+	}
+}
+
+func TestGenerateRejectsNonImages(t *testing.T) {
+	if _, err := thumbnail.Generate(bytes.NewReader([]byte("not an image"))); err != thumbnail.ErrUnsupported {
+		t.Errorf("Generate returned %v, want ErrUnsupported", err)
+	}
+}
+
+// pngObject is a stored PNG of the given width for memS3.
+func pngObject(t *testing.T, width int) *memObject {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, 100))); err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(buf.Bytes())
+	return &memObject{data: buf.Bytes(), header: http.Header{"Content-Type": {"image/png"}}, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+}
+
+func TestThumbnailHandler(t *testing.T) {
+	s3 := &memS3{objects: map[string]*memObject{
+		"user-7/photos/a.png": pngObject(t, 400),
+		"user-8/photos/b.png": pngObject(t, 400),
+	}}
+	router, cookie := newTestServer(t, &downloadDB{}, s3)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/thumbnail/"+path, nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	width := func(rec *httptest.ResponseRecorder) int {
+		img, err := jpeg.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("thumbnail is not a JPEG: %v", err)
+		}
+		return img.Bounds().Dx()
+	}
+
+	rec := get("photos/a.png")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("thumbnail returned %d: %s", rec.Code, rec.Body.String())
+	}
+	width(rec)
+	if s3.object("thumbnails/user-7/256/photos/a.png") == nil {
+		t.Fatal("the thumbnail was not stored")
+	}
+
+	// A thumbnail of an image that was replaced is rendered again
+	s3.mu.Lock()
+	s3.objects["user-7/photos/a.png"] = pngObject(t, 100)
+	s3.mu.Unlock()
+	if rec := get("photos/a.png"); rec.Code != http.StatusOK || width(rec) != 100 {
+		t.Errorf("thumbnail of a replaced image was not rendered again")
+	}
+
+	// Keys that leave the user's folder are refused, not passed to storage
+	for _, path := range []string{"photos/../../user-8/photos/b.png", "photos/./a.png"} {
+		if rec := get(path); rec.Code != http.StatusBadRequest {
+			t.Errorf("thumbnail of %s returned %d, want 400", path, rec.Code)
+		}
+	}
+	if rec := get("photos/missing.png"); rec.Code != http.StatusNotFound {
+		t.Errorf("thumbnail of a missing image returned %d, want 404", rec.Code)
+	}
+}
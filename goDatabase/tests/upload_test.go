@@ -0,0 +1,301 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// uploadFile is one file of a test upload, with its optional relative path.
+type uploadFile struct {
+	name, relativePath, content string
+}
+
+// postUpload sends files to /api/uploadFile with the given form fields and
+// decodes the response.
+func postUpload(t *testing.T, router http.Handler, cookie *http.Cookie, fields map[string]string, files ...uploadFile) (int, map[string]json.RawMessage) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range fields {
+		form.WriteField(k, v)
+	}
+	for _, f := range files {
+		if f.relativePath != "" {
+			form.WriteField("relativePaths", f.relativePath)
+		}
+		w, _ := form.CreateFormFile("files", f.name)
+		w.Write([]byte(f.content))
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/uploadFile", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var response map[string]json.RawMessage
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec.Code, response
+}
+
+// stringList decodes a JSON list of strings, treating a missing one as empty.
+func stringList(t *testing.T, raw json.RawMessage) []string {
+	t.Helper()
+	list := []string{}
+	if raw != nil {
+		if err := json.Unmarshal(raw, &list); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return list
+}
+
+func newUploadServer(t *testing.T, existing ...string) (http.Handler, *http.Cookie, *memS3) {
+	s3 := &memS3{objects: make(map[string]*memObject)}
+	for _, key := range existing {
+		s3.objects["user-7/"+key] = &memObject{data: []byte("old"), header: http.Header{}, etag: `"old"`}
+	}
+	router, cookie := newTestServer(t, newIndexDB(), s3)
+	return router, cookie, s3
+}
+
+func TestUploadConflictPolicies(t *testing.T) {
+	router, cookie, s3 := newUploadServer(t, "docs/a.txt", "docs/b.txt", "docs/c.txt", "docs/d.txt")
+
+	code, response := postUpload(t, router, cookie, map[string]string{
+		"path":      "docs",
+		"conflicts": `{"b.txt": "keepBoth", "c.txt": "skip", "d.txt": "fail"}`,
+	},
+		uploadFile{name: "a.txt", content: "new a"},
+		uploadFile{name: "b.txt", content: "new b"},
+		uploadFile{name: "c.txt", content: "new c"},
+		uploadFile{name: "d.txt", content: "new d"},
+		uploadFile{name: "e.txt", content: "new e"},
+	)
+	if code != http.StatusOK {
+		t.Fatalf("upload returned %d", code)
+	}
+
+	if got, want := stringList(t, response["uploaded_files"]), []string{"docs/a.txt", "docs/b (1).txt", "docs/e.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("uploaded_files are %v, want %v", got, want)
+	}
+	if got, want := stringList(t, response["failed_files"]), []string{"docs/c.txt", "docs/d.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("failed_files are %v, want %v", got, want)
+	}
+
+	var conflicts []struct{ File, Path, Action string }
+	json.Unmarshal(response["conflicts"], &conflicts)
+	actions := make(map[string]string)
+	for _, c := range conflicts {
+		actions[c.File] = c.Action + " " + c.Path
+	}
+	wantActions := map[string]string{
+		"a.txt": "replaced docs/a.txt",
+		"b.txt": "renamed docs/b (1).txt",
+		"c.txt": "skipped docs/c.txt",
+		"d.txt": "failed docs/d.txt",
+	}
+	if !reflect.DeepEqual(actions, wantActions) {
+		t.Errorf("conflicts are %v, want %v", actions, wantActions)
+	}
+
+	for key, want := range map[string]string{
+		"docs/a.txt":     "new a",
+		"docs/b.txt":     "old",
+		"docs/b (1).txt": "new b",
+		"docs/c.txt":     "old",
+		"docs/d.txt":     "old",
+		"docs/e.txt":     "new e",
+	} {
+		if obj := s3.object("user-7/" + key); obj == nil || string(obj.data) != want {
+			t.Errorf("%s does not hold %q", key, want)
+		}
+	}
+
+	// A second clash keeps counting up
+	postUpload(t, router, cookie, map[string]string{"path": "docs", "conflict": "keepBoth"}, uploadFile{name: "b.txt", content: "newer b"})
+	if obj := s3.object("user-7/docs/b (2).txt"); obj == nil || string(obj.data) != "newer b" {
+		t.Error(`second keepBoth upload was not stored as "b (2).txt"`)
+	}
+}
+
+func TestUploadSameNameTwice(t *testing.T) {
+	router, cookie, s3 := newUploadServer(t, "docs/b.txt")
+
+	for _, tt := range []struct {
+		name     string
+		fields   map[string]string
+		uploaded []string
+	}{
+		// An upload never replaces another file of the same request
+		{"a.txt", map[string]string{"path": "docs"}, []string{"docs/a.txt", "docs/a (1).txt"}},
+		{"b.txt", map[string]string{"path": "docs", "conflict": "keepBoth"}, []string{"docs/b (1).txt", "docs/b (2).txt"}},
+	} {
+		code, response := postUpload(t, router, cookie, tt.fields,
+			uploadFile{name: tt.name, content: "first"},
+			uploadFile{name: tt.name, content: "second"},
+		)
+		if code != http.StatusOK {
+			t.Fatalf("upload returned %d", code)
+		}
+		if got := stringList(t, response["uploaded_files"]); !reflect.DeepEqual(got, tt.uploaded) {
+			t.Errorf("uploaded_files are %v, want %v", got, tt.uploaded)
+		}
+		for i, key := range tt.uploaded {
+			want := []string{"first", "second"}[i]
+			if obj := s3.object("user-7/" + key); obj == nil || string(obj.data) != want {
+				t.Errorf("%s does not hold %q", key, want)
+			}
+		}
+	}
+
+	// A skipped duplicate leaves the first file in place
+	code, response := postUpload(t, router, cookie, map[string]string{"path": "docs", "conflict": "skip"},
+		uploadFile{name: "c.txt", content: "first"},
+		uploadFile{name: "c.txt", content: "second"},
+	)
+	if code != http.StatusOK {
+		t.Fatalf("upload returned %d", code)
+	}
+	if got := stringList(t, response["failed_files"]); !reflect.DeepEqual(got, []string{"docs/c.txt"}) {
+		t.Errorf("failed_files are %v, want the skipped docs/c.txt", got)
+	}
+	if obj := s3.object("user-7/docs/c.txt"); obj == nil || string(obj.data) != "first" {
+		t.Error("skipped duplicate replaced docs/c.txt")
+	}
+}
+
+func TestUploadSkippedFilesCreateNoFolders(t *testing.T) {
+	// The folder exists only as a prefix, without a marker object
+	router, cookie, s3 := newUploadServer(t, "docs/old/c.txt")
+
+	code, response := postUpload(t, router, cookie, map[string]string{"path": "docs", "conflict": "skip"},
+		uploadFile{name: "c.txt", relativePath: "old/c.txt", content: "new c"},
+	)
+	if code != http.StatusOK {
+		t.Fatalf("upload returned %d", code)
+	}
+	if got := stringList(t, response["uploaded_files"]); len(got) != 0 {
+		t.Errorf("uploaded_files are %v, want none", got)
+	}
+	if got := stringList(t, response["failed_files"]); !reflect.DeepEqual(got, []string{"docs/old/c.txt"}) {
+		t.Errorf("failed_files are %v, want the skipped docs/old/c.txt", got)
+	}
+	if got := stringList(t, response["created_folders"]); len(got) != 0 {
+		t.Errorf("created folders %v for a skipped file", got)
+	}
+	if s3.object("user-7/docs/") != nil || s3.object("user-7/docs/old/") != nil {
+		t.Error("folder markers were stored for a skipped file")
+	}
+}
+
+func TestUploadConflictPoliciesByRelativePath(t *testing.T) {
+	router, cookie, s3 := newUploadServer(t, "docs/old/c.txt", "docs/c.txt")
+
+	// Per-file policies of folder uploads are keyed by relative path
+	code, response := postUpload(t, router, cookie, map[string]string{
+		"path":      "docs",
+		"conflict":  "skip",
+		"conflicts": `{"old/c.txt": "keepBoth"}`,
+	},
+		uploadFile{name: "c.txt", relativePath: "old/c.txt", content: "new c"},
+		uploadFile{name: "c.txt", relativePath: "c.txt", content: "new root c"},
+	)
+	if code != http.StatusOK {
+		t.Fatalf("upload returned %d", code)
+	}
+	if got := stringList(t, response["uploaded_files"]); !reflect.DeepEqual(got, []string{"docs/old/c (1).txt"}) {
+		t.Errorf("uploaded_files are %v, want docs/old/c (1).txt", got)
+	}
+	if got := stringList(t, response["failed_files"]); !reflect.DeepEqual(got, []string{"docs/c.txt"}) {
+		t.Errorf("failed_files are %v, want the skipped docs/c.txt", got)
+	}
+	if obj := s3.object("user-7/docs/c.txt"); obj == nil || string(obj.data) != "old" {
+		t.Error("skipped upload replaced docs/c.txt")
+	}
+}
+
+func TestUploadRejectsUnknownConflictPolicy(t *testing.T) {
+	router, cookie, s3 := newUploadServer(t)
+
+	for _, fields := range []map[string]string{
+		{"conflict": "merge"},
+		{"conflicts": `{"a.txt": "merge"}`},
+		{"conflicts": `not json`},
+	} {
+		if code, _ := postUpload(t, router, cookie, fields, uploadFile{name: "a.txt", content: "a"}); code != http.StatusBadRequest {
+			t.Errorf("upload with %v returned %d, want 400", fields, code)
+		}
+	}
+	if s3.object("user-7/a.txt") != nil {
+		t.Error("upload with an unknown policy was stored")
+	}
+}
+
+func TestUploadRejectsInvalidRelativePaths(t *testing.T) {
+	router, cookie, s3 := newUploadServer(t)
+
+	for _, relativePath := range []string{
+		"../escape.txt",
+		"a/../../escape.txt",
+		`..\escape.txt`,
+		"a//b.txt",
+		"a/./b.txt",
+		strings.Repeat("a/", 32) + "deep.txt",
+	} {
+		code, _ := postUpload(t, router, cookie, map[string]string{"path": "docs"},
+			uploadFile{name: "f.txt", relativePath: relativePath, content: "x"},
+		)
+		if code != http.StatusBadRequest {
+			t.Errorf("relative path %q returned %d, want 400", relativePath, code)
+		}
+	}
+
+	// Every file needs a relative path once any file has one
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("relativePaths", "a/f.txt")
+	for range 2 {
+		w, _ := form.CreateFormFile("files", "f.txt")
+		w.Write([]byte("x"))
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/uploadFile", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("upload with too few relative paths returned %d, want 400", rec.Code)
+	}
+
+	if keys := bucketKeys(s3); len(keys) != 0 {
+		t.Errorf("invalid uploads stored %v", keys)
+	}
+}
+
+func TestUploadCleansRelativePaths(t *testing.T) {
+	router, cookie, s3 := newUploadServer(t)
+
+	code, response := postUpload(t, router, cookie, map[string]string{"path": "/docs/"},
+		uploadFile{name: "a.jpg", relativePath: `photos\2024\a.jpg`, content: "a"},
+		uploadFile{name: "b.txt", relativePath: "/notes/b.txt/", content: "b"},
+	)
+	if code != http.StatusOK {
+		t.Fatalf("upload returned %d", code)
+	}
+	want := []string{"docs/photos/2024/a.jpg", "docs/notes/b.txt"}
+	if got := stringList(t, response["uploaded_files"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("uploaded_files are %v, want %v", got, want)
+	}
+	if s3.object("user-7/docs/photos/2024/") == nil {
+		t.Error("no folder marker was stored for a new folder")
+	}
+}
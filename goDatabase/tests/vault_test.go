@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goDatabase/internal/auth"
+	"goDatabase/internal/database"
+	"goDatabase/internal/envelope"
+	"goDatabase/internal/kms"
+	"goDatabase/internal/server"
+)
+
+// vaultDB adds vault keys and folder indexing to encryptionDB.
+type vaultDB struct {
+	encryptionDB
+	vaultMu sync.Mutex
+	vaults  map[int]database.Vault
+}
+
+func (db *vaultDB) GetVault(userID int) (database.Vault, error) {
+	db.vaultMu.Lock()
+	defer db.vaultMu.Unlock()
+	v, ok := db.vaults[userID]
+	if !ok {
+		return database.Vault{}, database.ErrVaultNotFound
+	}
+	return v, nil
+}
+
+func (db *vaultDB) CreateVault(userID int, wrappedKey []byte, masterKeyID string) error {
+	db.vaultMu.Lock()
+	defer db.vaultMu.Unlock()
+	if _, ok := db.vaults[userID]; ok {
+		return database.ErrVaultExists
+	}
+	db.vaults[userID] = database.Vault{UserID: userID, WrappedKey: wrappedKey, MasterKeyID: masterKeyID}
+	return nil
+}
+
+func (db *vaultDB) EnsureFolder(userID int, folderPath string) (int, error) { return 1, nil }
+
+func TestVault(t *testing.T) {
+	keyring, err := envelope.ParseKeyring("test:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("face-secret")
+	db := &vaultDB{vaults: make(map[int]database.Vault)}
+	s3 := &memS3{objects: make(map[string]*memObject)}
+	router, lockedCookie := newConfiguredServer(t, db, s3, func(s *server.Server) {
+		s.EnableEncryption(kms.NewStatic(keyring))
+		s.EnableFaceVerification(secret)
+	})
+
+	verification := func(userID int, issued time.Time, nonce string, secret []byte) string {
+		token, err := auth.SignFaceVerification(auth.FaceVerification{UserID: userID, IssuedAt: issued, Nonce: nonce}, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	send := func(method, target string, body jsonObject, cookie *http.Cookie) *httptest.ResponseRecorder {
+		var r *http.Request
+		if body != nil {
+			data, _ := json.Marshal(body)
+			r = httptest.NewRequest(method, target, bytes.NewReader(data))
+			r.Header.Set("Content-Type", "application/json")
+		} else {
+			r = httptest.NewRequest(method, target, nil)
+		}
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+	sessionCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == auth.SessionName {
+				return c
+			}
+		}
+		t.Fatal("unlocking did not update the session")
+		return nil
+	}
+
+	// Only fresh verifications signed for this user create the vault
+	claims, _, _ := strings.Cut(verification(7, time.Now(), "d", []byte("guess")), ".")
+	_, signature, _ := strings.Cut(verification(8, time.Now(), "d", secret), ".")
+	for name, tc := range map[string]struct {
+		token string
+		want  int
+	}{
+		"malformed":      {"not-a-token", http.StatusUnauthorized},
+		"other secret":   {verification(7, time.Now(), "a", []byte("guess")), http.StatusUnauthorized},
+		"stale":          {verification(7, time.Now().Add(-5*time.Minute), "b", secret), http.StatusUnauthorized},
+		"other user":     {verification(8, time.Now(), "c", secret), http.StatusForbidden},
+		"swapped claims": {claims + "." + signature, http.StatusUnauthorized},
+	} {
+		if rec := send(http.MethodPost, "/api/vault", jsonObject{"verification": tc.token}, lockedCookie); rec.Code != tc.want {
+			t.Errorf("%s verification: got %d, want %d: %s", name, rec.Code, tc.want, rec.Body)
+		}
+	}
+	if len(db.vaults) != 0 {
+		t.Fatal("a rejected verification created a vault")
+	}
+
+	token := verification(7, time.Now(), "first-scan", secret)
+	rec := send(http.MethodPost, "/api/vault", jsonObject{"verification": token}, lockedCookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("creating the vault returned %d: %s", rec.Code, rec.Body)
+	}
+	unlockedCookie := sessionCookie(rec)
+
+	// A verification unlocks once
+	if rec := send(http.MethodPost, "/api/vault/unlock", jsonObject{"verification": token}, lockedCookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed verification returned %d", rec.Code)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("path", "Vault")
+	w, _ := form.CreateFormFile("files", "passport.txt")
+	w.Write([]byte("passport number 123456"))
+	form.Close()
+	upload := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/uploadFile", bytes.NewReader(body.Bytes()))
+		r.Header.Set("Content-Type", form.FormDataContentType())
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := upload(lockedCookie); rec.Code != http.StatusLocked {
+		t.Errorf("upload into the locked vault returned %d", rec.Code)
+	}
+	if rec := upload(unlockedCookie); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "failed_files") {
+		t.Fatalf("upload into the unlocked vault returned %d: %s", rec.Code, rec.Body)
+	}
+	stored := s3.object("user-7/Vault/passport.txt")
+	if stored == nil {
+		t.Fatal("vault upload was not stored")
+	}
+	if bytes.Contains(stored.data, []byte("passport")) || stored.header.Get("X-Amz-Meta-Encryption-Key") != "vault" {
+		t.Errorf("vault file is not encrypted with the vault key: %v", stored.header)
+	}
+
+	download := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		return send(http.MethodGet, "/api/downloadFile/Vault/passport.txt", nil, cookie)
+	}
+	if rec := download(unlockedCookie); rec.Code != http.StatusOK || rec.Body.String() != "passport number 123456" {
+		t.Errorf("unlocked download returned %d %q", rec.Code, rec.Body)
+	}
+
+	// Without the unlock, vault items can be neither listed nor read
+	for _, target := range []string{
+		"/api/downloadFile/Vault/passport.txt",
+		"/api/listBucket?path=Vault",
+		"/api/downloadZip?path=Vault",
+		"/api/thumbnail/Vault/passport.txt",
+	} {
+		if rec := send(http.MethodGet, target, nil, lockedCookie); rec.Code < 400 || strings.Contains(rec.Body.String(), "passport number") {
+			t.Errorf("locked GET %s returned %d", target, rec.Code)
+		}
+	}
+
+	// Nothing leaves the vault under a weaker key
+	if rec := send(http.MethodPost, "/api/moveFile", jsonObject{"sourcePath": "Vault/passport.txt", "destinationPath": "", "type": "file"}, unlockedCookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("moving out of the vault returned %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/api/shares", jsonObject{"path": "Vault/passport.txt", "type": "file"}, unlockedCookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("sharing a vault file returned %d", rec.Code)
+	}
+
+	// Locking forgets the key; a new scan brings it back
+	if rec := send(http.MethodPost, "/api/vault/lock", nil, unlockedCookie); rec.Code != http.StatusOK {
+		t.Fatalf("locking returned %d", rec.Code)
+	}
+	if rec := download(unlockedCookie); rec.Code != http.StatusLocked {
+		t.Errorf("download after locking returned %d", rec.Code)
+	}
+	rec = send(http.MethodPost, "/api/vault/unlock", jsonObject{"verification": verification(7, time.Now(), "second-scan", secret)}, unlockedCookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlocking returned %d: %s", rec.Code, rec.Body)
+	}
+	if rec := download(sessionCookie(rec)); rec.Code != http.StatusOK {
+		t.Errorf("download after unlocking again returned %d", rec.Code)
+	}
+}
+
+// jsonObject is a JSON request body.
+type jsonObject map[string]string
@@ -9,6 +9,12 @@ import json
 from datetime import datetime
 import aiohttp_cors
 from cryptoFunctions import UserEncryption
+import base64
+import hashlib
+import hmac
+import os
+import secrets
+import time
 
 
 async def hello(request):
@@ -69,6 +75,24 @@ async def cookieInfo(cookie):
             return None
 
 
+def signFaceVerification(userID):
+    # Signed proof of a successful scan, which the Go server accepts once to
+    # unlock the user's vault. Format: base64url(claims).base64url(HMAC-SHA256)
+    secret = os.getenv("FACE_VERIFICATION_SECRET")
+    if not secret:
+        return None
+
+    claims = {
+        "sub": userID,
+        "iat": int(time.time()),
+        "nonce": secrets.token_urlsafe(16),
+        "purpose": "face_verified",
+    }
+    payload = base64.urlsafe_b64encode(json.dumps(claims).encode()).rstrip(b"=").decode()
+    signature = hmac.new(secret.encode(), payload.encode(), hashlib.sha256).digest()
+    return payload + "." + base64.urlsafe_b64encode(signature).rstrip(b"=").decode()
+
+
 async def firstFaceScan(request):
     # Parse the incoming form data
     cookie_value = request.headers.get("Cookie")  # Extract cookie from incoming request
@@ -128,7 +152,8 @@ async def firstFaceScan(request):
 
                 return web.json_response({
                     "message": "New user successfully created",
-                    "redirect_url": "http://localhost:8000/files"
+                    "redirect_url": "http://localhost:8000/files",
+                    "verification": signFaceVerification(userID)
                 })
             else:
                 print("THEY GOT FACE ALREADY")
@@ -146,7 +171,8 @@ async def firstFaceScan(request):
 
                     return web.json_response({
                         "message": "Face Scan Successful!",
-                        "redirect_url": "http://localhost:8000/files"
+                        "redirect_url": "http://localhost:8000/files",
+                        "verification": signFaceVerification(userID)
                      })
                 else:
                     return web.Response(text="Face Scan not Successful", status=400)